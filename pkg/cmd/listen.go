@@ -134,7 +134,7 @@ func (lc *listenCmd) runListenCmd(cmd *cobra.Command, args []string) error {
 		return errors.New("--load-from-webhooks-api requires a location to forward to with --forward-to")
 	}
 
-	p := proxy.New(&proxy.Config{
+	p, err := proxy.New(&proxy.Config{
 		DeviceName:          deviceName,
 		Key:                 key,
 		EndpointRoutes:      endpointRoutes,
@@ -146,6 +146,9 @@ func (lc *listenCmd) runListenCmd(cmd *cobra.Command, args []string) error {
 		Log:                 log.StandardLogger(),
 		NoWSS:               lc.noWSS,
 	})
+	if err != nil {
+		return err
+	}
 
 	err = p.Run()
 	if err != nil {
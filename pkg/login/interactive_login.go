@@ -127,7 +127,7 @@ func protectTerminalState() (chan os.Signal, error) {
 		return nil, err
 	}
 
-	signalChan := make(chan os.Signal)
+	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, os.Interrupt)
 	go func() {
 		<-signalChan
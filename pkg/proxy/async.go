@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"context"
+)
+
+//
+// Public types
+//
+
+// PostResult is the outcome of an event posted with PostAsync.
+type PostResult struct {
+	WebhookID string
+
+	// StatusCode is the status code of the endpoint's response, or zero if
+	// there is none
+	StatusCode int
+
+	Err error
+}
+
+// PostAsync enqueues the event to be posted by one of the client's workers
+// and returns a channel that receives the result once the event was
+// processed. It blocks while all the workers are busy, until one of them is
+// available or the context is done, in which case the result carries the
+// context's error.
+func (c *EndpointClient) PostAsync(ctx context.Context, webhookID string, body string, headers map[string]string) <-chan PostResult {
+	results := make(chan PostResult, 1)
+
+	c.startWorkers.Do(func() {
+		for i := 0; i < c.workers(); i++ {
+			go c.work()
+		}
+	})
+
+	job := &asyncJob{
+		ctx:       ctx,
+		webhookID: webhookID,
+		body:      body,
+		headers:   headers,
+		results:   results,
+	}
+
+	select {
+	case c.jobs <- job:
+	case <-ctx.Done():
+		results <- PostResult{WebhookID: webhookID, Err: ctx.Err()}
+	case <-c.workersDone:
+		results <- PostResult{WebhookID: webhookID, Err: ErrClosed}
+	}
+
+	return results
+}
+
+//
+// Private constants
+//
+
+const defaultWorkers = 10
+
+//
+// Private types
+//
+
+type asyncJob struct {
+	ctx       context.Context
+	webhookID string
+	body      string
+	headers   map[string]string
+	results   chan<- PostResult
+}
+
+//
+// Private functions
+//
+
+func (c *EndpointClient) workers() int {
+	if c.cfg.Workers > 0 {
+		return c.cfg.Workers
+	}
+
+	return defaultWorkers
+}
+
+// work processes jobs until the client is closed.
+func (c *EndpointClient) work() {
+	for {
+		select {
+		case job := <-c.jobs:
+			result, err := c.post(job.ctx, job.webhookID, job.body, job.headers)
+			job.results <- PostResult{
+				WebhookID:  job.webhookID,
+				StatusCode: result.statusCode,
+				Err:        err,
+			}
+		case <-c.workersDone:
+			return
+		}
+	}
+}
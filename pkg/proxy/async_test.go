@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostAsync(t *testing.T) {
+	var inFlight, maxInFlight int32
+	arrived := make(chan struct{}, 5)
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			peak := atomic.LoadInt32(&maxInFlight)
+			if n <= peak || atomic.CompareAndSwapInt32(&maxInFlight, peak, n) {
+				break
+			}
+		}
+		arrived <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		Workers: 2,
+	})
+	require.Nil(t, err)
+
+	// PostAsync blocks while both workers are held by the endpoint
+	results := make(chan (<-chan PostResult), 5)
+	go func() {
+		for _, webhookID := range []string{"wh_0", "wh_1", "wh_2", "wh_3", "wh_4"} {
+			results <- client.PostAsync(context.Background(), webhookID, "{}", map[string]string{})
+		}
+		close(results)
+	}()
+
+	<-arrived
+	<-arrived
+	require.Len(t, arrived, 0)
+	close(release)
+
+	i := 0
+	for ch := range results {
+		result := <-ch
+		require.Nil(t, result.Err)
+		require.Equal(t, http.StatusAccepted, result.StatusCode)
+		require.Equal(t, []string{"wh_0", "wh_1", "wh_2", "wh_3", "wh_4"}[i], result.WebhookID)
+		i++
+	}
+	require.Equal(t, 5, i)
+	require.Equal(t, int32(2), atomic.LoadInt32(&maxInFlight))
+
+	require.Equal(t, 0, client.Close(context.Background()))
+	result := <-client.PostAsync(context.Background(), "wh_5", "{}", map[string]string{})
+	require.Equal(t, ErrClosed, result.Err)
+}
+
+func TestPostAsyncBackpressure(t *testing.T) {
+	unblock := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		Workers: 1,
+	})
+	require.Nil(t, err)
+
+	first := client.PostAsync(context.Background(), "wh_0", "{}", map[string]string{})
+
+	// The only worker is busy, so the enqueue gives up with the context
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	result := <-client.PostAsync(ctx, "wh_1", "{}", map[string]string{})
+	require.Equal(t, context.DeadlineExceeded, result.Err)
+
+	close(unblock)
+	result = <-first
+	require.Nil(t, result.Err)
+	require.Equal(t, http.StatusOK, result.StatusCode)
+}
@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+//
+// Public types
+//
+
+// WeightedTarget is one of the URLs of an EndpointClient that spreads events
+// across several local endpoints.
+type WeightedTarget struct {
+	URL string
+
+	// Weight is the share of events sent to the URL relative to the other
+	// targets. It must be at least 1.
+	Weight int
+}
+
+//
+// Public functions
+//
+
+// NewWeightedEndpointClient returns a new EndpointClient that spreads events
+// across the target URLs using weighted round-robin. When retries are
+// enabled, failed attempts are retried on a different target if there is
+// one. Unix domain socket URLs are not supported.
+func NewWeightedEndpointClient(targets []WeightedTarget, connect bool, events []string, cfg *EndpointConfig) (*EndpointClient, error) {
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("at least one target is required")
+	}
+
+	urls := make([]string, 0, len(targets))
+	for _, t := range targets {
+		if t.Weight < 1 {
+			return nil, fmt.Errorf("weight of target %s must be at least 1, got %d", t.URL, t.Weight)
+		}
+		if strings.HasPrefix(t.URL, unixSocketScheme) {
+			return nil, fmt.Errorf("unix socket target %s can't be weighted", t.URL)
+		}
+		urls = append(urls, t.URL)
+	}
+
+	return newEndpointClient(strings.Join(urls, ","), targets, connect, events, cfg)
+}
+
+//
+// Private types
+//
+
+// target is a URL requests are sent to
+type target struct {
+	url string
+
+	// requestURL is the URL of the requests, which differs from url for Unix
+	// domain sockets
+	requestURL string
+
+	// host is the host of url, by which requests in flight are capped
+	host string
+
+	weight int
+
+	// current is the running weight used by the smooth weighted round-robin
+	current int
+}
+
+// targetPicker selects the target of each request with the smooth weighted
+// round-robin algorithm, which interleaves the targets instead of sending
+// bursts of requests to the heaviest one.
+type targetPicker struct {
+	mu      sync.Mutex
+	targets []*target
+}
+
+// pick returns the next target. If avoid is set and there are other targets,
+// avoid is not picked.
+func (p *targetPicker) pick(avoid *target) *target {
+	if len(p.targets) == 1 {
+		return p.targets[0]
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var best *target
+	total := 0
+	for _, t := range p.targets {
+		if t == avoid {
+			continue
+		}
+		t.current += t.weight
+		total += t.weight
+		if best == nil || t.current > best.current {
+			best = t
+		}
+	}
+	best.current -= total
+
+	return best
+}
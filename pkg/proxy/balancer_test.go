@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTargetPickerWeights(t *testing.T) {
+	a := &target{url: "a", weight: 2}
+	b := &target{url: "b", weight: 1}
+	picker := &targetPicker{targets: []*target{a, b}}
+
+	picked := ""
+	for i := 0; i < 6; i++ {
+		picked += picker.pick(nil).url
+	}
+
+	// Targets are interleaved rather than picked in bursts
+	require.Equal(t, "abaaba", picked)
+}
+
+func TestTargetPickerAvoid(t *testing.T) {
+	a := &target{url: "a", weight: 5}
+	b := &target{url: "b", weight: 1}
+	picker := &targetPicker{targets: []*target{a, b}}
+
+	for i := 0; i < 10; i++ {
+		require.Equal(t, b, picker.pick(a))
+	}
+
+	single := &targetPicker{targets: []*target{a}}
+	require.Equal(t, a, single.pick(a))
+}
+
+func TestNewWeightedEndpointClientInvalidTargets(t *testing.T) {
+	_, err := NewWeightedEndpointClient(nil, false, []string{"*"}, nil)
+	require.NotNil(t, err)
+
+	_, err = NewWeightedEndpointClient([]WeightedTarget{{URL: "http://localhost", Weight: 0}}, false, []string{"*"}, nil)
+	require.NotNil(t, err)
+
+	_, err = NewWeightedEndpointClient([]WeightedTarget{{URL: "unix:///tmp/endpoint.sock", Weight: 1}}, false, []string{"*"}, nil)
+	require.NotNil(t, err)
+}
+
+func TestPostWeightedTargets(t *testing.T) {
+	var countA, countB int32
+	tsA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&countA, 1)
+	}))
+	defer tsA.Close()
+	tsB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&countB, 1)
+	}))
+	defer tsB.Close()
+
+	client, err := NewWeightedEndpointClient([]WeightedTarget{
+		{URL: tsA.URL, Weight: 3},
+		{URL: tsB.URL, Weight: 1},
+	}, false, []string{"*"}, nil)
+	require.Nil(t, err)
+	require.Equal(t, tsA.URL+","+tsB.URL, client.URL)
+
+	for i := 0; i < 8; i++ {
+		require.Nil(t, client.Post("wh_123", "{}", map[string]string{}))
+	}
+
+	require.Equal(t, int32(6), atomic.LoadInt32(&countA))
+	require.Equal(t, int32(2), atomic.LoadInt32(&countB))
+}
+
+func TestPostWeightedTargetsRetryOtherTarget(t *testing.T) {
+	var countFailing int32
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&countFailing, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer healthy.Close()
+
+	rcvStatus := 0
+	client, err := NewWeightedEndpointClient([]WeightedTarget{
+		{URL: failing.URL, Weight: 10},
+		{URL: healthy.URL, Weight: 1},
+	}, false, []string{"*"}, &EndpointConfig{
+		RetryPolicy: RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond},
+		ResponseHandler: EndpointResponseHandlerFunc(func(webhookID string, resp *http.Response) {
+			rcvStatus = resp.StatusCode
+		}),
+	})
+	require.Nil(t, err)
+
+	err = client.Post("wh_123", "{}", map[string]string{})
+	require.Nil(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&countFailing))
+	require.Equal(t, http.StatusOK, rcvStatus)
+}
@@ -0,0 +1,360 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//
+// Private constants
+//
+
+const (
+	defaultMaxBatchSize = 100
+	defaultMaxBatchWait = time.Second
+)
+
+//
+// Private types
+//
+
+// batcher accumulates the events of an EndpointClient into batches. A batch
+// is sent once it is full, by the goroutine that filled it, or once the
+// oldest event in it waited for long enough, by a timer goroutine. A pending
+// batch is held as outstanding in the client from its first event until it
+// was sent, so that Close waits for it, and Close sends it without waiting
+// for its timer.
+type batcher struct {
+	maxSize int
+	maxWait time.Duration
+	clock   Clock
+
+	// ordered makes the batches be sent one after the other, in the order
+	// in which they were started, so that the events of a partition are
+	// delivered in order even though they don't wait for the batches of the
+	// previous events
+	ordered bool
+
+	// send delivers a batch and hands every event its result
+	send func(events []*batchedEvent)
+
+	// hold registers a pending batch as outstanding in the client, and
+	// returns a function to call once it was sent
+	hold func() func()
+
+	// closing is closed when the client is closed, to send the pending batch
+	// right away
+	closing   chan struct{}
+	closeOnce sync.Once
+
+	mu      sync.Mutex
+	pending []*batchedEvent
+
+	// taken is closed when the pending batch is taken, to stop its timer
+	taken chan struct{}
+
+	// lastSent is closed once the last batch taken was sent, when ordered
+	lastSent chan struct{}
+}
+
+// batchedEvent is an event waiting in a batch. done receives its result once
+// the batch was delivered.
+type batchedEvent struct {
+	d    *delivery
+	done chan batchResult
+	sent bool
+}
+
+type batchResult struct {
+	statusCode int
+	err        error
+}
+
+// add appends the event to the pending batch, sending the batch if it is
+// full.
+func (b *batcher) add(evt *batchedEvent) {
+	b.mu.Lock()
+	b.pending = append(b.pending, evt)
+	if len(b.pending) == 1 {
+		b.taken = make(chan struct{})
+	}
+
+	if len(b.pending) >= b.maxSize {
+		send := b.take()
+		b.mu.Unlock()
+		send()
+		return
+	}
+
+	if len(b.pending) == 1 {
+		go b.sendAfterWait(b.taken, b.hold())
+	}
+	b.mu.Unlock()
+}
+
+// sendAfterWait sends the pending batch once its oldest event waited for
+// maxWait, or right away when the client is closed, unless the batch was
+// taken before that. It then calls release.
+func (b *batcher) sendAfterWait(taken chan struct{}, release func()) {
+	defer release()
+
+	select {
+	case <-b.clock.After(b.maxWait):
+	case <-b.closing:
+	case <-taken:
+		return
+	}
+
+	b.mu.Lock()
+	select {
+	case <-taken:
+		b.mu.Unlock()
+		return
+	default:
+	}
+	send := b.take()
+	b.mu.Unlock()
+	send()
+}
+
+// take returns a function sending the pending batch, after the previous
+// batches when ordered, and starts a new batch. It must be called with the
+// lock held.
+func (b *batcher) take() func() {
+	events := b.pending
+	b.pending = nil
+	close(b.taken)
+
+	if !b.ordered {
+		return func() { b.send(events) }
+	}
+
+	prev := b.lastSent
+	sent := make(chan struct{})
+	b.lastSent = sent
+
+	return func() {
+		if prev != nil {
+			<-prev
+		}
+		b.send(events)
+		close(sent)
+	}
+}
+
+// close makes the pending batch and the batches started later be sent
+// without waiting for their timer.
+func (b *batcher) close() {
+	b.closeOnce.Do(func() { close(b.closing) })
+}
+
+// batchEntry is the result of an event in the response of a batch endpoint
+type batchEntry struct {
+	Status int `json:"status"`
+}
+
+//
+// Private functions
+//
+
+func (c *EndpointClient) newBatcher() *batcher {
+	if c.cfg.BatchURL == "" {
+		return nil
+	}
+
+	maxSize := c.cfg.MaxBatchSize
+	if maxSize <= 0 {
+		maxSize = defaultMaxBatchSize
+	}
+	maxWait := c.cfg.MaxBatchWait
+	if maxWait <= 0 {
+		maxWait = defaultMaxBatchWait
+	}
+
+	return &batcher{
+		maxSize: maxSize,
+		maxWait: maxWait,
+		clock:   c.clock,
+		ordered: c.partitioner != nil,
+		send:    c.sendBatch,
+		hold:    c.hold,
+		closing: make(chan struct{}),
+	}
+}
+
+// postBatched adds the event to the pending batch and waits until the batch
+// was delivered, or until the context is done. In the latter case the event
+// is still delivered with its batch. joined is called once the event was
+// added, since the order of the events of a partition is then kept by the
+// batches.
+func (c *EndpointClient) postBatched(ctx context.Context, d *delivery, joined func()) (int, error) {
+	evt := &batchedEvent{d: d, done: make(chan batchResult, 1)}
+	c.batcher.add(evt)
+	joined()
+
+	select {
+	case result := <-evt.done:
+		return result.statusCode, result.err
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// sendBatch forwards the events as a JSON array to the batch URL and hands
+// every event its result. It isn't bound to the contexts of the events,
+// only to the client being stopped.
+func (c *EndpointClient) sendBatch(events []*batchedEvent) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-c.stopped:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	d, err := c.newBatchDelivery(events)
+	if err == nil {
+		c.cfg.Log.WithFields(log.Fields{
+			"prefix":     "proxy.EndpointClient.Post",
+			"batch_size": len(events),
+		}).Debug("Forwarding batch of events")
+
+		_, err = c.forward(ctx, d)
+	}
+
+	for _, evt := range events {
+		if !evt.sent {
+			evt.done <- batchResult{err: err}
+		}
+	}
+}
+
+func (c *EndpointClient) newBatchDelivery(events []*batchedEvent) (*delivery, error) {
+	bodies := make([]string, 0, len(events))
+	for _, evt := range events {
+		bodies = append(bodies, string(evt.d.body))
+	}
+
+	d := &delivery{
+		evt:     &stripeEvent{},
+		body:    []byte("[" + strings.Join(bodies, ",") + "]"),
+		headers: map[string]string{"Content-Type": defaultContentType},
+		target:  c.batchTarget,
+		batch:   events,
+	}
+
+	if c.cfg.RequestIDHeader != "" {
+		requestID, err := newRequestID()
+		if err != nil {
+			return nil, err
+		}
+		d.requestID = requestID
+	}
+
+	if c.cfg.CompressRequests && len(d.body) > c.compressionThreshold() {
+		compressed, err := gzipBody(d.body)
+		if err != nil {
+			return nil, err
+		}
+		d.uncompressed = d.body
+		d.body = compressed
+		d.contentEncoding = "gzip"
+	}
+
+	return d, nil
+}
+
+// handleBatchResponse splits the response of the batch endpoint into a
+// response for every event of the batch and hands them to the response
+// handler. If the body of the response is a JSON array with an entry with a
+// status for every event, in the order of the batch, every event gets its
+// own status code and entry as its body. Otherwise every event gets the
+// status code and the body of the batch response.
+func (c *EndpointClient) handleBatchResponse(d *delivery, resp *http.Response) (int, error) {
+	body, _ := ioutil.ReadAll(resp.Body) // #nosec G104
+
+	statusCodes, entries := splitBatchResponse(body, len(d.batch))
+	if entries == nil {
+		c.cfg.Log.WithFields(d.logFields(log.Fields{
+			"batch_size": len(d.batch),
+		})).Debug("Batch response has no result per event, using the response of the batch")
+	}
+
+	for i, evt := range d.batch {
+		statusCode := resp.StatusCode
+		evtBody := body
+		if entries != nil {
+			statusCode = statusCodes[i]
+			evtBody = entries[i]
+		}
+
+		evtResp := &http.Response{
+			Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+			StatusCode:    statusCode,
+			Proto:         resp.Proto,
+			ProtoMajor:    resp.ProtoMajor,
+			ProtoMinor:    resp.ProtoMinor,
+			Header:        resp.Header.Clone(),
+			Body:          ioutil.NopCloser(bytes.NewReader(evtBody)),
+			ContentLength: int64(len(evtBody)),
+			Request:       resp.Request,
+		}
+
+		evt.d.duration = d.duration
+		statusCode, err := c.handleBatchedEventResponse(evt.d, evtResp)
+		evt.done <- batchResult{statusCode: statusCode, err: err}
+		evt.sent = true
+	}
+
+	return resp.StatusCode, nil
+}
+
+// handleBatchedEventResponse handles the response of an event of a batch
+// like forward handles the response of an event sent on its own.
+func (c *EndpointClient) handleBatchedEventResponse(d *delivery, resp *http.Response) (int, error) {
+	c.classify(d, resp, nil)
+
+	if handler, ok := c.cfg.ResponseHandler.(EndpointResponseActionHandler); ok {
+		d.action = handler.ProcessEndpointResponseAction(c.endpointResponse(d, resp))
+	}
+
+	if !c.isDeliveryFailure(d, resp, nil) {
+		if err := c.dedup.add(d.evt.ID); err != nil {
+			c.cfg.Log.WithFields(d.logFields(nil)).Warnf("Failed to record delivered event in dedup file, error = %v", err)
+		}
+	}
+
+	return c.handleResponse(d, resp)
+}
+
+// splitBatchResponse returns the status code and the entry of every event in
+// the body of a batch response, or nil if the body isn't a JSON array with an
+// object with a status for each of the n events.
+func splitBatchResponse(body []byte, n int) ([]int, []json.RawMessage) {
+	var entries []json.RawMessage
+	if err := json.Unmarshal(body, &entries); err != nil || len(entries) != n {
+		return nil, nil
+	}
+
+	statusCodes := make([]int, n)
+	for i, raw := range entries {
+		var entry batchEntry
+		if err := json.Unmarshal(raw, &entry); err != nil || entry.Status < 100 || entry.Status > 599 {
+			return nil, nil
+		}
+		statusCodes[i] = entry.Status
+	}
+
+	return statusCodes, entries
+}
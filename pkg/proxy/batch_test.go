@@ -0,0 +1,297 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/stripe-cli/pkg/proxy/proxytest"
+)
+
+func TestPostBatch(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	var rcvBody string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		rcvBody = string(buf)
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"status":200},{"status":500,"error":"boom"},{"status":200}]`))
+	}))
+	defer ts.Close()
+
+	handled := map[string]string{}
+	client, err := NewEndpointClient(ts.URL+"/events", false, []string{"*"}, &EndpointConfig{
+		BatchURL:     ts.URL + "/batch",
+		MaxBatchSize: 3,
+		MaxBatchWait: time.Minute,
+		FailOnNon2xx: true,
+		ResponseHandler: EndpointResponseHandlerFunc(func(webhookID string, resp *http.Response) {
+			buf, _ := ioutil.ReadAll(resp.Body)
+			mu.Lock()
+			handled[webhookID] = resp.Status + " " + string(buf)
+			mu.Unlock()
+		}),
+	})
+	require.Nil(t, err)
+
+	// The first events wait for the batch to be full
+	errs := make(chan error, 2)
+	go func() { errs <- client.Post("wh_1", `{"id":"evt_1"}`, map[string]string{}) }()
+	go func() { errs <- client.Post("wh_2", `{"id":"evt_2"}`, map[string]string{}) }()
+	waitForPendingBatch(client, 2)
+
+	require.Nil(t, client.Post("wh_3", `{"id":"evt_3"}`, map[string]string{}))
+
+	var failed int
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			var statusErr *HTTPStatusError
+			require.True(t, errors.As(err, &statusErr))
+			require.Equal(t, http.StatusInternalServerError, statusErr.Code)
+			failed++
+		}
+	}
+	require.Equal(t, 1, failed)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []string{"/batch"}, paths)
+	require.Contains(t, rcvBody, `{"id":"evt_3"}]`)
+	require.Len(t, rcvBody, len(`[{"id":"evt_1"},{"id":"evt_2"},{"id":"evt_3"}]`))
+
+	// Every event is handed its own result, in the order of the batch
+	require.Equal(t, `200 OK {"status":200}`, handled["wh_3"])
+	statuses := []string{handled["wh_1"], handled["wh_2"]}
+	sort.Strings(statuses)
+	require.Equal(t, []string{`200 OK {"status":200}`, `500 Internal Server Error {"status":500,"error":"boom"}`}, statuses)
+}
+
+func TestPostBatchWait(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	var status int
+	var body string
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		BatchURL:     ts.URL + "/batch",
+		MaxBatchWait: 10 * time.Millisecond,
+		ResponseHandler: EndpointResponseHandlerFunc(func(webhookID string, resp *http.Response) {
+			buf, _ := ioutil.ReadAll(resp.Body)
+			status = resp.StatusCode
+			body = string(buf)
+		}),
+	})
+	require.Nil(t, err)
+
+	// The batch isn't full, so it is sent after MaxBatchWait, and as its
+	// response has no result per event, the event gets the batch response
+	start := time.Now()
+	require.Nil(t, client.Post("wh_123", `{"id":"evt_123"}`, map[string]string{}))
+	require.True(t, time.Since(start) >= 10*time.Millisecond)
+	require.Equal(t, int32(1), atomic.LoadInt32(&requests))
+	require.Equal(t, http.StatusAccepted, status)
+	require.Equal(t, "ok", body)
+}
+
+func TestPostBatchClose(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	}))
+	defer ts.Close()
+
+	clock := proxytest.NewFakeClock(time.Date(2019, 9, 1, 12, 0, 0, 0, time.UTC))
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		BatchURL:     ts.URL + "/batch",
+		MaxBatchWait: time.Minute,
+		Clock:        clock,
+	})
+	require.Nil(t, err)
+
+	// The event stays in the batch after Post gave up, and Close sends it
+	// without waiting for its timer
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() { errs <- client.PostWithContext(ctx, "wh_123", `{"id":"evt_123"}`, map[string]string{}) }()
+	waitForPendingBatch(client, 1)
+	cancel()
+	require.Equal(t, context.Canceled, <-errs)
+	require.Equal(t, int32(0), atomic.LoadInt32(&requests))
+
+	require.Equal(t, 0, client.Close(context.Background()))
+	require.Equal(t, int32(1), atomic.LoadInt32(&requests))
+}
+
+func TestPostBatchPartitioned(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	arrived := make(chan struct{}, 2)
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf, _ := ioutil.ReadAll(r.Body)
+		arrived <- struct{}{}
+		if strings.Contains(string(buf), "evt_1") {
+			// The next batch must wait for this one
+			<-release
+		}
+		mu.Lock()
+		bodies = append(bodies, string(buf))
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	clock := proxytest.NewFakeClock(time.Date(2019, 9, 1, 12, 0, 0, 0, time.UTC))
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		BatchURL:          ts.URL + "/batch",
+		MaxBatchSize:      3,
+		MaxBatchWait:      time.Minute,
+		SerializeDelivery: true,
+		Clock:             clock,
+	})
+	require.Nil(t, err)
+
+	// The events of a partition join the same batch instead of waiting for
+	// the batches of the previous ones
+	errs := make(chan error, 4)
+	for i := 1; i <= 3; i++ {
+		id := fmt.Sprintf("evt_%d", i)
+		go func() { errs <- client.Post("wh_"+id, `{"id":"`+id+`"}`, map[string]string{}) }()
+		if i < 3 {
+			waitForPendingBatch(client, i)
+		}
+	}
+	<-arrived
+
+	// The next batch waits for the full one to be delivered, whose timer is
+	// still registered with the clock
+	go func() { errs <- client.Post("wh_evt_4", `{"id":"evt_4"}`, map[string]string{}) }()
+	require.False(t, clock.WaitForWaiters(2, 20*time.Millisecond))
+	close(release)
+	require.True(t, clock.WaitForWaiters(2, time.Second))
+	clock.Advance(time.Minute)
+	for i := 0; i < 4; i++ {
+		require.Nil(t, <-errs)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []string{
+		`[{"id":"evt_1"},{"id":"evt_2"},{"id":"evt_3"}]`,
+		`[{"id":"evt_4"}]`,
+	}, bodies)
+}
+
+func TestPostBatchEventStatusNotRetried(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"status":503}]`))
+	}))
+	defer ts.Close()
+
+	var status int
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		BatchURL:     ts.URL + "/batch",
+		MaxBatchSize: 1,
+		FailOnNon2xx: true,
+		RetryPolicy:  RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+		ResponseHandler: EndpointResponseHandlerFunc(func(webhookID string, resp *http.Response) {
+			status = resp.StatusCode
+		}),
+	})
+	require.Nil(t, err)
+
+	// The batch request succeeded, so the retryable status of the event is
+	// handed to its Post without resubmitting it
+	err = client.Post("wh_123", `{"id":"evt_123"}`, map[string]string{})
+	var statusErr *HTTPStatusError
+	require.True(t, errors.As(err, &statusErr))
+	require.Equal(t, http.StatusServiceUnavailable, statusErr.Code)
+	require.Equal(t, http.StatusServiceUnavailable, status)
+	require.Equal(t, int32(1), atomic.LoadInt32(&requests))
+}
+
+func TestPostBatchTransportError(t *testing.T) {
+	var onError []string
+	client, err := NewEndpointClient("http://localhost:1", false, []string{"*"}, &EndpointConfig{
+		BatchURL:     "http://localhost:1/batch",
+		MaxBatchSize: 1,
+		OnError: func(webhookID string, eventType string, err error) {
+			onError = append(onError, webhookID)
+		},
+	})
+	require.Nil(t, err)
+
+	require.NotNil(t, client.Post("wh_123", `{"id":"evt_123"}`, map[string]string{}))
+	require.Equal(t, []string{"wh_123"}, onError)
+}
+
+func TestNewEndpointClientBatchURL(t *testing.T) {
+	_, err := NewEndpointClient("http://localhost", false, []string{"*"}, &EndpointConfig{
+		BatchURL: "localhost/batch",
+	})
+	require.NotNil(t, err)
+
+	_, err = NewEndpointClient("http://localhost", false, []string{"*"}, &EndpointConfig{
+		BatchURL:       "http://localhost/batch",
+		FormEncodeBody: true,
+	})
+	require.NotNil(t, err)
+
+	_, err = NewEndpointClient("http://localhost", false, []string{"*"}, &EndpointConfig{
+		BatchURL: "unix:///tmp/batch.sock",
+	})
+	require.NotNil(t, err)
+}
+
+func TestSplitBatchResponse(t *testing.T) {
+	statusCodes, entries := splitBatchResponse([]byte(`[{"status":200},{"status":409,"id":"evt_2"}]`), 2)
+	require.Equal(t, []int{200, 409}, statusCodes)
+	require.Equal(t, `{"status":409,"id":"evt_2"}`, string(entries[1]))
+
+	// The response must have a valid status for every event
+	statusCodes, _ = splitBatchResponse([]byte(`[{"status":200}]`), 2)
+	require.Nil(t, statusCodes)
+	statusCodes, _ = splitBatchResponse([]byte(`[{"status":200},{}]`), 2)
+	require.Nil(t, statusCodes)
+	statusCodes, _ = splitBatchResponse([]byte(`ok`), 1)
+	require.Nil(t, statusCodes)
+}
+
+// waitForPendingBatch waits for the pending batch of the client to hold n
+// events, for up to a second.
+func waitForPendingBatch(client *EndpointClient, n int) {
+	deadline := time.Now().Add(time.Second)
+	for {
+		client.batcher.mu.Lock()
+		pending := len(client.batcher.pending)
+		client.batcher.mu.Unlock()
+		if pending == n || time.Now().After(deadline) {
+			return
+		}
+		runtime.Gosched()
+	}
+}
@@ -0,0 +1,247 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//
+// Public variables
+//
+
+// ErrCircuitOpen is returned by EndpointClient.Post when the endpoint failed
+// too many times in a row and the circuit breaker is not letting requests
+// through.
+var ErrCircuitOpen = errors.New("circuit breaker is open, endpoint is considered unavailable")
+
+//
+// Public types
+//
+
+// CircuitBreakerPolicy configures the circuit breaker of an EndpointClient.
+// The zero value disables the circuit breaker.
+type CircuitBreakerPolicy struct {
+	// FailureThreshold is the number of consecutive failed deliveries after
+	// which the circuit opens. A delivery fails when all its attempts
+	// ended with a transport error or a 5xx response.
+	FailureThreshold int
+
+	// Cooldown is how long the circuit stays open before letting a single
+	// trial request through to test whether the endpoint recovered.
+	Cooldown time.Duration
+}
+
+// CircuitState is the state of a circuit breaker.
+type CircuitState int
+
+// Possible states of a circuit breaker.
+const (
+	// CircuitClosed lets all requests through
+	CircuitClosed CircuitState = iota
+
+	// CircuitOpen rejects all requests
+	CircuitOpen
+
+	// CircuitHalfOpen lets a single trial request through
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+//
+// Private types
+//
+
+type circuitBreaker struct {
+	policy CircuitBreakerPolicy
+	log    *log.Logger
+	clock  Clock
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+
+	// streak is the number of consecutive failed deliveries, which is
+	// tracked even when the breaker is disabled
+	streak int
+
+	// trialInFlight is set while the half-open trial request is in flight
+	trialInFlight bool
+}
+
+func (b *circuitBreaker) enabled() bool {
+	return b.policy.FailureThreshold > 0
+}
+
+// allow returns whether a request may be sent to the endpoint. Every allowed
+// request must be followed by a call to either record or abort.
+func (b *circuitBreaker) allow() bool {
+	if !b.enabled() {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		if b.clock.Now().Sub(b.openedAt) < b.policy.Cooldown {
+			return false
+		}
+		b.setState(CircuitHalfOpen)
+		b.trialInFlight = true
+		return true
+	case CircuitHalfOpen:
+		if b.trialInFlight {
+			return false
+		}
+		b.trialInFlight = true
+		return true
+	default:
+		return true
+	}
+}
+
+// record updates the breaker with the outcome of a delivery and returns the
+// current failure streak.
+func (b *circuitBreaker) record(success bool) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if success {
+		b.streak = 0
+	} else {
+		b.streak++
+	}
+
+	if !b.enabled() {
+		return b.streak
+	}
+
+	switch b.state {
+	case CircuitClosed:
+		if success {
+			b.failures = 0
+			break
+		}
+		b.failures++
+		if b.failures >= b.policy.FailureThreshold {
+			b.open()
+		}
+	case CircuitHalfOpen:
+		b.trialInFlight = false
+		if success {
+			b.failures = 0
+			b.setState(CircuitClosed)
+		} else {
+			b.open()
+		}
+	}
+
+	return b.streak
+}
+
+// abort releases a request that was allowed but whose outcome is unknown,
+// e.g. because it was canceled.
+func (b *circuitBreaker) abort() {
+	if !b.enabled() {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == CircuitHalfOpen {
+		b.trialInFlight = false
+	}
+}
+
+// retryIn returns how long until the open circuit lets a trial request
+// through.
+func (b *circuitBreaker) retryIn() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != CircuitOpen {
+		return 0
+	}
+
+	return b.policy.Cooldown - b.clock.Now().Sub(b.openedAt)
+}
+
+func (b *circuitBreaker) failureStreak() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.streak
+}
+
+func (b *circuitBreaker) currentState() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+// open must be called with the lock held.
+func (b *circuitBreaker) open() {
+	b.openedAt = b.clock.Now()
+	b.setState(CircuitOpen)
+}
+
+// setState must be called with the lock held.
+func (b *circuitBreaker) setState(state CircuitState) {
+	if state == b.state {
+		return
+	}
+
+	b.log.WithFields(log.Fields{
+		"prefix": "proxy.circuitBreaker",
+		"from":   b.state,
+		"to":     state,
+	}).Infof("Circuit breaker is now %s", state)
+
+	b.state = state
+}
+
+//
+// Private functions
+//
+
+func newCircuitBreaker(policy CircuitBreakerPolicy, logger *log.Logger, clock Clock) *circuitBreaker {
+	return &circuitBreaker{
+		policy: policy,
+		log:    logger,
+		clock:  clock,
+	}
+}
+
+// isDeliveryFailure returns whether the outcome of a delivery counts as a
+// failure for the circuit breaker. Success status codes never do, and
+// responses classified by ClassifyResponse do unless they are successes.
+func (c *EndpointClient) isDeliveryFailure(d *delivery, resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	if d.outcome != OutcomeDefault {
+		return d.outcome != OutcomeSuccess
+	}
+
+	return resp.StatusCode >= http.StatusInternalServerError && !c.isSuccess(resp.StatusCode)
+}
@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/stripe-cli/pkg/proxy/proxytest"
+)
+
+func TestCircuitBreakerStates(t *testing.T) {
+	clock := proxytest.NewFakeClock(time.Now())
+	breaker := newCircuitBreaker(CircuitBreakerPolicy{FailureThreshold: 2, Cooldown: 20 * time.Millisecond}, &log.Logger{Out: ioutil.Discard}, clock)
+
+	require.True(t, breaker.allow())
+	breaker.record(false)
+	require.Equal(t, CircuitClosed, breaker.currentState())
+
+	require.True(t, breaker.allow())
+	breaker.record(false)
+	require.Equal(t, CircuitOpen, breaker.currentState())
+	require.False(t, breaker.allow())
+
+	clock.Advance(25 * time.Millisecond)
+
+	// Only a single trial request is let through
+	require.True(t, breaker.allow())
+	require.Equal(t, CircuitHalfOpen, breaker.currentState())
+	require.False(t, breaker.allow())
+
+	breaker.record(false)
+	require.Equal(t, CircuitOpen, breaker.currentState())
+
+	clock.Advance(25 * time.Millisecond)
+
+	require.True(t, breaker.allow())
+	breaker.record(true)
+	require.Equal(t, CircuitClosed, breaker.currentState())
+	require.True(t, breaker.allow())
+}
+
+func TestPostCircuitOpen(t *testing.T) {
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		CircuitBreaker: CircuitBreakerPolicy{FailureThreshold: 3, Cooldown: time.Minute},
+	})
+	require.Nil(t, err)
+
+	for i := 0; i < 3; i++ {
+		err = client.Post("wh_123", "{}", map[string]string{})
+		require.Nil(t, err)
+	}
+	require.Equal(t, CircuitOpen, client.CircuitState())
+
+	err = client.Post("wh_123", "{}", map[string]string{})
+	require.Equal(t, ErrCircuitOpen, err)
+	require.Equal(t, int32(3), atomic.LoadInt32(&count))
+}
+
+func TestPostFailureStreak(t *testing.T) {
+	var failing int32 = 1
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	var notified []int
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		FailureStreakThreshold: 2,
+		OnFailureStreak: func(streak int) {
+			notified = append(notified, streak)
+		},
+	})
+	require.Nil(t, err)
+
+	for i := 0; i < 3; i++ {
+		require.Nil(t, client.Post("wh_123", "{}", map[string]string{}))
+	}
+	require.Equal(t, 3, client.FailureStreak())
+	require.Equal(t, []int{2}, notified)
+
+	atomic.StoreInt32(&failing, 0)
+	require.Nil(t, client.Post("wh_123", "{}", map[string]string{}))
+	require.Equal(t, 0, client.FailureStreak())
+
+	// A new streak notifies again
+	atomic.StoreInt32(&failing, 1)
+	for i := 0; i < 2; i++ {
+		require.Nil(t, client.Post("wh_123", "{}", map[string]string{}))
+	}
+	require.Equal(t, []int{2, 2}, notified)
+}
@@ -0,0 +1,246 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//
+// Public variables
+//
+
+// ErrBufferFull is returned by EndpointClient.Post when the event can't be
+// buffered because the buffer is full and its overflow policy is
+// RejectNewest.
+var ErrBufferFull = errors.New("event buffer is full")
+
+//
+// Public types
+//
+
+// BufferOverflowPolicy decides what happens to events that arrive when the
+// buffer of an EndpointClient is full.
+type BufferOverflowPolicy int
+
+// Possible overflow policies of a buffer.
+const (
+	// DropOldest drops the oldest buffered event to make room for the new one
+	DropOldest BufferOverflowPolicy = iota
+
+	// RejectNewest rejects the new event with ErrBufferFull
+	RejectNewest
+)
+
+//
+// Private constants
+//
+
+// minFlushDelay is the minimum delay before trying to flush the buffer again
+// when the circuit breaker didn't let the trial request through
+const minFlushDelay = 100 * time.Millisecond
+
+//
+// Private types
+//
+
+type bufferedEvent struct {
+	webhookID string
+	body      string
+	headers   map[string]string
+
+	// replayed is set for the events replayed from the dead letter file
+	replayed bool
+}
+
+// eventBuffer is a bounded FIFO of the events that arrived while the circuit
+// breaker was open.
+type eventBuffer struct {
+	size   int
+	policy BufferOverflowPolicy
+
+	mu     sync.Mutex
+	events []*bufferedEvent
+
+	// active is set from the moment an event is buffered until the buffer is
+	// flushed. New events are buffered while it is set so that events are
+	// delivered in order.
+	active bool
+}
+
+// push appends the event to the buffer. When onlyIfActive is set, the event
+// is only buffered if the buffer is active. It returns whether the event was
+// buffered and the event that was dropped to make room for it, if any.
+func (b *eventBuffer) push(evt *bufferedEvent, onlyIfActive bool) (bool, *bufferedEvent, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if onlyIfActive && !b.active {
+		return false, nil, nil
+	}
+
+	var dropped *bufferedEvent
+	if len(b.events) >= b.size {
+		if b.policy == RejectNewest {
+			return false, nil, ErrBufferFull
+		}
+		dropped = b.events[0]
+		b.events = b.events[1:]
+	}
+	b.events = append(b.events, evt)
+
+	return true, dropped, nil
+}
+
+// activate marks the buffer as active and returns whether it wasn't already.
+func (b *eventBuffer) activate() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.active {
+		return false
+	}
+	b.active = true
+
+	return true
+}
+
+// peek returns the oldest event, or nil if the buffer is empty, in which case
+// the buffer is no longer active.
+func (b *eventBuffer) peek() *bufferedEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.events) == 0 {
+		b.active = false
+		return nil
+	}
+
+	return b.events[0]
+}
+
+// pop removes the oldest event.
+func (b *eventBuffer) pop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.events) > 0 {
+		b.events = b.events[1:]
+	}
+}
+
+// take empties the buffer and returns its events.
+func (b *eventBuffer) take() []*bufferedEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	events := b.events
+	b.events = nil
+	b.active = false
+
+	return events
+}
+
+//
+// Private functions
+//
+
+func newEventBuffer(size int, policy BufferOverflowPolicy) *eventBuffer {
+	if size <= 0 {
+		return nil
+	}
+
+	return &eventBuffer{
+		size:   size,
+		policy: policy,
+	}
+}
+
+// bufferEvent holds the event until the endpoint recovers. When onlyIfActive
+// is set, the event is only buffered if older events are already waiting. It
+// returns whether the event was buffered.
+func (c *EndpointClient) bufferEvent(evt *bufferedEvent, onlyIfActive bool) (bool, error) {
+	buffered, dropped, err := c.buffer.push(evt, onlyIfActive)
+
+	if dropped != nil {
+		c.cfg.Log.WithFields(log.Fields{
+			"prefix":     "proxy.EndpointClient.bufferEvent",
+			"webhook_id": dropped.webhookID,
+		}).Warn("Event buffer is full, dropped the oldest event")
+		c.maybeWriteDeadLetter(ErrBufferFull, dropped.webhookID, dropped.body, dropped.headers)
+	}
+
+	if buffered {
+		c.cfg.Log.WithFields(log.Fields{
+			"prefix":     "proxy.EndpointClient.bufferEvent",
+			"webhook_id": evt.webhookID,
+		}).Debug("Buffered event until the local endpoint recovers")
+
+		if c.buffer.activate() {
+			c.scheduleFlush()
+		}
+	}
+
+	return buffered, err
+}
+
+// scheduleFlush flushes the buffer once the circuit breaker lets a trial
+// request through. The flush is abandoned if the client is closed first, so
+// that the goroutine doesn't outlive the client, e.g. on a fake clock that
+// is never advanced.
+func (c *EndpointClient) scheduleFlush() {
+	delay := c.breaker.retryIn()
+	if delay < minFlushDelay {
+		delay = minFlushDelay
+	}
+
+	go func() {
+		select {
+		case <-c.clock.After(delay):
+			c.flushBuffer()
+		case <-c.workersDone:
+		}
+	}()
+}
+
+// flushBuffer delivers the buffered events in order. It stops and schedules
+// another flush if the circuit breaker opens again, waits while the client
+// is paused, and stops for good when the client is closed.
+func (c *EndpointClient) flushBuffer() {
+	for {
+		// The wait isn't registered as an outstanding request so that it
+		// doesn't hold up Close, which drops the buffered events
+		if resumed := c.resumedChan(); resumed != nil {
+			select {
+			case <-resumed:
+			case <-c.workersDone:
+				return
+			}
+		}
+
+		ctx, cancel, err := c.begin(context.Background())
+		if err != nil {
+			return
+		}
+
+		evt := c.buffer.peek()
+		if evt == nil {
+			c.end(cancel)
+			return
+		}
+
+		_, err = c.deliver(ctx, evt, func() {})
+		if errors.Is(err, ErrCircuitOpen) {
+			c.end(cancel)
+			c.scheduleFlush()
+			return
+		}
+
+		c.buffer.pop()
+		c.maybeWriteDeadLetter(err, evt.webhookID, evt.body, evt.headers)
+		c.end(cancel)
+	}
+}
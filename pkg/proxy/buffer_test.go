@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/stripe-cli/pkg/proxy/proxytest"
+)
+
+func TestPostBuffersWhileCircuitOpen(t *testing.T) {
+	received := make(chan string, 4)
+	var failing int32 = 1
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("X-Event")
+		if atomic.CompareAndSwapInt32(&failing, 1, 0) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	clock := proxytest.NewFakeClock(time.Date(2019, 9, 1, 12, 0, 0, 0, time.UTC))
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		CircuitBreaker: CircuitBreakerPolicy{FailureThreshold: 1, Cooldown: 20 * time.Millisecond},
+		BufferSize:     10,
+		Clock:          clock,
+	})
+	require.Nil(t, err)
+
+	for _, evt := range []string{"evt_0", "evt_1", "evt_2", "evt_3"} {
+		err = client.Post("wh_123", "{}", map[string]string{"X-Event": evt})
+		require.Nil(t, err)
+	}
+	require.Equal(t, "evt_0", <-received)
+	require.Len(t, received, 0)
+
+	// The buffered events are flushed once the circuit breaker lets a trial
+	// request through
+	require.True(t, clock.WaitForWaiters(1, time.Second))
+	clock.Advance(minFlushDelay)
+	for _, evt := range []string{"evt_1", "evt_2", "evt_3"} {
+		require.Equal(t, evt, <-received)
+	}
+	require.Equal(t, CircuitClosed, client.CircuitState())
+}
+
+func TestPostBufferedSignatureNotReverified(t *testing.T) {
+	received := make(chan string, 2)
+	var failing int32 = 1
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("X-Event")
+		if atomic.CompareAndSwapInt32(&failing, 1, 0) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	clock := proxytest.NewFakeClock(time.Date(2019, 9, 1, 12, 0, 0, 0, time.UTC))
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		CircuitBreaker:  CircuitBreakerPolicy{FailureThreshold: 1, Cooldown: 20 * time.Millisecond},
+		BufferSize:      10,
+		VerifySignature: true,
+		Secret:          testSecret,
+		Clock:           clock,
+	})
+	require.Nil(t, err)
+
+	for _, evt := range []string{"evt_0", "evt_1"} {
+		err = client.Post("wh_123", "{}", map[string]string{
+			"X-Event":          evt,
+			"Stripe-Signature": signatureHeaderFor(clock.Now(), "{}", testSecret),
+		})
+		require.Nil(t, err)
+	}
+	require.Equal(t, "evt_0", <-received)
+
+	// The buffered event was verified when it arrived, so it is flushed even
+	// though its signature is now older than the tolerance
+	require.True(t, clock.WaitForWaiters(1, time.Second))
+	clock.Advance(2 * defaultSignatureTolerance)
+	require.Equal(t, "evt_1", <-received)
+}
+
+func TestPostBufferOverflow(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		CircuitBreaker: CircuitBreakerPolicy{FailureThreshold: 1, Cooldown: time.Minute},
+		BufferSize:     2,
+	})
+	require.Nil(t, err)
+
+	for _, webhookID := range []string{"wh_0", "wh_1", "wh_2", "wh_3"} {
+		require.Nil(t, client.Post(webhookID, "{}", map[string]string{}))
+	}
+
+	// The oldest buffered event was dropped
+	require.Len(t, client.buffer.events, 2)
+	require.Equal(t, "wh_2", client.buffer.events[0].webhookID)
+	require.Equal(t, "wh_3", client.buffer.events[1].webhookID)
+
+	require.Equal(t, 2, client.Close(context.Background()))
+}
+
+func TestPostBufferRejectNewest(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		CircuitBreaker: CircuitBreakerPolicy{FailureThreshold: 1, Cooldown: time.Minute},
+		BufferSize:     1,
+		BufferOverflow: RejectNewest,
+	})
+	require.Nil(t, err)
+
+	require.Nil(t, client.Post("wh_0", "{}", map[string]string{}))
+	require.Nil(t, client.Post("wh_1", "{}", map[string]string{}))
+	require.Equal(t, ErrBufferFull, client.Post("wh_2", "{}", map[string]string{}))
+
+	require.Len(t, client.buffer.events, 1)
+	require.Equal(t, "wh_1", client.buffer.events[0].webhookID)
+}
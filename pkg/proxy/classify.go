@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"net/http"
+)
+
+//
+// Public types
+//
+
+// Outcome is the classification of a response of the endpoint by the
+// ClassifyResponse function of an EndpointClient.
+type Outcome int
+
+// Possible outcomes of a response.
+const (
+	// OutcomeDefault leaves the response to the default classification,
+	// based on its status code
+	OutcomeDefault Outcome = iota
+
+	// OutcomeSuccess considers the event delivered
+	OutcomeSuccess
+
+	// OutcomeFailure considers the delivery failed, without retrying it
+	OutcomeFailure
+
+	// OutcomeRetry considers the delivery failed and retries it, following
+	// the backoff of the retry policy and up to its MaxAttempts
+	OutcomeRetry
+)
+
+//
+// Private functions
+//
+
+// classify sets the outcome of the response of the last attempt with
+// ClassifyResponse, if set. Responses of batches aren't classified, the
+// responses of their events are.
+func (c *EndpointClient) classify(d *delivery, resp *http.Response, err error) {
+	d.outcome = OutcomeDefault
+	if c.cfg.ClassifyResponse == nil || err != nil || d.batch != nil {
+		return
+	}
+
+	// The body stays available to the response handler
+	d.outcome = c.cfg.ClassifyResponse(resp, c.bufferResponse(resp))
+}
+
+// succeeded returns whether the response of the last attempt counts as a
+// success, according to ClassifyResponse if it classified it, and to the
+// success status codes otherwise. It is false for transport errors, whose
+// response is nil.
+func (c *EndpointClient) succeeded(d *delivery, resp *http.Response) bool {
+	if resp == nil {
+		return false
+	}
+	if d.outcome != OutcomeDefault {
+		return d.outcome == OutcomeSuccess
+	}
+
+	return c.isSuccess(resp.StatusCode)
+}
@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func classifyBody(resp *http.Response, body []byte) Outcome {
+	switch {
+	case strings.Contains(string(body), "temporary"):
+		return OutcomeRetry
+	case strings.Contains(string(body), "fatal"):
+		return OutcomeFailure
+	case strings.Contains(string(body), "ok"):
+		return OutcomeSuccess
+	}
+	return OutcomeDefault
+}
+
+func TestPostClassifyResponseRetry(t *testing.T) {
+	var attempts int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.Write([]byte(`{"error":"temporary"}`))
+			return
+		}
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	defer ts.Close()
+
+	var success bool
+	var body string
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		ClassifyResponse: classifyBody,
+		RetryPolicy:      RetryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond},
+		ResponseHandler: EndpointResponseHandlerV2Func(func(resp *EndpointResponse) {
+			buf, _ := ioutil.ReadAll(resp.Response.Body)
+			success = resp.Success
+			body = string(buf)
+		}),
+	})
+	require.Nil(t, err)
+
+	// 200 responses classified as retries are retried
+	require.Nil(t, client.Post("wh_123", `{"id":"evt_123"}`, map[string]string{}))
+	require.Equal(t, 3, attempts)
+	require.True(t, success)
+	require.Equal(t, `{"status":"ok"}`, body)
+
+	metrics := client.Metrics()
+	require.Equal(t, int64(3), metrics.Attempted)
+	require.Equal(t, int64(1), metrics.Succeeded)
+	require.Equal(t, int64(2), metrics.Failed)
+}
+
+func TestPostClassifyResponseFailure(t *testing.T) {
+	var attempts int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if r.URL.Query().Get("status") != "" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"error":"fatal"}`))
+	}))
+	defer ts.Close()
+
+	var success bool
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		ClassifyResponse: classifyBody,
+		FailOnNon2xx:     true,
+		RetryPolicy:      RetryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond},
+		ResponseHandler: EndpointResponseHandlerV2Func(func(resp *EndpointResponse) {
+			success = resp.Success
+		}),
+	})
+	require.Nil(t, err)
+
+	// Failures aren't retried, even though their status code is a success
+	err = client.Post("wh_123", `{"id":"evt_123"}`, map[string]string{})
+	var statusErr *HTTPStatusError
+	require.True(t, errors.As(err, &statusErr))
+	require.Equal(t, http.StatusOK, statusErr.Code)
+	require.Equal(t, 1, attempts)
+	require.False(t, success)
+	require.Equal(t, 1, client.FailureStreak())
+
+	// Responses left to the default classification are retried by the
+	// retry policy
+	attempts = 0
+	client, err = NewEndpointClient(ts.URL+"?status=500", false, []string{"*"}, &EndpointConfig{
+		ClassifyResponse: classifyBody,
+		RetryPolicy:      RetryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond},
+	})
+	require.Nil(t, err)
+	require.Nil(t, client.Post("wh_123", `{"id":"evt_123"}`, map[string]string{}))
+	require.Equal(t, 5, attempts)
+}
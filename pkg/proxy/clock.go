@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"time"
+)
+
+//
+// Public types
+//
+
+// Clock is the source of time of an EndpointClient. The backoff between
+// retries, the cooldown of the circuit breaker, the rate limiter, the retry
+// budget, the dedup cache, the wait of batches and the age of events are all
+// measured with it, so that tests can control time with a fake clock such as
+// proxytest.FakeClock. The timeouts of the requests aren't affected.
+type Clock interface {
+	Now() time.Time
+
+	// After waits for the duration to elapse and then sends the current
+	// time on the returned channel.
+	After(d time.Duration) <-chan time.Time
+}
+
+//
+// Private types
+//
+
+// realClock is the Clock of the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+//
+// Private functions
+//
+
+func newClock(clock Clock) Clock {
+	if clock == nil {
+		return realClock{}
+	}
+
+	return clock
+}
@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/stripe-cli/pkg/proxy/proxytest"
+)
+
+func TestPostBackoffFakeClock(t *testing.T) {
+	endpoint := proxytest.NewRecordingEndpoint()
+	defer endpoint.Close()
+	endpoint.SetDefault(proxytest.Response{StatusCode: http.StatusInternalServerError})
+
+	clock := proxytest.NewFakeClock(time.Date(2019, 9, 1, 12, 0, 0, 0, time.UTC))
+	client, err := NewEndpointClient(endpoint.URL, false, []string{"*"}, &EndpointConfig{
+		Clock:       clock,
+		RetryPolicy: RetryPolicy{MaxAttempts: 3, BaseDelay: time.Hour},
+	})
+	require.Nil(t, err)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Post("wh_123", "{}", map[string]string{})
+	}()
+
+	// The first retry waits for an hour of the clock
+	require.True(t, clock.WaitForWaiters(1, time.Second))
+	require.Equal(t, 1, endpoint.Count())
+	clock.Advance(59 * time.Minute)
+	require.Equal(t, 1, clock.Waiters())
+	clock.Advance(time.Minute)
+	require.True(t, endpoint.WaitForRequests(2, time.Second))
+
+	// The second retry waits for two hours
+	require.True(t, clock.WaitForWaiters(1, time.Second))
+	clock.Advance(2 * time.Hour)
+	require.True(t, endpoint.WaitForRequests(3, time.Second))
+
+	require.Nil(t, <-done)
+	require.Equal(t, 3, endpoint.Count())
+}
+
+func TestCircuitBreakerFakeClock(t *testing.T) {
+	clock := proxytest.NewFakeClock(time.Date(2019, 9, 1, 12, 0, 0, 0, time.UTC))
+	client, err := NewEndpointClient("http://localhost:0", false, []string{"*"}, &EndpointConfig{
+		Clock:          clock,
+		CircuitBreaker: CircuitBreakerPolicy{FailureThreshold: 1, Cooldown: time.Minute},
+	})
+	require.Nil(t, err)
+
+	require.NotNil(t, client.Post("wh_123", "{}", map[string]string{}))
+	require.Equal(t, CircuitOpen, client.CircuitState())
+	require.Equal(t, ErrCircuitOpen, client.Post("wh_123", "{}", map[string]string{}))
+
+	// The trial request is let through once the cooldown elapsed
+	clock.Advance(time.Minute)
+	err = client.Post("wh_123", "{}", map[string]string{})
+	require.NotNil(t, err)
+	require.NotEqual(t, ErrCircuitOpen, err)
+}
@@ -0,0 +1,223 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//
+// Public types
+//
+
+// RecordedEvent is an event as written to a dead letter file. Recorded
+// events can be replayed with their original timing by ReplayStream.
+type RecordedEvent struct {
+	WebhookID string            `json:"webhook_id"`
+	Body      string            `json:"body"`
+	Headers   map[string]string `json:"headers"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// ReplayDeadLetters re-posts every event of the dead letter file, as Post
+// does, except that the timestamps of their signatures aren't checked
+// against the tolerance of VerifySignature since they are as old as the
+// events. Events that are delivered with a success status code are removed
+// from the file, as are the events that are buffered, which are written back
+// to the file if the buffer drops them, while the others, including the
+// events that are filtered out, are kept for a later replay. It returns the
+// number of delivered events, and ErrClosed if the client was closed during
+// the replay.
+func (c *EndpointClient) ReplayDeadLetters(ctx context.Context) (int, error) {
+	c.replayMu.Lock()
+	defer c.replayMu.Unlock()
+
+	// The file isn't locked during the replay, so that the events that fail
+	// meanwhile can still be written to it
+	c.deadLetterMu.Lock()
+	lines, err := readLines(c.cfg.DeadLetterFile)
+	c.deadLetterMu.Unlock()
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	remaining := make([][]byte, 0)
+
+	var replayErr error
+	for i, line := range lines {
+		if replayErr = ctx.Err(); replayErr != nil {
+			remaining = append(remaining, lines[i:]...)
+			break
+		}
+
+		var evt RecordedEvent
+		if err := json.Unmarshal(line, &evt); err != nil {
+			c.cfg.Log.WithFields(log.Fields{
+				"prefix": "proxy.EndpointClient.ReplayDeadLetters",
+			}).Warn("Skipping malformed entry of the dead letter file")
+			remaining = append(remaining, line)
+			continue
+		}
+
+		result, err := c.postEvent(ctx, &bufferedEvent{
+			webhookID: evt.WebhookID,
+			body:      evt.Body,
+			headers:   evt.Headers,
+			replayed:  true,
+		})
+		switch {
+		case errors.Is(err, ErrClosed):
+			replayErr = err
+			remaining = append(remaining, lines[i:]...)
+		case err != nil:
+			remaining = append(remaining, line)
+		case result.buffered:
+			// The buffer delivers the event or writes it back to the file
+		case result.sent && !c.cfg.DryRun && c.isSuccess(result.statusCode):
+			delivered++
+		default:
+			remaining = append(remaining, line)
+		}
+		if replayErr != nil {
+			break
+		}
+	}
+
+	c.deadLetterMu.Lock()
+	defer c.deadLetterMu.Unlock()
+
+	current, err := readLines(c.cfg.DeadLetterFile)
+	if err != nil && !os.IsNotExist(err) {
+		return delivered, err
+	}
+	if len(current) > len(lines) {
+		remaining = append(remaining, current[len(lines):]...)
+	}
+	if err := rewriteLines(c.cfg.DeadLetterFile, remaining); err != nil {
+		return delivered, err
+	}
+
+	return delivered, replayErr
+}
+
+//
+// Private constants
+//
+
+// maxRecordedEventSize bounds the size of a line of the dead letter file
+const maxRecordedEventSize = 10 * 1024 * 1024
+
+//
+// Private functions
+//
+
+// maybeWriteDeadLetter writes the event to the dead letter file, if there is
+// one, when err is a delivery failure. Events that were rejected because of
+// their signature or size are not worth replaying.
+func (c *EndpointClient) maybeWriteDeadLetter(err error, webhookID string, body string, headers map[string]string) {
+	if err == nil || c.cfg.DeadLetterFile == "" || errors.Is(err, ErrSignatureMismatch) || errors.Is(err, ErrBodyTooLarge) {
+		return
+	}
+
+	c.writeDeadLetter(webhookID, body, headers)
+}
+
+// writeDeadLetter appends the event to the dead letter file. Each event is
+// written with a single call and synced to disk, so that a crash can't
+// corrupt the entries that were previously written.
+func (c *EndpointClient) writeDeadLetter(webhookID string, body string, headers map[string]string) {
+	line, err := json.Marshal(&RecordedEvent{
+		WebhookID: webhookID,
+		Body:      body,
+		Headers:   headers,
+		Timestamp: c.clock.Now(),
+	})
+	if err == nil {
+		c.deadLetterMu.Lock()
+		err = appendLine(c.cfg.DeadLetterFile, line)
+		c.deadLetterMu.Unlock()
+	}
+
+	if err != nil {
+		c.cfg.Log.WithFields(log.Fields{
+			"prefix":     "proxy.EndpointClient.writeDeadLetter",
+			"webhook_id": webhookID,
+		}).Errorf("Failed to write undelivered event to dead letter file, error = %v", err)
+	}
+}
+
+func appendLine(path string, line []byte) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(append(line, '\n'))
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+func readLines(path string) ([][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	lines := make([][]byte, 0)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, maxRecordedEventSize)
+	for scanner.Scan() {
+		if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+			lines = append(lines, append([]byte(nil), line...))
+		}
+	}
+
+	return lines, scanner.Err()
+}
+
+// rewriteLines atomically replaces the content of the file with the given
+// lines by writing them to a temporary file first.
+func rewriteLines(path string, lines [][]byte) error {
+	tmp, err := os.OpenFile(filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp"), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(tmp)
+	for _, line := range lines {
+		w.Write(line)     // #nosec G104
+		w.WriteByte('\n') // #nosec G104
+	}
+
+	err = w.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name()) // #nosec G104
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
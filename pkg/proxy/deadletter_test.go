@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadLetters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stripe-cli-proxy")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	deadLetterFile := filepath.Join(dir, "dead_letters.jsonl")
+
+	var up int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&up) == 0 {
+			// Simulate a server that drops the connection
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		require.Equal(t, "bar", r.Header.Get("Foo"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		DeadLetterFile: deadLetterFile,
+	})
+	require.Nil(t, err)
+
+	err = client.Post("wh_1", `{"id": "evt_1"}`, map[string]string{"Foo": "bar"})
+	require.NotNil(t, err)
+	err = client.Post("wh_2", `{"id": "evt_2"}`, map[string]string{"Foo": "bar"})
+	require.NotNil(t, err)
+
+	lines, err := readLines(deadLetterFile)
+	require.Nil(t, err)
+	require.Len(t, lines, 2)
+
+	// Nothing can be delivered while the server is down
+	delivered, err := client.ReplayDeadLetters(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, 0, delivered)
+
+	lines, err = readLines(deadLetterFile)
+	require.Nil(t, err)
+	require.Len(t, lines, 2)
+
+	atomic.StoreInt32(&up, 1)
+
+	delivered, err = client.ReplayDeadLetters(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, 2, delivered)
+
+	lines, err = readLines(deadLetterFile)
+	require.Nil(t, err)
+	require.Len(t, lines, 0)
+}
+
+func TestReplayDeadLettersMissingFile(t *testing.T) {
+	client, err := NewEndpointClient("http://localhost", false, []string{"*"}, &EndpointConfig{
+		DeadLetterFile: filepath.Join(os.TempDir(), "stripe-cli-proxy-missing.jsonl"),
+	})
+	require.Nil(t, err)
+
+	delivered, err := client.ReplayDeadLetters(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, 0, delivered)
+}
+
+func TestReplayDeadLettersSkipsFilteredEvents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stripe-cli-proxy")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	}))
+	defer ts.Close()
+
+	deadLetterFile := filepath.Join(dir, "dead_letters.jsonl")
+	old := time.Now().Add(-time.Hour)
+	for i, body := range []string{`{"id":"evt_1","livemode":true}`, `{"id":"evt_2","livemode":false}`} {
+		line, err := json.Marshal(&RecordedEvent{
+			WebhookID: fmt.Sprintf("wh_%d", i+1),
+			Body:      body,
+			Headers:   map[string]string{"Stripe-Signature": signatureHeaderFor(old, body, testSecret)},
+		})
+		require.Nil(t, err)
+		require.Nil(t, appendLine(deadLetterFile, line))
+	}
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		DeadLetterFile:  deadLetterFile,
+		VerifySignature: true,
+		Secret:          testSecret,
+		AllowLivemode:   true,
+	})
+	require.Nil(t, err)
+
+	// The old signatures are accepted, while the test mode event is kept
+	// for a later replay
+	delivered, err := client.ReplayDeadLetters(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, 1, delivered)
+	require.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	lines, err := readLines(deadLetterFile)
+	require.Nil(t, err)
+	require.Len(t, lines, 1)
+	require.Contains(t, string(lines[0]), "wh_2")
+}
+
+func TestReplayDeadLettersClosed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stripe-cli-proxy")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	deadLetterFile := filepath.Join(dir, "dead_letters.jsonl")
+	require.Nil(t, ioutil.WriteFile(deadLetterFile, []byte(`{"webhook_id":"wh_1","body":"{\"id\":\"evt_1\"}","headers":{}}`+"\n"), 0600))
+
+	client, err := NewEndpointClient("http://localhost", false, []string{"*"}, &EndpointConfig{
+		DeadLetterFile: deadLetterFile,
+	})
+	require.Nil(t, err)
+	client.Close(context.Background())
+
+	delivered, err := client.ReplayDeadLetters(context.Background())
+	require.Equal(t, ErrClosed, err)
+	require.Equal(t, 0, delivered)
+
+	lines, err := readLines(deadLetterFile)
+	require.Nil(t, err)
+	require.Len(t, lines, 1)
+}
@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+)
+
+//
+// Private types
+//
+
+// decompressingBody decodes a gzip or deflate response body. The decoder is
+// only created on the first read, so that empty bodies with a declared
+// encoding read as empty instead of failing.
+type decompressingBody struct {
+	body     io.ReadCloser
+	encoding string
+
+	// r is the decoder, which is nil until the first read
+	r   io.Reader
+	err error
+}
+
+func (b *decompressingBody) Read(p []byte) (int, error) {
+	if b.r == nil && b.err == nil {
+		b.r, b.err = newDecoder(bufio.NewReader(b.body), b.encoding)
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	return b.r.Read(p)
+}
+
+func (b *decompressingBody) Close() error {
+	return b.body.Close()
+}
+
+//
+// Private functions
+//
+
+// decompressResponse replaces the body of a response encoded with gzip or
+// deflate with its decoded body. Responses with another encoding are left
+// untouched.
+func decompressResponse(resp *http.Response) {
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if encoding != "gzip" && encoding != "x-gzip" && encoding != "deflate" {
+		return
+	}
+
+	resp.Body = &decompressingBody{body: resp.Body, encoding: encoding}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+}
+
+// newDecoder returns a reader decoding the body. The deflate encoding is
+// meant to be zlib-wrapped, but since some servers send raw deflate data,
+// the zlib header is checked before choosing between the two.
+func newDecoder(r *bufio.Reader, encoding string) (io.Reader, error) {
+	if _, err := r.Peek(1); err == io.EOF {
+		return strings.NewReader(""), nil
+	}
+
+	if encoding != "deflate" {
+		return gzip.NewReader(r)
+	}
+
+	header, err := r.Peek(2)
+	if err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(r)
+	}
+
+	return flate.NewReader(r), nil
+}
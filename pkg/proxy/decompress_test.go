@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostDecompressesResponse(t *testing.T) {
+	encode := map[string]func(w io.Writer) io.WriteCloser{
+		"gzip":    func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+		"deflate": func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) },
+		"raw": func(w io.Writer) io.WriteCloser {
+			fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+			return fw
+		},
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := r.URL.Query().Get("encoding")
+		if encoding == "raw" {
+			w.Header().Set("Content-Encoding", "deflate")
+		} else {
+			w.Header().Set("Content-Encoding", encoding)
+		}
+		if r.URL.Query().Get("empty") != "" {
+			return
+		}
+
+		var buf bytes.Buffer
+		zw := encode[encoding](&buf)
+		zw.Write([]byte(`{"received":true}`)) // #nosec G104
+		zw.Close()                            // #nosec G104
+		w.Write(buf.Bytes())                  // #nosec G104
+	}))
+	defer ts.Close()
+
+	post := func(query string, cfg *EndpointConfig) ([]byte, *http.Response) {
+		var body []byte
+		var rcv *http.Response
+		cfg.ResponseHandler = EndpointResponseHandlerFunc(func(webhookID string, resp *http.Response) {
+			rcv = resp
+			body, _ = ioutil.ReadAll(resp.Body)
+		})
+		client, err := NewEndpointClient(ts.URL+"?"+query, false, []string{"*"}, cfg)
+		require.Nil(t, err)
+		require.Nil(t, client.Post("wh_123", "{}", map[string]string{}))
+		return body, rcv
+	}
+
+	for _, encoding := range []string{"gzip", "deflate", "raw"} {
+		body, resp := post("encoding="+encoding, &EndpointConfig{})
+		require.Equal(t, `{"received":true}`, string(body), encoding)
+		require.Equal(t, "", resp.Header.Get("Content-Encoding"))
+
+		// The body is also decompressed before it's recorded
+		body, _ = post("encoding="+encoding, &EndpointConfig{DumpTraffic: true})
+		require.Equal(t, `{"received":true}`, string(body), encoding)
+	}
+
+	// A declared encoding with an empty body reads as empty
+	body, _ := post("encoding=gzip&empty=1", &EndpointConfig{})
+	require.Empty(t, body)
+
+	// The raw bytes are handed over when decompression is disabled
+	body, resp := post("encoding=gzip", &EndpointConfig{DisableResponseDecompression: true})
+	require.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	require.Nil(t, err)
+	decoded, err := ioutil.ReadAll(zr)
+	require.Nil(t, err)
+	require.Equal(t, `{"received":true}`, string(decoded))
+}
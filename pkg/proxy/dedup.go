@@ -0,0 +1,244 @@
+package proxy
+
+import (
+	"container/list"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+//
+// Private constants
+//
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+
+	defaultDedupTTL = 10 * time.Minute
+)
+
+//
+// Private types
+//
+
+// dedupCache is a bounded LRU of the IDs of recently delivered events.
+type dedupCache struct {
+	size  int
+	ttl   time.Duration
+	clock Clock
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+
+	// reserved holds the IDs of the events being delivered, which aren't
+	// in the LRU until their delivery succeeded
+	reserved map[string]bool
+
+	// order holds the entries from the most to the least recently delivered
+	order *list.List
+
+	// file is the path of the file where deliveries are recorded, if any.
+	// appended is the number of lines appended to it since it was last
+	// compacted.
+	file     string
+	appended int
+}
+
+type dedupEntry struct {
+	eventID     string
+	deliveredAt time.Time
+}
+
+// persistedDedupEntry is a delivery as recorded in the dedup file.
+type persistedDedupEntry struct {
+	EventID     string    `json:"event_id"`
+	DeliveredAt time.Time `json:"delivered_at"`
+}
+
+// seen returns whether the event was delivered within the TTL.
+func (c *dedupCache) seen(eventID string) bool {
+	if c == nil || eventID == "" {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.delivered(eventID)
+}
+
+// reserve returns false if the event was delivered within the TTL or is
+// being delivered. Otherwise it marks the event as being delivered, so that
+// its concurrent duplicates are deduped, until add or release is called.
+func (c *dedupCache) reserve(eventID string) bool {
+	if c == nil || eventID == "" {
+		return true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.reserved[eventID] || c.delivered(eventID) {
+		return false
+	}
+	c.reserved[eventID] = true
+
+	return true
+}
+
+// release gives up the reservation of an event whose delivery failed, so
+// that it can be delivered again. It does nothing once the delivery was
+// recorded by add.
+func (c *dedupCache) release(eventID string) {
+	if c == nil || eventID == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.reserved, eventID)
+}
+
+// add records the delivery of the event, evicting the least recently
+// delivered event if the cache is full. It returns an error if the delivery
+// couldn't be recorded in the dedup file.
+func (c *dedupCache) add(eventID string) error {
+	if c == nil || eventID == "" {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now()
+	delete(c.reserved, eventID)
+	c.insert(eventID, now)
+
+	if c.file == "" {
+		return nil
+	}
+
+	// The file is compacted once it holds many more lines than the cache
+	// holds entries, so that its size stays bounded
+	if c.appended >= 2*c.size {
+		return c.compact()
+	}
+
+	line, err := json.Marshal(&persistedDedupEntry{EventID: eventID, DeliveredAt: now})
+	if err != nil {
+		return err
+	}
+	c.appended++
+
+	return appendLine(c.file, line)
+}
+
+// persist loads the deliveries recorded in the file, ignoring those older
+// than the TTL, and records the following deliveries in it.
+func (c *dedupCache) persist(path string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	lines, err := readLines(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, line := range lines {
+		var entry persistedDedupEntry
+		if json.Unmarshal(line, &entry) != nil || entry.EventID == "" {
+			continue
+		}
+		if c.clock.Now().Sub(entry.DeliveredAt) <= c.ttl {
+			c.insert(entry.EventID, entry.DeliveredAt)
+		}
+	}
+
+	c.file = path
+
+	return c.compact()
+}
+
+// delivered returns whether the event was delivered within the TTL,
+// forgetting it if it was delivered before. It must be called with the lock
+// held.
+func (c *dedupCache) delivered(eventID string) bool {
+	elem, ok := c.entries[eventID]
+	if !ok {
+		return false
+	}
+
+	if c.clock.Now().Sub(elem.Value.(*dedupEntry).deliveredAt) > c.ttl {
+		c.order.Remove(elem)
+		delete(c.entries, eventID)
+		return false
+	}
+
+	return true
+}
+
+// insert must be called with the lock held.
+func (c *dedupCache) insert(eventID string, deliveredAt time.Time) {
+	if elem, ok := c.entries[eventID]; ok {
+		elem.Value.(*dedupEntry).deliveredAt = deliveredAt
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[eventID] = c.order.PushFront(&dedupEntry{eventID: eventID, deliveredAt: deliveredAt})
+
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*dedupEntry).eventID)
+	}
+}
+
+// compact rewrites the dedup file with the entries of the cache that are
+// within the TTL, from the least to the most recently delivered. It must be
+// called with the lock held.
+func (c *dedupCache) compact() error {
+	lines := make([][]byte, 0, c.order.Len())
+	for elem := c.order.Back(); elem != nil; elem = elem.Prev() {
+		entry := elem.Value.(*dedupEntry)
+		if c.clock.Now().Sub(entry.deliveredAt) > c.ttl {
+			continue
+		}
+
+		line, err := json.Marshal(&persistedDedupEntry{EventID: entry.eventID, DeliveredAt: entry.deliveredAt})
+		if err != nil {
+			return err
+		}
+		lines = append(lines, line)
+	}
+
+	c.appended = 0
+
+	return rewriteLines(c.file, lines)
+}
+
+//
+// Private functions
+//
+
+// newDedupCache returns a cache of the given size, or nil if size is zero,
+// which disables deduplication.
+func newDedupCache(size int, ttl time.Duration, clock Clock) *dedupCache {
+	if size <= 0 {
+		return nil
+	}
+	if ttl <= 0 {
+		ttl = defaultDedupTTL
+	}
+
+	return &dedupCache{
+		size:     size,
+		ttl:      ttl,
+		clock:    clock,
+		entries:  make(map[string]*list.Element),
+		reserved: make(map[string]bool),
+		order:    list.New(),
+	}
+}
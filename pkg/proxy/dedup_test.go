@@ -0,0 +1,223 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/stripe-cli/pkg/proxy/proxytest"
+)
+
+func TestDedupCacheEviction(t *testing.T) {
+	cache := newDedupCache(2, time.Minute, realClock{})
+
+	cache.add("evt_1")
+	cache.add("evt_2")
+	require.True(t, cache.seen("evt_1"))
+
+	// evt_2 is the least recently delivered event
+	cache.add("evt_1")
+	cache.add("evt_3")
+	require.True(t, cache.seen("evt_1"))
+	require.False(t, cache.seen("evt_2"))
+	require.True(t, cache.seen("evt_3"))
+}
+
+func TestDedupCacheTTL(t *testing.T) {
+	clock := proxytest.NewFakeClock(time.Now())
+	cache := newDedupCache(10, 10*time.Millisecond, clock)
+
+	cache.add("evt_1")
+	require.True(t, cache.seen("evt_1"))
+
+	clock.Advance(15 * time.Millisecond)
+	require.False(t, cache.seen("evt_1"))
+}
+
+func TestDedupCacheDisabled(t *testing.T) {
+	cache := newDedupCache(0, 0, realClock{})
+
+	cache.add("evt_1")
+	require.False(t, cache.seen("evt_1"))
+}
+
+func TestDedupCacheReserve(t *testing.T) {
+	cache := newDedupCache(10, time.Minute, realClock{})
+
+	require.True(t, cache.reserve("evt_1"))
+	require.False(t, cache.reserve("evt_1"))
+	require.False(t, cache.seen("evt_1"))
+
+	// A failed delivery can be retried
+	cache.release("evt_1")
+	require.True(t, cache.reserve("evt_1"))
+
+	// A delivered event stays deduped after its reservation is released
+	cache.add("evt_1")
+	cache.release("evt_1")
+	require.False(t, cache.reserve("evt_1"))
+	require.True(t, cache.seen("evt_1"))
+
+	var disabled *dedupCache
+	require.True(t, disabled.reserve("evt_1"))
+	require.True(t, disabled.reserve("evt_1"))
+}
+
+func TestPostDedupConcurrent(t *testing.T) {
+	var count int32
+	arrived := make(chan struct{}, 1)
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		arrived <- struct{}{}
+		<-release
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		DedupSize: 10,
+	})
+	require.Nil(t, err)
+
+	// The duplicate is posted while the event is being delivered
+	done := make(chan error, 1)
+	go func() { done <- client.Post("wh_1", `{"id":"evt_123"}`, map[string]string{}) }()
+	<-arrived
+	require.Nil(t, client.Post("wh_2", `{"id":"evt_123"}`, map[string]string{}))
+
+	close(release)
+	require.Nil(t, <-done)
+	require.Equal(t, int32(1), atomic.LoadInt32(&count))
+}
+
+func TestPostDedup(t *testing.T) {
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		require.Equal(t, "evt_123", r.Header.Get("Idempotency-Key"))
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		IdempotencyKeys: true,
+		DedupSize:       10,
+	})
+	require.Nil(t, err)
+
+	for i := 0; i < 3; i++ {
+		err = client.Post("wh_123", `{"id":"evt_123"}`, map[string]string{})
+		require.Nil(t, err)
+	}
+	require.Equal(t, int32(1), atomic.LoadInt32(&count))
+}
+
+func TestPostDedupFailedDelivery(t *testing.T) {
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		DedupSize: 10,
+	})
+	require.Nil(t, err)
+
+	// Events that weren't delivered aren't deduped
+	for i := 0; i < 2; i++ {
+		err = client.Post("wh_123", `{"id":"evt_123"}`, map[string]string{})
+		require.Nil(t, err)
+	}
+	require.Equal(t, int32(2), atomic.LoadInt32(&count))
+}
+
+func TestDedupCachePersist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stripe-cli-proxy")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	dedupFile := filepath.Join(dir, "dedup.jsonl")
+
+	old, err := json.Marshal(&persistedDedupEntry{EventID: "evt_old", DeliveredAt: time.Now().Add(-time.Hour)})
+	require.Nil(t, err)
+	require.Nil(t, appendLine(dedupFile, old))
+	require.Nil(t, appendLine(dedupFile, []byte("not json")))
+
+	cache := newDedupCache(10, time.Minute, realClock{})
+	require.Nil(t, cache.persist(dedupFile))
+	require.False(t, cache.seen("evt_old"))
+	require.Nil(t, cache.add("evt_1"))
+	require.Nil(t, cache.add("evt_2"))
+
+	// A new cache, e.g. after a restart, remembers the deliveries
+	cache = newDedupCache(10, time.Minute, realClock{})
+	require.Nil(t, cache.persist(dedupFile))
+	require.True(t, cache.seen("evt_1"))
+	require.True(t, cache.seen("evt_2"))
+
+	// Expired and malformed entries were pruned
+	lines, err := readLines(dedupFile)
+	require.Nil(t, err)
+	require.Len(t, lines, 2)
+}
+
+func TestDedupCachePersistCompaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stripe-cli-proxy")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	dedupFile := filepath.Join(dir, "dedup.jsonl")
+
+	cache := newDedupCache(2, time.Minute, realClock{})
+	require.Nil(t, cache.persist(dedupFile))
+
+	for i := 0; i < 10; i++ {
+		require.Nil(t, cache.add(fmt.Sprintf("evt_%d", i)))
+	}
+
+	lines, err := readLines(dedupFile)
+	require.Nil(t, err)
+	require.True(t, len(lines) <= 4)
+
+	cache = newDedupCache(2, time.Minute, realClock{})
+	require.Nil(t, cache.persist(dedupFile))
+	require.True(t, cache.seen("evt_9"))
+	require.True(t, cache.seen("evt_8"))
+	require.False(t, cache.seen("evt_7"))
+}
+
+func TestPostDedupFile(t *testing.T) {
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "stripe-cli-proxy")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := func() *EndpointConfig {
+		return &EndpointConfig{
+			DedupSize: 10,
+			DedupFile: filepath.Join(dir, "dedup.jsonl"),
+		}
+	}
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, cfg())
+	require.Nil(t, err)
+	require.Nil(t, client.Post("wh_123", `{"id":"evt_123"}`, map[string]string{}))
+
+	client, err = NewEndpointClient(ts.URL, false, []string{"*"}, cfg())
+	require.Nil(t, err)
+	require.Nil(t, client.Post("wh_123", `{"id":"evt_123"}`, map[string]string{}))
+	require.Equal(t, int32(1), atomic.LoadInt32(&count))
+}
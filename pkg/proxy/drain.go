@@ -0,0 +1,163 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//
+// Public variables
+//
+
+// ErrClosed is returned by EndpointClient.Post once the client was closed.
+var ErrClosed = errors.New("endpoint client is closed")
+
+//
+// Public functions
+//
+
+// Close stops accepting new events and waits for the outstanding requests,
+// including those waiting for the rate limiter or for a retry, to complete.
+// When the context is done before that, the outstanding requests are
+// canceled. Events still buffered because the circuit is open or the client
+// is paused are dropped. The keepalive probes are stopped. It returns the
+// number of requests that were canceled or dropped.
+func (c *EndpointClient) Close(ctx context.Context) int {
+	c.drainMu.Lock()
+	c.closed = true
+	c.drainMu.Unlock()
+
+	if c.batcher != nil {
+		c.batcher.close()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		c.outstanding.Wait()
+		c.stopWorkers.Do(func() { close(c.workersDone) })
+		c.cancelBackground()
+		c.background.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return c.dropBuffer()
+	case <-ctx.Done():
+	}
+
+	dropped := int(atomic.LoadInt32(&c.outstandingCount))
+	c.stopOnce.Do(func() { close(c.stopped) })
+	<-done
+
+	c.cfg.Log.WithFields(log.Fields{
+		"prefix":  "proxy.EndpointClient.Close",
+		"dropped": dropped,
+	}).Warn("Timed out waiting for outstanding requests to local endpoint, canceled them")
+
+	return dropped + c.dropBuffer()
+}
+
+// Close closes all the clients concurrently, as EndpointClient.Close does,
+// and returns the total number of canceled requests.
+func (c *MultiEndpointClient) Close(ctx context.Context) int {
+	return closeAll(ctx, c.clients)
+}
+
+// Close closes the primary and backup clients concurrently, as
+// EndpointClient.Close does, and returns the total number of canceled
+// requests.
+func (c *FailoverClient) Close(ctx context.Context) int {
+	return closeAll(ctx, []*EndpointClient{c.primary, c.backup})
+}
+
+//
+// Private functions
+//
+
+// begin registers an outstanding request. It returns a context that is
+// canceled if the client is closed before the request completes, and must be
+// followed by a call to end.
+func (c *EndpointClient) begin(ctx context.Context) (context.Context, context.CancelFunc, error) {
+	c.drainMu.Lock()
+	defer c.drainMu.Unlock()
+
+	if c.closed {
+		return nil, nil, ErrClosed
+	}
+
+	c.outstanding.Add(1)
+	atomic.AddInt32(&c.outstandingCount, 1)
+
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-c.stopped:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel, nil
+}
+
+func (c *EndpointClient) end(cancel context.CancelFunc) {
+	cancel()
+	atomic.AddInt32(&c.outstandingCount, -1)
+	c.outstanding.Done()
+}
+
+// hold registers work done in the background for outstanding requests, e.g.
+// a pending batch, so that Close waits for it. It isn't counted as a request
+// when Close times out, and must be called while the request that starts
+// the work is outstanding. The returned function must be called once the
+// work is done.
+func (c *EndpointClient) hold() func() {
+	c.outstanding.Add(1)
+	return c.outstanding.Done
+}
+
+// dropBuffer empties the buffer of a closed client, writing its events to
+// the dead letter file if there is one. It returns the number of dropped
+// events.
+func (c *EndpointClient) dropBuffer() int {
+	if c.buffer == nil {
+		return 0
+	}
+
+	events := c.buffer.take()
+	for _, evt := range events {
+		c.maybeWriteDeadLetter(ErrClosed, evt.webhookID, evt.body, evt.headers)
+	}
+
+	if len(events) > 0 {
+		c.cfg.Log.WithFields(log.Fields{
+			"prefix":  "proxy.EndpointClient.Close",
+			"dropped": len(events),
+		}).Warn("Dropped events buffered while the local endpoint was unavailable")
+	}
+
+	return len(events)
+}
+
+// closeAll closes the clients concurrently and returns the total number of
+// canceled requests.
+func closeAll(ctx context.Context, clients []*EndpointClient) int {
+	var dropped int32
+
+	done := make(chan struct{}, len(clients))
+	for _, client := range clients {
+		go func(client *EndpointClient) {
+			atomic.AddInt32(&dropped, int32(client.Close(ctx)))
+			done <- struct{}{}
+		}(client)
+	}
+	for range clients {
+		<-done
+	}
+
+	return int(atomic.LoadInt32(&dropped))
+}
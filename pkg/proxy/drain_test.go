@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloseWaitsForOutstandingRequests(t *testing.T) {
+	received := make(chan struct{})
+	unblock := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(received)
+		<-unblock
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, nil)
+	require.Nil(t, err)
+
+	errs := make(chan error)
+	go func() {
+		errs <- client.Post("wh_123", "{}", map[string]string{})
+	}()
+	<-received
+
+	canceled := make(chan int)
+	go func() { canceled <- client.Close(context.Background()) }()
+	select {
+	case <-canceled:
+		require.Fail(t, "Close returned before the outstanding request was done")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(unblock)
+	require.Nil(t, <-errs)
+	require.Equal(t, 0, <-canceled)
+
+	err = client.Post("wh_123", "{}", map[string]string{})
+	require.Equal(t, ErrClosed, err)
+}
+
+func TestCloseDeadline(t *testing.T) {
+	received := make(chan struct{})
+	unblock := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(received)
+		<-unblock
+	}))
+	defer ts.Close()
+	defer close(unblock)
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, nil)
+	require.Nil(t, err)
+
+	errs := make(chan error)
+	go func() {
+		errs <- client.Post("wh_123", "{}", map[string]string{})
+	}()
+	<-received
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	require.Equal(t, 1, client.Close(ctx))
+	require.Equal(t, context.Canceled, <-errs)
+}
+
+func TestMultiEndpointClientClose(t *testing.T) {
+	client, err := NewMultiEndpointClient([]string{"http://localhost:1", "http://localhost:2"}, false, []string{"*"}, nil)
+	require.Nil(t, err)
+
+	require.Equal(t, 0, client.Close(context.Background()))
+
+	err = client.Post("wh_123", "{}", map[string]string{})
+	require.NotNil(t, err)
+	require.Equal(t, ErrClosed, err.(*MultiEndpointError).Errors["http://localhost:1"])
+}
@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//
+// Private functions
+//
+
+// dryRun logs the request of the delivery instead of sending it and returns
+// a synthetic 200 response.
+func (c *EndpointClient) dryRun(ctx context.Context, d *delivery) (*http.Response, error) {
+	req, err := c.newRequest(ctx, d, c.pickTarget(nil), 1)
+
+	var hookErr *beforePostError
+	if errors.As(err, &hookErr) {
+		return nil, hookErr.err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	header := req.Header.Clone()
+	c.redactHeaders(header)
+
+	c.cfg.Log.WithFields(d.logFields(log.Fields{
+		"url":       req.URL.String(),
+		"headers":   header,
+		"body_size": d.size(),
+	})).Info("Dry run, not forwarding event")
+
+	resp := &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}
+
+	if handler, ok := c.cfg.ResponseHandler.(EndpointResponseActionHandler); ok {
+		d.action = handler.ProcessEndpointResponseAction(c.endpointResponse(d, resp))
+	}
+
+	return resp, nil
+}
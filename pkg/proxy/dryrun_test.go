@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostDryRun(t *testing.T) {
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+	}))
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	logger := log.New()
+	logger.Out = &buf
+
+	rcvStatus := 0
+	client, err := NewEndpointClient(ts.URL+"/webhooks", false, []string{"*"}, &EndpointConfig{
+		DryRun:      true,
+		Log:         logger,
+		BearerToken: "secret_token",
+		ResponseHandler: EndpointResponseHandlerFunc(func(webhookID string, resp *http.Response) {
+			rcvStatus = resp.StatusCode
+		}),
+	})
+	require.Nil(t, err)
+
+	err = client.Post("wh_123", `{"id":"evt_123","type":"invoice.paid"}`, map[string]string{
+		"Stripe-Signature": "t=123,v1=abc",
+	})
+	require.Nil(t, err)
+	require.Equal(t, http.StatusOK, rcvStatus)
+	require.Equal(t, int32(0), atomic.LoadInt32(&count))
+
+	output := buf.String()
+	require.Contains(t, output, "Dry run, not forwarding event")
+	require.Contains(t, output, ts.URL+"/webhooks")
+	require.Contains(t, output, "invoice.paid")
+	require.NotContains(t, output, "secret_token")
+	require.NotContains(t, output, "v1=abc")
+}
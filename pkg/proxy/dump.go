@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//
+// Private constants
+//
+
+const redacted = "[REDACTED]"
+
+//
+// Private functions
+//
+
+// dumpRequest logs the request as sent on the wire. body is the request
+// body, which can't be read from the request without consuming it.
+func (c *EndpointClient) dumpRequest(req *http.Request, body []byte) {
+	dumpReq := req.Clone(req.Context())
+	dumpReq.Body = ioutil.NopCloser(bytes.NewReader(body))
+	c.redactHeaders(dumpReq.Header)
+
+	dump, err := httputil.DumpRequestOut(dumpReq, true)
+	if err != nil {
+		c.cfg.Log.WithFields(log.Fields{
+			"prefix": "proxy.EndpointClient.dumpRequest",
+			"error":  err,
+		}).Debug("Failed to dump request")
+		return
+	}
+
+	c.cfg.Log.WithFields(log.Fields{
+		"prefix": "proxy.EndpointClient.dumpRequest",
+	}).Debugf("Request to local endpoint:\n%s", dump)
+}
+
+// dumpResponse logs the response as received on the wire. Its body must
+// have been buffered.
+func (c *EndpointClient) dumpResponse(resp *http.Response) {
+	dump, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		c.cfg.Log.WithFields(log.Fields{
+			"prefix": "proxy.EndpointClient.dumpResponse",
+			"error":  err,
+		}).Debug("Failed to dump response")
+		return
+	}
+
+	c.cfg.Log.WithFields(log.Fields{
+		"prefix": "proxy.EndpointClient.dumpResponse",
+	}).Debugf("Response from local endpoint:\n%s", dump)
+}
+
+// redactHeaders hides the signature, unless configured otherwise, and the
+// credentials and cookies of the request.
+func (c *EndpointClient) redactHeaders(header http.Header) {
+	if !c.cfg.DumpSignature && header.Get("Stripe-Signature") != "" {
+		header.Set("Stripe-Signature", redacted)
+	}
+	for _, name := range []string{"Authorization", "Cookie"} {
+		if header.Get(name) != "" {
+			header.Set(name, redacted)
+		}
+	}
+}
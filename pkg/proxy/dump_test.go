@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostDumpTraffic(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "t=123,v1=hunter2", r.Header.Get("Stripe-Signature"))
+		w.Header().Set("X-Response-Header", "baz")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK!"))
+	}))
+	defer ts.Close()
+
+	var out bytes.Buffer
+	logger := log.New()
+	logger.SetOutput(&out)
+	logger.SetLevel(log.DebugLevel)
+
+	rcvBody := ""
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		DumpTraffic: true,
+		BearerToken: "sk_dev_secret",
+		Cookies:     []*http.Cookie{{Name: "session", Value: "session_secret"}},
+		Log:         logger,
+		ResponseHandler: EndpointResponseHandlerFunc(func(webhookID string, resp *http.Response) {
+			buf := new(bytes.Buffer)
+			buf.ReadFrom(resp.Body)
+			rcvBody = buf.String()
+		}),
+	})
+	require.Nil(t, err)
+
+	err = client.Post("wh_123", `{"id": "evt_123"}`, map[string]string{
+		"Stripe-Signature": "t=123,v1=hunter2",
+	})
+	require.Nil(t, err)
+
+	require.Equal(t, "OK!", rcvBody)
+	require.Contains(t, out.String(), "evt_123")
+	require.Contains(t, out.String(), "Stripe-Signature: [REDACTED]")
+	require.NotContains(t, out.String(), "hunter2")
+	require.Contains(t, out.String(), "Authorization: [REDACTED]")
+	require.NotContains(t, out.String(), "sk_dev_secret")
+	require.Contains(t, out.String(), "Cookie: [REDACTED]")
+	require.NotContains(t, out.String(), "session_secret")
+	require.Contains(t, out.String(), "X-Response-Header: baz")
+	require.Contains(t, out.String(), "OK!")
+}
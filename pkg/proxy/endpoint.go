@@ -2,24 +2,629 @@ package proxy
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/stripe/stripe-cli/pkg/stripe"
+	"github.com/stripe/stripe-cli/pkg/version"
 )
 
+//
+// Public variables
+//
+
+// ErrBodyTooLarge is returned by EndpointClient.Post when the event is larger
+// than the configured MaxRequestBodyBytes.
+var ErrBodyTooLarge = errors.New("event body is too large")
+
+// ErrNotAcknowledged is returned by EndpointClient.Post when the response
+// handler asked for the event to be retried but no attempts were left.
+var ErrNotAcknowledged = errors.New("event was not acknowledged by the response handler")
+
 //
 // Public types
 //
 
 // EndpointConfig contains the optional configuration parameters of an EndpointClient.
 type EndpointConfig struct {
+	// HTTPClient sends the requests to the endpoint. Unlike
+	// http.DefaultClient, the client built when it isn't set doesn't follow
+	// redirects by itself, since that would turn the POST of a 301, 302 or
+	// 303 into a GET without the event. Redirects are instead handled as
+	// configured by FollowRedirects.
 	HTTPClient *http.Client
 
+	// ClientCertFile and ClientKeyFile are the paths of the PEM encoded
+	// certificate and key presented to endpoints that require mutual TLS.
+	// They are only used when HTTPClient is not set.
+	ClientCertFile string
+	ClientKeyFile  string
+
+	// CACertFile is the path of a PEM encoded bundle of certificate
+	// authorities used to verify the endpoint's certificate instead of the
+	// system pool. It is only used when HTTPClient is not set.
+	CACertFile string
+
+	// InsecureSkipVerify disables the verification of the endpoint's
+	// certificate, e.g. for local servers using a self-signed certificate.
+	// It is only used when HTTPClient is not set.
+	InsecureSkipVerify bool
+
+	// AllowRemote silences the warning logged when the client is created
+	// and the host of a target resolves to an address that is neither a
+	// loopback nor a private address, for intentional forwarding to a
+	// remote server.
+	AllowRemote bool
+
+	// ForceHTTP2 makes the client only speak HTTP/2 to the endpoint, using
+	// ALPN negotiation over TLS and prior knowledge (h2c) over cleartext
+	// connections. It is only used when HTTPClient is not set.
+	ForceHTTP2 bool
+
+	// ProxyURL is the URL of an http, https or socks5 proxy through which
+	// requests are sent, with optional credentials in its userinfo. The
+	// HTTPS_PROXY and HTTP_PROXY environment variables aren't used, since
+	// they usually point at a proxy that can't reach the local endpoint. It
+	// is only used when HTTPClient is not set.
+	ProxyURL string
+
+	// MaxIdleConns and MaxIdleConnsPerHost cap the number of idle
+	// connections kept open for reuse, in total and per host. They default
+	// to 100, so that bursts of events to a local endpoint reuse their
+	// connections. They are only used when HTTPClient is not set and
+	// ForceHTTP2 isn't.
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+
+	// DialTimeout bounds the time it takes to connect to the endpoint,
+	// independently of Timeout, which also covers the time the endpoint
+	// takes to respond. Defaults to 30 seconds. It is only used when
+	// HTTPClient is not set.
+	DialTimeout time.Duration
+
+	// IdleConnTimeout is how long an idle connection is kept open. Defaults
+	// to 90 seconds. It is only used when HTTPClient is not set and
+	// ForceHTTP2 isn't.
+	IdleConnTimeout time.Duration
+
+	// ConnMaxLifetime, if set, recycles connections once they have been open
+	// for that long, e.g. so that connections to a dev server that restarts
+	// often don't go stale and fail with "connection reset". Requests are
+	// transparently sent on a new connection when theirs expired, except
+	// for streamed bodies that can't be rewound, whose attempt fails. It is
+	// only used when HTTPClient is not set and ForceHTTP2 isn't.
+	ConnMaxLifetime time.Duration
+
+	// CookieJar, if set, stores the cookies set by the endpoint and sends
+	// them back with forwarded requests, e.g. a session cookie. It is only
+	// used when HTTPClient is not set.
+	CookieJar http.CookieJar
+
+	// Cookies are sent with every forwarded request, in addition to the
+	// cookies of CookieJar.
+	Cookies []*http.Cookie
+
+	// DisableKeepAlives makes the client open a new connection for every
+	// request. It is only used when HTTPClient is not set and ForceHTTP2
+	// isn't.
+	DisableKeepAlives bool
+
+	// FollowRedirects makes the client follow the 307 and 308 redirects of
+	// the endpoint, sending the event again with its body to their location.
+	// Other redirects, e.g. 301 and 302, aren't followed since they would
+	// turn the POST into a GET. Post returns a *RedirectError, once the
+	// response handler was invoked, for the redirects that aren't followed,
+	// including all of them when FollowRedirects isn't set. The
+	// Authorization and Cookie headers aren't sent to other hosts.
+	// Redirects followed by a custom HTTPClient aren't affected.
+	FollowRedirects bool
+
+	// MaxRedirects is the number of redirects followed for every attempt
+	// when FollowRedirects is set. Defaults to 10.
+	MaxRedirects int
+
+	// Timeout is the timeout of the HTTP client built when HTTPClient is not
+	// set. Defaults to 30 seconds.
+	Timeout time.Duration
+
+	// PayloadTimeout, if set, gives each attempt a deadline that grows with
+	// the size of the request body. The overall Timeout still applies.
+	PayloadTimeout PayloadTimeout
+
+	// PerAttemptTimeout is the deadline of each attempt, so that a slow
+	// attempt doesn't starve the retries. When PayloadTimeout is also set,
+	// the shorter deadline applies. Zero means no deadline.
+	PerAttemptTimeout time.Duration
+
+	// TimeoutForEvent, if set, returns the deadline of each attempt at
+	// forwarding an event of the given type, e.g. a longer one for events
+	// whose handlers do heavy work. Timeout applies to the events for which
+	// it returns zero and to batches. It replaces Timeout as the timeout of
+	// the HTTP client built when HTTPClient is not set, so that it may
+	// exceed it. When PayloadTimeout or PerAttemptTimeout is also set, the
+	// shorter deadline applies.
+	TimeoutForEvent func(eventType string) time.Duration
+
+	// OverallTimeout caps the time spent forwarding an event, across all its
+	// attempts and the delays between them. Zero means no cap.
+	OverallTimeout time.Duration
+
 	Log *log.Logger
 
 	ResponseHandler EndpointResponseHandler
+
+	// FailOnNon2xx makes Post return an *HTTPStatusError when the endpoint
+	// responds with a status code that isn't one of SuccessStatusCodes,
+	// once the response handler was invoked.
+	FailOnNon2xx bool
+
+	// SuccessStatusCodes are the status codes with which the endpoint
+	// accepts an event, e.g. only 202 for endpoints that process events
+	// asynchronously. They default to all 2xx status codes. They classify
+	// the responses for the metrics, FailOnNon2xx, the Success field of
+	// EndpointResponse, the circuit breaker and the retries: responses
+	// with a success status code are never retried, even if the code is one
+	// of the RetryableStatusCodes of the RetryPolicy, while the other
+	// responses are retried according to the RetryPolicy.
+	SuccessStatusCodes []int
+
+	// ClassifyResponse, if set, classifies every response of the endpoint
+	// from the response and its body, e.g. for endpoints that always respond
+	// with 200 and report errors in the body. Its outcome replaces the
+	// classification by status code for the metrics, FailOnNon2xx, the
+	// Success field of EndpointResponse, the circuit breaker and the
+	// retries: OutcomeRetry retries the event following the backoff of the
+	// RetryPolicy, up to its MaxAttempts, while OutcomeSuccess and
+	// OutcomeFailure are never retried. Outcomes other than OutcomeSuccess
+	// count as failures for the circuit breaker. OutcomeDefault leaves the
+	// response to the classification by status code. The body is read up to
+	// MaxResponseBodyBytes and remains available to the response handler.
+	// The response handler's actions, if any, still take precedence.
+	ClassifyResponse func(resp *http.Response, body []byte) Outcome
+
+	// OnError, if set, is called when Post fails to send an event to the
+	// endpoint because of a transport error, once all the attempts are
+	// exhausted, for non-2xx responses when FailOnNon2xx is set and for
+	// redirects that aren't followed. err is the error returned by Post.
+	OnError func(webhookID string, eventType string, err error)
+
+	// DryRun makes the client log the requests it would send, with their
+	// credentials redacted, instead of sending them. The response handler
+	// receives an empty 200 response for every event.
+	DryRun bool
+
+	// ExcludedEvents is a list of event types that are never forwarded, even
+	// if they are matched by the list of events of the client. It supports the
+	// same wildcard patterns as the list of events. Exclusion wins over
+	// inclusion.
+	ExcludedEvents []string
+
+	// LogSkipped logs every event that isn't forwarded because of its type,
+	// its connect mode, its age or sampling, at info level along with the
+	// reason, so that misconfigured filters are easy to spot. Otherwise only
+	// some of these events are logged, at debug level.
+	LogSkipped bool
+
+	// Clock, if set, is the source of time used for the backoff between
+	// retries, the circuit breaker, the rate limiter and the other time-based
+	// features, e.g. a fake clock in tests. Defaults to the system clock.
+	Clock Clock
+
+	// RetryPolicy controls how requests that failed with a connection error
+	// or a 5xx response are retried. The zero value disables retries.
+	RetryPolicy RetryPolicy
+
+	// RetryBudget is the number of retries that the client can make in a
+	// burst, across all events. Every retry uses a token of the budget,
+	// which refills at RetryBudgetRefillRate tokens per second, and failed
+	// attempts aren't retried while the budget is exhausted. Zero means no
+	// budget.
+	RetryBudget int
+
+	// RetryBudgetRefillRate defaults to 1 token per second
+	RetryBudgetRefillRate float64
+
+	// MetricsSink, if set, is notified after every attempt
+	MetricsSink MetricsSink
+
+	// PrometheusRegistry, if set, gets the forwarding metrics registered by
+	// RegisterPrometheusMetrics, which count every attempt and its duration,
+	// to be exposed to Prometheus with PrometheusHandler. Several clients may
+	// share the same registry.
+	PrometheusRegistry *prometheus.Registry
+
+	// TracerProvider, if set, provides the OpenTelemetry tracer with which a
+	// client span is started around every attempt. The trace context is
+	// propagated to the endpoint in the request headers by Propagator,
+	// which are set before the Signer and BeforePost run.
+	TracerProvider trace.TracerProvider
+
+	// Propagator writes the trace context to the request headers when
+	// TracerProvider is set. It defaults to the W3C traceparent and
+	// tracestate headers.
+	Propagator propagation.TextMapPropagator
+
+	// RecordSink, if set, receives a DeliveryRecord after every attempt
+	RecordSink RecordSink
+
+	// SlowThreshold, if set, makes the client log a warning when the
+	// endpoint takes longer than that to respond to a request, and call
+	// OnSlowResponse if it is set.
+	SlowThreshold  time.Duration
+	OnSlowResponse func(webhookID string, eventType string, duration time.Duration)
+
+	// MaxRequestBodyBytes is the maximum size of the events forwarded to the
+	// endpoint. Post returns ErrBodyTooLarge for larger events without
+	// sending them. Zero means no limit.
+	MaxRequestBodyBytes int64
+
+	// MaxResponseBodyBytes is the maximum number of bytes of the endpoint's
+	// response body that are read and handed to the response handler. Longer
+	// bodies are truncated and their connection is closed instead of being
+	// read to the end, including for the responses that aren't handed to the
+	// response handler, e.g. those of the attempts that are retried.
+	// Defaults to 64KB.
+	MaxResponseBodyBytes int64
+
+	// DisableResponseDecompression stops the client from decoding response
+	// bodies encoded with gzip or deflate, according to their
+	// Content-Encoding header, before they are handed to the response
+	// handler and buffered, for handlers that want the raw bytes. It also
+	// stops the transport built when HTTPClient is not set from asking for
+	// gzip responses and decoding them transparently.
+	DisableResponseDecompression bool
+
+	// CircuitBreaker controls when the client stops forwarding events to an
+	// endpoint that keeps failing. The zero value disables it.
+	CircuitBreaker CircuitBreakerPolicy
+
+	// OnFailureStreak, if set, is called when the number of consecutive
+	// failed deliveries reaches FailureStreakThreshold. Failures are counted
+	// the same way as by the circuit breaker, and the streak is reset by a
+	// successful delivery.
+	OnFailureStreak        func(streak int)
+	FailureStreakThreshold int
+
+	// BufferSize is the number of events held in memory while the circuit
+	// breaker is open. Buffered events are delivered in order once the
+	// endpoint recovers, before any new event, and Post returns nil for
+	// them. Zero disables the buffer.
+	BufferSize int
+
+	// BufferOverflow decides what happens to new events when the buffer is
+	// full. Defaults to DropOldest.
+	BufferOverflow BufferOverflowPolicy
+
+	// DeadLetterFile is the path of a file to which events that couldn't be
+	// delivered are appended, one JSON object per line, so that they can be
+	// replayed later with ReplayDeadLetters.
+	DeadLetterFile string
+
+	// Transform, if set, replaces the body of every event with the body it
+	// returns before the event is sent, e.g. to convert events to another
+	// schema. It is called once per event, after the signature was verified
+	// and before the body is compressed. If it returns an error, the event
+	// isn't sent and Post returns that error.
+	Transform func(eventType string, body []byte) ([]byte, error)
+
+	// FormEncodeBody converts the JSON body of every event to form-encoded
+	// key/values before it is sent, for endpoints that only accept
+	// application/x-www-form-urlencoded. Nested objects and arrays are
+	// flattened with dotted keys, array elements being keyed by their index,
+	// e.g. data.object.items.0.id. It happens after Transform, and overrides
+	// the Content-Type header of the event. Like with Transform, the
+	// Stripe-Signature header no longer matches the body.
+	FormEncodeBody bool
+
+	// ExpandThinEvents makes the client fetch the object that thin events
+	// refer to in their related_object with APIClient, and add it to the
+	// event as data.object before the event is sent, so that the endpoint
+	// receives a thick payload. It happens before Transform is called. Like
+	// with Transform, the Stripe-Signature header no longer matches the
+	// body of expanded events.
+	ExpandThinEvents bool
+
+	// APIClient is the Stripe API client, including the API key, with which
+	// thin events are expanded. It is required by ExpandThinEvents.
+	APIClient *stripe.Client
+
+	// ForwardUnexpandedEvents makes the client forward thin events as is
+	// when they can't be expanded, instead of failing with ErrExpandFailed.
+	ForwardUnexpandedEvents bool
+
+	// CompressRequests enables the gzip compression of request bodies larger
+	// than CompressionThreshold bytes.
+	CompressRequests bool
+
+	// CompressionThreshold is the size in bytes above which request bodies
+	// are compressed when CompressRequests is set. Defaults to 1KB.
+	CompressionThreshold int
+
+	// RateLimit is the maximum number of requests per second sent to the
+	// endpoint, enforced with a token bucket. Requests over the limit wait for
+	// their turn. Zero means no limit.
+	RateLimit float64
+
+	// RateLimitBurst is the number of requests that can be sent at once
+	// before RateLimit applies. Defaults to 1.
+	RateLimitBurst int
+
+	// Workers is the number of goroutines processing the events posted with
+	// PostAsync. Defaults to 10.
+	Workers int
+
+	// PartitionKeyFunc, if set, returns the partition key of an event, e.g.
+	// the ID of the object it is about. Events sharing a key are delivered
+	// one at a time, in the order in which they were posted, while events
+	// with different keys are delivered concurrently. Events for which it
+	// returns an empty key aren't ordered.
+	PartitionKeyFunc func(body string) string
+
+	// SerializeDelivery makes the client deliver events one at a time, in
+	// the order in which they were posted, e.g. so that tests posting
+	// events concurrently can assert the order in which the endpoint
+	// receives them. It overrides PartitionKeyFunc, and as an event waits
+	// for all the events posted before it, including their retries, it
+	// severely reduces the throughput.
+	SerializeDelivery bool
+
+	// BatchURL, if set, makes the client accumulate events and POST them
+	// together to this URL as a JSON array of their bodies, instead of
+	// sending every event on its own, to reduce the request overhead of
+	// bursts of events. A batch is sent once it holds MaxBatchSize events,
+	// or once its oldest event waited for MaxBatchWait. Post returns once
+	// the batch of the event was delivered. The endpoint may respond with a
+	// JSON array holding an object with a "status" for every event, in the
+	// order of the batch, in which case every event is handed to the
+	// response handler with its own status code and object as its body.
+	// Otherwise every event is handed the response of the batch. Batches
+	// are retried only as a whole, per RetryPolicy, when the batch request
+	// itself fails: the status of an event in the response is final, even
+	// if RetryPolicy would retry it, as resubmitting some events of a batch
+	// would reorder them. Close sends the pending batch right away. With
+	// PartitionKeyFunc or SerializeDelivery, the events of a partition join
+	// the pending batch without waiting for the batches of the previous
+	// ones, which are then sent one at a time to keep the order. It can't
+	// be used with FormEncodeBody.
+	BatchURL string
+
+	// MaxBatchSize is the maximum number of events in a batch when BatchURL
+	// is set. Defaults to 100.
+	MaxBatchSize int
+
+	// MaxBatchWait is the maximum time an event waits for its batch to be
+	// filled when BatchURL is set. Defaults to 1s.
+	MaxBatchWait time.Duration
+
+	// MaxInFlight caps the number of concurrent requests sent to the
+	// endpoint. Requests over the cap wait for a slot. Zero means no cap.
+	MaxInFlight int
+
+	// MaxConcurrentPerHost caps the number of concurrent requests sent to
+	// every host of the targets, on top of MaxInFlight. Requests over the cap
+	// wait for a slot. Zero means no cap.
+	MaxConcurrentPerHost int
+
+	// MaxInFlightBytes caps the total size of the request bodies being
+	// forwarded, including the delays between their retries, to bound the
+	// memory used by bursts of large events. Events over the cap wait for
+	// enough bytes to be released. An event larger than the cap is
+	// forwarded once nothing else is in flight. The bodies of streamed
+	// events of unknown length aren't counted. Zero means no cap.
+	MaxInFlightBytes int64
+
+	// DumpTraffic logs the full requests sent to the endpoint and the
+	// responses received, at debug level. The value of the Stripe-Signature
+	// header is redacted unless DumpSignature is set.
+	DumpTraffic bool
+
+	// DumpSignature includes the value of the Stripe-Signature header in
+	// dumped requests.
+	DumpSignature bool
+
+	// StaticHeaders are added to every forwarded request. Headers of the
+	// event take precedence over static headers with the same name.
+	StaticHeaders map[string]string
+
+	// HeaderTemplates are headers added to every forwarded request, whose
+	// values are Go templates evaluated against the decoded event, e.g.
+	// "{{.type}}" or "{{.data.object.id}}". Headers whose template can't be
+	// evaluated for an event are skipped. Headers of the event take
+	// precedence over templated headers, which take precedence over static
+	// headers.
+	HeaderTemplates map[string]string
+
+	// HeaderAllowlist and HeaderDenylist filter the headers of the events,
+	// e.g. for gateways rejecting unexpected headers. When HeaderAllowlist is
+	// set, only the headers it names are forwarded, and the headers named by
+	// HeaderDenylist are never forwarded, which wins if a header is in both.
+	// Names are case-insensitive. They don't apply to the headers set by the
+	// client, such as StaticHeaders, HeaderTemplates or Content-Type.
+	HeaderAllowlist []string
+	HeaderDenylist  []string
+
+	// Method is the HTTP method of forwarded requests, one of POST, PUT or
+	// PATCH. It defaults to POST.
+	Method string
+
+	// DisableDefaultContentType stops the client from setting the
+	// Content-Type header of forwarded requests to application/json when
+	// neither the event, HeaderTemplates nor StaticHeaders set it.
+	DisableDefaultContentType bool
+
+	// VerifySignature makes the client check the Stripe-Signature header of
+	// every event against Secret and Secrets before forwarding it. Events
+	// with a signature that doesn't match, or that is more than 5 minutes
+	// old, are rejected with ErrSignatureMismatch.
+	VerifySignature bool
+
+	// Secret is the webhook signing secret used when VerifySignature is set
+	Secret string
+
+	// Secrets are additional signing secrets, e.g. the old and new secrets
+	// during a rotation. A signature matching any of Secret and Secrets is
+	// accepted. The index of the secret that matched, counting Secret first
+	// if it is set, is logged at debug level.
+	Secrets []string
+
+	// BeforePost, if set, is called with every request right before it is
+	// sent, once all the headers have been applied, and may modify it. body
+	// is the final request body. If it returns an error, the request isn't
+	// sent and Post returns that error without retrying.
+	BeforePost func(req *http.Request, body []byte) error
+
+	// Signer, if set, signs every request once all the headers have been
+	// applied, right before BeforePost is called. The signed body is the
+	// body before CompressRequests compresses it. StripeSigner signs
+	// requests the way Stripe signs events, with timestamps from Clock.
+	Signer Signer
+
+	// IdempotencyKeys adds an Idempotency-Key header set to the event ID to
+	// forwarded requests, unless the event already has one.
+	IdempotencyKeys bool
+
+	// RequestIDHeader, if set, is the name of a header, e.g. X-Request-Id,
+	// holding a random ID generated for every event, unless the event
+	// already has one. The ID is the same for all the attempts of an event,
+	// so that the endpoint can group its retries.
+	RequestIDHeader string
+
+	// AttemptHeader, if set, is the name of a header, e.g. X-Attempt,
+	// holding the number of the attempt, starting at 1 and incremented with
+	// every retry of an event.
+	AttemptHeader string
+
+	// DisableAccountHeader stops clients forwarding Connect events from
+	// setting the Stripe-Account header of requests to the ID of the
+	// connected account the event belongs to.
+	DisableAccountHeader bool
+
+	// DedupSize is the number of recently delivered event IDs remembered by
+	// the client. Events that were already delivered within DedupTTL are
+	// skipped, as are the duplicates of an event posted while it is being
+	// delivered. Zero disables deduplication.
+	DedupSize int
+
+	// DedupTTL is how long a delivered event ID is remembered. Defaults to
+	// 10 minutes.
+	DedupTTL time.Duration
+
+	// DedupFile is the path of a file where the IDs of delivered events are
+	// recorded, so that deduplication spans restarts. It is loaded when the
+	// client is created, and entries older than DedupTTL are pruned. It is
+	// only used when DedupSize is set.
+	DedupFile string
+
+	// BasicAuthUser and BasicAuthPassword are the credentials sent with
+	// forwarded requests using HTTP basic authentication.
+	BasicAuthUser     string
+	BasicAuthPassword string
+
+	// BearerToken is sent with forwarded requests in the Authorization
+	// header. It can't be combined with basic authentication.
+	BearerToken string
+
+	// PathForEvent, if set, returns a path for each event type that is
+	// appended to the path of the endpoint's URL, e.g. "invoices" to forward
+	// invoice events to http://localhost:3000/webhooks/invoices. An empty
+	// path leaves the URL unchanged.
+	PathForEvent func(eventType string) string
+
+	// UserAgent is the User-Agent header of forwarded requests, unless the
+	// event has its own. Defaults to StripeCLI-Proxy/<version>.
+	UserAgent string
+
+	// ProbeMethod is the method of the requests sent by Ping. Defaults to
+	// HEAD.
+	ProbeMethod string
+
+	// ProbePath is the path of the requests sent by Ping. Defaults to the
+	// path of the endpoint's URL.
+	ProbePath string
+
+	// ProbeStatusCodes are the status codes for which Ping considers the
+	// endpoint reachable. When empty, any response will do.
+	ProbeStatusCodes []int
+
+	// KeepaliveInterval, if set, makes the client send a probe request, as
+	// configured for Ping, to every target each time it sent nothing to the
+	// endpoint for that long, so that its connections stay open between
+	// events, e.g. when the endpoint's idle timeout is shorter than the
+	// quiet periods. The status codes of the responses are ignored. The
+	// probes run until the client is closed. Zero disables them.
+	KeepaliveInterval time.Duration
+
+	// AllowLivemode and AllowTestmode restrict the forwarded events to live
+	// mode and test mode events respectively. When neither is set, events of
+	// both modes are forwarded.
+	AllowLivemode bool
+	AllowTestmode bool
+
+	// SampleRate, if set, is the fraction of events, between 0 and 1, that
+	// are forwarded, e.g. 0.1 to forward a random 10% of the events. The
+	// other events are skipped. Zero forwards every event.
+	SampleRate float64
+
+	// SampleSeed seeds the random sampling of events, so that the same
+	// events are sampled from run to run. Zero uses a random seed.
+	SampleSeed int64
+
+	// MaxEventAge, if set, makes the client skip events that were created
+	// longer ago than that, e.g. the backlog of events received after being
+	// offline for a while. Zero means no limit.
+	MaxEventAge time.Duration
+
+	// EventCreatedHeader is the name of the header holding the creation time
+	// of events, as a Unix timestamp or in RFC 3339 format, for MaxEventAge.
+	// Defaults to reading the created field of the event payload.
+	EventCreatedHeader string
+}
+
+// PayloadTimeout computes the timeout of an attempt as Base plus the time
+// needed to transfer the request body at BytesPerSecond, capped at Max. It is
+// disabled when Base is zero.
+type PayloadTimeout struct {
+	Base           time.Duration
+	BytesPerSecond int64
+
+	// Max caps the timeout. Zero means no cap.
+	Max time.Duration
+}
+
+// timeout returns the timeout of an attempt sending size bytes, or zero if
+// the payload timeout is disabled.
+func (p PayloadTimeout) timeout(size int) time.Duration {
+	if p.Base <= 0 {
+		return 0
+	}
+
+	timeout := p.Base
+	if p.BytesPerSecond > 0 && size > 0 {
+		timeout += time.Duration(int64(size) * int64(time.Second) / p.BytesPerSecond)
+	}
+	if p.Max > 0 && timeout > p.Max {
+		timeout = p.Max
+	}
+
+	return timeout
 }
 
 // EndpointResponseHandler handles a response from the endpoint.
@@ -38,106 +643,1614 @@ func (f EndpointResponseHandlerFunc) ProcessResponse(webhookID string, resp *htt
 	f(webhookID, resp)
 }
 
+// EndpointResponse describes the response of the endpoint to a forwarded
+// event.
+type EndpointResponse struct {
+	WebhookID string
+
+	// EventID and EventType are the ID and type of the forwarded event,
+	// e.g. evt_123 and invoice.paid. They are empty if the body of the
+	// event couldn't be decoded or was streamed with PostReader.
+	EventID   string
+	EventType string
+
+	// Duration is how long the endpoint took to respond to the request. When
+	// the request was retried, it is the duration of the last attempt.
+	Duration time.Duration
+
+	// Success is set when the endpoint accepted the event with one of the
+	// success status codes, 2xx by default
+	Success bool
+
+	Response *http.Response
+}
+
+// EndpointResponseHandlerV2 handles a response from the endpoint along with
+// details about the forwarded event. When the ResponseHandler of an
+// EndpointClient implements it, ProcessEndpointResponse is called instead of
+// ProcessResponse.
+type EndpointResponseHandlerV2 interface {
+	EndpointResponseHandler
+	ProcessEndpointResponse(*EndpointResponse)
+}
+
+// EndpointResponseHandlerV2Func is an adapter to allow the use of ordinary
+// functions as EndpointResponseHandlerV2.
+type EndpointResponseHandlerV2Func func(*EndpointResponse)
+
+// ProcessResponse calls f with the webhook ID and the response only.
+func (f EndpointResponseHandlerV2Func) ProcessResponse(webhookID string, resp *http.Response) {
+	f(&EndpointResponse{WebhookID: webhookID, Response: resp})
+}
+
+// ProcessEndpointResponse calls f(resp).
+func (f EndpointResponseHandlerV2Func) ProcessEndpointResponse(resp *EndpointResponse) {
+	f(resp)
+}
+
+// ResponseAction tells an EndpointClient what to do with an event once its
+// response was processed.
+type ResponseAction int
+
+// Possible actions of an EndpointResponseActionHandler.
+const (
+	// Ack considers the event handled
+	Ack ResponseAction = iota
+
+	// Retry sends the event again, following the retry policy
+	Retry
+
+	// Drop gives up on the event without reporting an error
+	Drop
+)
+
+// EndpointResponseActionHandler handles a response from the endpoint and
+// decides what happens to the event. When the ResponseHandler of an
+// EndpointClient implements it, ProcessEndpointResponseAction is called with
+// the response of every attempt, and its decision replaces the retry
+// policy's for responses. Connection errors are still retried as configured.
+type EndpointResponseActionHandler interface {
+	EndpointResponseHandler
+	ProcessEndpointResponseAction(*EndpointResponse) ResponseAction
+}
+
+// EndpointResponseActionHandlerFunc is an adapter to allow the use of
+// ordinary functions as EndpointResponseActionHandler.
+type EndpointResponseActionHandlerFunc func(*EndpointResponse) ResponseAction
+
+// ProcessResponse calls f with the webhook ID and the response only, and
+// ignores its action.
+func (f EndpointResponseActionHandlerFunc) ProcessResponse(webhookID string, resp *http.Response) {
+	f(&EndpointResponse{WebhookID: webhookID, Response: resp})
+}
+
+// ProcessEndpointResponseAction calls f(resp).
+func (f EndpointResponseActionHandlerFunc) ProcessEndpointResponseAction(resp *EndpointResponse) ResponseAction {
+	return f(resp)
+}
+
+// Endpoint is a local endpoint to which the proxy forwards events. It is
+// implemented by EndpointClient, MultiEndpointClient, RoutingEndpointClient
+// and FailoverClient, and can be implemented by fakes in tests.
+type Endpoint interface {
+	// SupportsEventType returns whether events of the type are forwarded
+	SupportsEventType(connect bool, eventType string) bool
+
+	Post(webhookID string, body string, headers map[string]string) error
+	PostWithContext(ctx context.Context, webhookID string, body string, headers map[string]string) error
+
+	// Ping checks that the endpoint is reachable
+	Ping(ctx context.Context) error
+
+	// Close waits for the outstanding requests until the context is done,
+	// and returns the number of requests that were canceled
+	Close(ctx context.Context) int
+}
+
 // EndpointClient is the client used to POST webhook requests to the local endpoint.
 type EndpointClient struct {
-	// URL the client sends POST requests to
+	// URL the client sends POST requests to. URLs of the form
+	// unix:///path/to/socket send requests to a server listening on a Unix
+	// domain socket. For clients created with NewWeightedEndpointClient, it
+	// is the comma separated list of the target URLs.
 	URL string
 
+	// targets picks the URL of each request
+	targets *targetPicker
+
 	connect bool
 
-	events map[string]bool
+	// eventsMu protects events, which can be replaced with SetEvents
+	eventsMu sync.RWMutex
+	events   map[string]bool
+
+	excludedEvents map[string]bool
+
+	// successStatusCodes is nil when SuccessStatusCodes isn't set, in which
+	// case 2xx status codes are successes
+	successStatusCodes map[int]bool
 
 	// Optional configuration parameters
 	cfg *EndpointConfig
+
+	metrics endpointMetrics
+
+	// prometheusMetrics is nil when PrometheusRegistry isn't set
+	prometheusMetrics *PrometheusMetrics
+
+	clock Clock
+
+	breaker *circuitBreaker
+
+	// buffer holds events while the circuit is open. It is nil when
+	// buffering is disabled.
+	buffer *eventBuffer
+
+	limiter *rateLimiter
+
+	// hostLimiter is nil when MaxConcurrentPerHost isn't set
+	hostLimiter *hostLimiter
+
+	// byteLimiter is nil when MaxInFlightBytes isn't set
+	byteLimiter *byteLimiter
+
+	retryBudget *retryBudget
+
+	sampler *sampler
+
+	// failsOver is set on the primary client of a FailoverClient, whose
+	// failing responses are left to the backup client, see failOver
+	failsOver bool
+
+	// partitioner is nil when neither PartitionKeyFunc nor SerializeDelivery
+	// is set
+	partitioner *partitioner
+
+	// batcher and batchTarget are nil when BatchURL isn't set
+	batcher     *batcher
+	batchTarget *target
+
+	// tracer is nil when tracing is disabled
+	tracer trace.Tracer
+
+	dedup *dedupCache
+
+	// headerTemplates are the parsed HeaderTemplates
+	headerTemplates map[string]*template.Template
+
+	// headerAllowlist and headerDenylist are the canonical names of
+	// HeaderAllowlist and HeaderDenylist. headerAllowlist is nil when all
+	// headers are allowed.
+	headerAllowlist map[string]bool
+	headerDenylist  map[string]bool
+
+	skipVerifyWarning sync.Once
+
+	// remoteChecked is closed once warnIfRemote is done
+	remoteChecked chan struct{}
+
+	// deadLetterMu serializes accesses to the dead letter file, and replayMu
+	// the replays of the file
+	deadLetterMu sync.Mutex
+	replayMu     sync.Mutex
+
+	// drainMu protects closed and the registration of outstanding requests
+	drainMu          sync.Mutex
+	closed           bool
+	outstanding      sync.WaitGroup
+	outstandingCount int32
+
+	// stopped is closed to cancel the outstanding requests when Close times
+	// out
+	stopped  chan struct{}
+	stopOnce sync.Once
+
+	// pauseMu protects resumed, which is closed by Resume and nil when the
+	// client isn't paused
+	pauseMu sync.Mutex
+	resumed chan struct{}
+
+	// jobs feeds the workers of PostAsync, which are started on first use and
+	// stopped when workersDone is closed by Close
+	jobs         chan *asyncJob
+	startWorkers sync.Once
+	workersDone  chan struct{}
+	stopWorkers  sync.Once
+
+	// activityMu protects lastActivity, the time of the last request sent
+	// to the endpoint, after which the keepalive probes are due
+	activityMu   sync.Mutex
+	lastActivity time.Time
+
+	// background tracks the goroutines started with the client, i.e. the
+	// keepalive probes and the resolution of warnIfRemote, which stop once
+	// backgroundCtx is canceled by Close
+	background       sync.WaitGroup
+	backgroundCtx    context.Context
+	cancelBackground context.CancelFunc
+}
+
+// CircuitState returns the current state of the client's circuit breaker.
+func (c *EndpointClient) CircuitState() CircuitState {
+	return c.breaker.currentState()
+}
+
+// FailureStreak returns the number of consecutive failed deliveries, as
+// counted by the circuit breaker, which is reset by a successful delivery.
+func (c *EndpointClient) FailureStreak() int {
+	return c.breaker.failureStreak()
+}
+
+// QueueDepth returns the number of requests currently waiting because of the
+// rate limit or the cap on requests in flight.
+func (c *EndpointClient) QueueDepth() int {
+	return c.limiter.queueDepth()
+}
+
+// Metrics returns a snapshot of the delivery metrics of the client.
+func (c *EndpointClient) Metrics() EndpointMetrics {
+	return c.metrics.get()
+}
+
+// LastResponse returns the last response of the endpoint to a forwarded
+// event, and whether there is one.
+func (c *EndpointClient) LastResponse() (RecordedResponse, bool) {
+	return c.metrics.lastResponse()
+}
+
+// Events returns the sorted list of event types forwarded by the client, as
+// configured, lowercased and without duplicates.
+func (c *EndpointClient) Events() []string {
+	c.eventsMu.RLock()
+	defer c.eventsMu.RUnlock()
+
+	events := make([]string, 0, len(c.events))
+	for event := range c.events {
+		events = append(events, event)
+	}
+	sort.Strings(events)
+
+	return events
+}
+
+// Connect returns whether the client forwards Connect events rather than
+// normal events.
+func (c *EndpointClient) Connect() bool {
+	return c.connect
 }
 
 // SupportsEventType takes an event of a webhook and compares it to the internal
-// list of supported events
+// list of supported events. Besides exact event types and the "*" catch-all,
+// the list may contain patterns such as "invoice.*" that match all the event
+// types under a prefix. Matching is case-insensitive. Event types matched
+// by the configured ExcludedEvents are never supported.
 func (c *EndpointClient) SupportsEventType(connect bool, eventType string) bool {
-	if connect != c.connect {
-		return false
+	_, reason := c.skipReason(connect, eventType)
+	if reason != "" && c.cfg.LogSkipped {
+		c.cfg.Log.WithFields(log.Fields{
+			"prefix":     "proxy.EndpointClient.SupportsEventType",
+			"url":        c.URL,
+			"event_type": eventType,
+			"reason":     reason,
+		}).Info("Event skipped")
 	}
 
-	// Endpoint supports all events, always return true
-	if c.events["*"] || c.events[eventType] {
+	return reason == ""
+}
+
+// SetEvents replaces the list of event types forwarded by the client, keeping
+// its state such as its metrics and circuit breaker. It is safe to call
+// concurrently with Post and SupportsEventType, but events for which
+// SupportsEventType was called before the change may still be forwarded
+// according to the old list.
+func (c *EndpointClient) SetEvents(events []string) {
+	eventsMap := convertToMap(events)
+
+	c.eventsMu.Lock()
+	defer c.eventsMu.Unlock()
+
+	c.events = eventsMap
+}
+
+// SupportsLivemode returns whether events of the given mode are forwarded, as
+// configured by AllowLivemode and AllowTestmode.
+func (c *EndpointClient) SupportsLivemode(livemode bool) bool {
+	if !c.cfg.AllowLivemode && !c.cfg.AllowTestmode {
 		return true
 	}
+	if livemode {
+		return c.cfg.AllowLivemode
+	}
 
-	return false
+	return c.cfg.AllowTestmode
 }
 
-// Post sends a message to the local endpoint.
+// Post sends a message to the local endpoint. If the client has a retry
+// policy, failed attempts are retried and the last error is returned once
+// all attempts are exhausted. Transport errors match ErrEndpointUnreachable,
+// ErrHostNotFound or ErrEndpointTimeout with errors.Is when they denote such
+// a failure. Responses with a status code that isn't a success, e.g. 4xx and
+// 5xx, aren't errors unless FailOnNon2xx is set, in which case Post returns
+// an *HTTPStatusError once the response handler was invoked. Redirects that
+// weren't followed return a *RedirectError, see FollowRedirects.
 func (c *EndpointClient) Post(webhookID string, body string, headers map[string]string) error {
-	c.cfg.Log.WithFields(log.Fields{
-		"prefix": "proxy.EndpointClient.Post",
-	}).Debug("Forwarding event to local endpoint")
+	return c.PostWithContext(context.Background(), webhookID, body, headers)
+}
+
+// PostWithContext is like Post but aborts the request, and any pending
+// retry, when the context is canceled. In that case the context's error is
+// returned. ErrClosed is returned once the client was closed.
+func (c *EndpointClient) PostWithContext(ctx context.Context, webhookID string, body string, headers map[string]string) error {
+	_, err := c.post(ctx, webhookID, body, headers)
+	return err
+}
+
+// post implements PostWithContext and also returns whether the event was
+// sent to the endpoint and the status code of its response.
+func (c *EndpointClient) post(ctx context.Context, webhookID string, body string, headers map[string]string) (deliveryResult, error) {
+	return c.postEvent(ctx, &bufferedEvent{webhookID: webhookID, body: body, headers: headers})
+}
 
-	req, err := http.NewRequest(http.MethodPost, c.URL, bytes.NewBuffer([]byte(body)))
+// postEvent implements post. Events that aren't delivered are written to the
+// dead letter file, except for replayed events, which ReplayDeadLetters
+// keeps in the file.
+func (c *EndpointClient) postEvent(ctx context.Context, evt *bufferedEvent) (deliveryResult, error) {
+	ctx, cancel, err := c.begin(ctx)
 	if err != nil {
-		return err
+		return deliveryResult{}, err
 	}
-	for k, v := range headers {
-		req.Header.Add(k, v)
+	defer c.end(cancel)
+
+	release, err := c.awaitPartition(ctx, evt.body)
+	defer release()
+	if err != nil {
+		return deliveryResult{}, err
 	}
 
-	resp, err := c.cfg.HTTPClient.Do(req)
+	// The signature is verified once, before the event is buffered, as it
+	// may then be flushed long after the tolerance
+	if err := c.verifyEvent(evt); err != nil {
+		return deliveryResult{}, err
+	}
+
+	maybeWriteDeadLetter := func(err error) {
+		if !evt.replayed {
+			c.maybeWriteDeadLetter(err, evt.webhookID, evt.body, evt.headers)
+		}
+	}
+
+	if c.buffer != nil {
+		// Events wait behind the buffered events to be delivered in order
+		if buffered, err := c.bufferEvent(evt, true); buffered || err != nil {
+			maybeWriteDeadLetter(err)
+			return deliveryResult{buffered: buffered}, err
+		}
+
+		// Events are held in the buffer while the client is paused
+		if c.IsPaused() {
+			buffered, err := c.bufferEvent(evt, false)
+			maybeWriteDeadLetter(err)
+			return deliveryResult{buffered: buffered}, err
+		}
+	}
+
+	result, err := c.deliver(ctx, evt, release)
+	if errors.Is(err, ErrCircuitOpen) && c.buffer != nil {
+		var buffered bool
+		if buffered, err = c.bufferEvent(evt, false); buffered {
+			return deliveryResult{buffered: true}, nil
+		}
+	}
+	maybeWriteDeadLetter(err)
+
+	return result, err
+}
+
+// verifyEvent checks the signature of the event if VerifySignature is set.
+func (c *EndpointClient) verifyEvent(evt *bufferedEvent) error {
+	if !c.cfg.VerifySignature {
+		return nil
+	}
+
+	// The signatures of replayed events are as old as the events
+	tolerance := defaultSignatureTolerance
+	if evt.replayed {
+		tolerance = 0
+	}
+	index, err := verifySignature(headerValue(evt.headers, signatureHeader), []byte(evt.body), c.signingSecrets(), tolerance, c.clock.Now())
 	if err != nil {
-		c.cfg.Log.Errorf("Failed to POST event to local endpoint, error = %v\n", err)
+		c.cfg.Log.WithFields(log.Fields{
+			"prefix":     "proxy.EndpointClient.Post",
+			"webhook_id": evt.webhookID,
+		}).Errorf("Not forwarding event, error = %v", err)
 		return err
 	}
-	defer resp.Body.Close()
-
-	c.cfg.ResponseHandler.ProcessResponse(webhookID, resp)
+	c.cfg.Log.WithFields(log.Fields{
+		"prefix":       "proxy.EndpointClient.Post",
+		"webhook_id":   evt.webhookID,
+		"secret_index": index,
+	}).Debug("Signature matched")
 
 	return nil
 }
 
-//
-// Public functions
-//
+// deliver forwards the event to the local endpoint, retrying as configured.
+// It returns whether the event was sent, as opposed to being filtered out,
+// and the status code of the endpoint's response, or zero if there is none.
+// release is called early to let the next event of the partition go on once
+// the event joined a batch.
+func (c *EndpointClient) deliver(ctx context.Context, buffered *bufferedEvent, release func()) (deliveryResult, error) {
+	webhookID, body, headers := buffered.webhookID, buffered.body, buffered.headers
+	evt := parseStripeEvent(body)
 
-// NewEndpointClient returns a new EndpointClient.
-func NewEndpointClient(url string, connect bool, events []string, cfg *EndpointConfig) *EndpointClient {
-	if cfg == nil {
-		cfg = &EndpointConfig{}
+	if c.cfg.MaxRequestBodyBytes > 0 && int64(len(body)) > c.cfg.MaxRequestBodyBytes {
+		c.cfg.Log.WithFields(log.Fields{
+			"prefix":     "proxy.EndpointClient.Post",
+			"webhook_id": webhookID,
+			"event_id":   evt.ID,
+			"body_size":  len(body),
+		}).Errorf("Not forwarding event, body exceeds %d bytes", c.cfg.MaxRequestBodyBytes)
+		return deliveryResult{}, ErrBodyTooLarge
 	}
-	if cfg.Log == nil {
-		cfg.Log = &log.Logger{Out: ioutil.Discard}
+
+	if !c.SupportsLivemode(evt.Livemode) {
+		c.cfg.Log.WithFields(log.Fields{
+			"prefix":     "proxy.EndpointClient.Post",
+			"webhook_id": webhookID,
+			"event_id":   evt.ID,
+			"livemode":   evt.Livemode,
+		}).Warn("Not forwarding event because its mode is not allowed")
+		return deliveryResult{}, nil
 	}
-	if cfg.HTTPClient == nil {
-		cfg.HTTPClient = &http.Client{
-			Timeout: defaultTimeout,
+
+	if !c.sampler.keep() {
+		c.logSkipped(log.Fields{
+			"prefix":     "proxy.EndpointClient.Post",
+			"webhook_id": webhookID,
+			"event_id":   evt.ID,
+			"event_type": evt.Type,
+			"reason":     "sampled out",
+		}, "Event was sampled out")
+		return deliveryResult{}, nil
+	}
+
+	if c.cfg.MaxEventAge > 0 {
+		if created, ok := c.eventCreated(evt, headers); ok && c.isStale(created) {
+			c.cfg.Log.WithFields(log.Fields{
+				"prefix":     "proxy.EndpointClient.Post",
+				"webhook_id": webhookID,
+				"event_id":   evt.ID,
+				"event_type": evt.Type,
+				"created":    created,
+				"reason":     "stale",
+			}).Info("Event is stale, dropped")
+			return deliveryResult{}, nil
 		}
 	}
-	if cfg.ResponseHandler == nil {
-		cfg.ResponseHandler = EndpointResponseHandlerFunc(func(string, *http.Response) {})
+
+	if !c.dedup.reserve(evt.ID) {
+		c.cfg.Log.WithFields(log.Fields{
+			"prefix":     "proxy.EndpointClient.Post",
+			"webhook_id": webhookID,
+			"event_id":   evt.ID,
+		}).Info("Event was already delivered or is being delivered, deduped")
+		return deliveryResult{}, nil
+	}
+	// This does nothing once the delivery was recorded
+	defer c.dedup.release(evt.ID)
+
+	body, err := c.maybeExpandEvent(ctx, webhookID, evt, body)
+	if err != nil {
+		return deliveryResult{}, err
+	}
+
+	d, err := c.newDelivery(webhookID, evt, body, headers)
+	if err != nil {
+		return deliveryResult{}, err
 	}
 
-	return &EndpointClient{
-		URL:     url,
-		connect: connect,
-		events:  convertToMap(events),
-		cfg:     cfg,
+	var statusCode int
+	if c.batcher != nil && !c.cfg.DryRun {
+		statusCode, err = c.postBatched(ctx, d, release)
+	} else {
+		statusCode, err = c.forward(ctx, d)
 	}
+
+	// Errors without a response, e.g. transport errors, occur before the
+	// event reaches the endpoint
+	return deliveryResult{statusCode: statusCode, sent: err == nil || statusCode != 0}, err
 }
 
-//
-// Private constants
-//
+// forward sends the delivery to the local endpoint, retrying as configured,
+// and hands the response to the response handler.
+func (c *EndpointClient) forward(ctx context.Context, d *delivery) (int, error) {
+	// Every delivery, including streamed events and batches, waits here
+	// while the client is paused
+	if err := c.awaitResume(ctx); err != nil {
+		return 0, err
+	}
 
-const (
-	defaultTimeout = 30 * time.Second
-)
+	c.cfg.Log.WithFields(d.logFields(nil)).Debug("Forwarding event to local endpoint")
+
+	if c.cfg.InsecureSkipVerify {
+		c.skipVerifyWarning.Do(func() {
+			c.cfg.Log.WithFields(log.Fields{
+				"prefix": "proxy.EndpointClient.Post",
+			}).Warn("Certificate verification is disabled for forwarded requests")
+		})
+	}
+
+	if c.cfg.DryRun {
+		resp, err := c.dryRun(ctx, d)
+		if err != nil {
+			return 0, err
+		}
+
+		return c.handleResponse(d, resp)
+	}
+
+	if !c.breaker.allow() {
+		c.cfg.Log.WithFields(d.logFields(nil)).Debug("Circuit breaker is open, not forwarding event")
+		return 0, ErrCircuitOpen
+	}
+
+	resp, err := c.sendWithRetries(ctx, d)
+
+	var hookErr *beforePostError
+	if errors.As(err, &hookErr) {
+		c.breaker.abort()
+		c.cfg.Log.WithFields(d.logFields(nil)).Errorf("%s failed, not forwarding event, error = %v", hookErr.hook, hookErr.err)
+		return 0, hookErr.err
+	}
+
+	if ctx.Err() != nil {
+		c.breaker.abort()
+		if resp != nil {
+			c.discardResponse(resp)
+		}
+		c.cfg.Log.WithFields(d.logFields(nil)).Debug("Forwarding to local endpoint aborted")
+		return 0, ctx.Err()
+	}
+
+	streak := c.breaker.record(!c.isDeliveryFailure(d, resp, err))
+	if c.cfg.OnFailureStreak != nil && c.cfg.FailureStreakThreshold > 0 && streak == c.cfg.FailureStreakThreshold {
+		c.cfg.OnFailureStreak(streak)
+	}
+
+	if err != nil {
+		c.cfg.Log.WithFields(d.logFields(nil)).Errorf("Failed to POST event to local endpoint, error = %v\n", err)
+		err = classifyError(err)
+		c.onError(d, err)
+		return 0, err
+	}
+
+	if d.batch == nil && !c.isDeliveryFailure(d, resp, nil) {
+		if err := c.dedup.add(d.evt.ID); err != nil {
+			c.cfg.Log.WithFields(d.logFields(nil)).Warnf("Failed to record delivered event in dedup file, error = %v", err)
+		}
+	}
+
+	respBody := c.bufferResponse(resp)
+	c.metrics.recordResponse(resp.StatusCode, respBody, c.clock.Now())
+
+	c.cfg.Log.WithFields(d.logFields(log.Fields{
+		"status":    resp.StatusCode,
+		"body_size": len(respBody),
+	})).Debug("Received response from local endpoint")
+
+	if d.batch != nil {
+		return c.handleBatchResponse(d, resp)
+	}
+
+	return c.handleResponse(d, resp)
+}
+
+// handleResponse hands the response to the response handler, unless it fails
+// over to the backup endpoint of a FailoverClient, and returns the outcome of
+// the delivery.
+func (c *EndpointClient) handleResponse(d *delivery, resp *http.Response) (int, error) {
+	if c.failOver(d, resp) {
+		return resp.StatusCode, &failoverError{status: &HTTPStatusError{Code: resp.StatusCode}}
+	}
+
+	if _, ok := c.cfg.ResponseHandler.(EndpointResponseActionHandler); ok {
+		// The handler already processed the response in sendWithRetries
+		switch d.action {
+		case Retry:
+			return resp.StatusCode, ErrNotAcknowledged
+		case Drop:
+			c.cfg.Log.WithFields(d.logFields(nil)).Info("Event was dropped by the response handler")
+			return resp.StatusCode, nil
+		}
+	} else if handler, ok := c.cfg.ResponseHandler.(EndpointResponseHandlerV2); ok {
+		handler.ProcessEndpointResponse(c.endpointResponse(d, resp))
+	} else {
+		c.cfg.ResponseHandler.ProcessResponse(d.webhookID, resp)
+	}
+
+	if err := redirectError(resp); err != nil {
+		c.onError(d, err)
+		return resp.StatusCode, err
+	}
+
+	if c.cfg.FailOnNon2xx && !c.succeeded(d, resp) {
+		err := &HTTPStatusError{Code: resp.StatusCode}
+		c.onError(d, err)
+		return resp.StatusCode, err
+	}
+
+	return resp.StatusCode, nil
+}
+
+// skipReason returns the filter that rejects events of the type and connect
+// mode and why, or empty strings if they are forwarded.
+func (c *EndpointClient) skipReason(connect bool, eventType string) (EventFilter, string) {
+	if connect != c.connect {
+		if c.connect {
+			return FilterConnect, "only Connect events are forwarded"
+		}
+		return FilterConnect, "Connect events aren't forwarded"
+	}
+
+	if matchesEventType(c.excludedEvents, eventType) {
+		return FilterExcluded, "excluded"
+	}
+
+	c.eventsMu.RLock()
+	defer c.eventsMu.RUnlock()
+
+	if !matchesEventType(c.events, eventType) {
+		return FilterEvents, "not in the list of events"
+	}
+
+	return "", ""
+}
+
+// isStale returns whether an event created at the given time is older than
+// MaxEventAge.
+func (c *EndpointClient) isStale(created time.Time) bool {
+	return c.cfg.MaxEventAge > 0 && c.clock.Now().Sub(created) > c.cfg.MaxEventAge
+}
+
+// logSkipped logs an event that isn't forwarded, at info level if
+// LogSkipped is set and at debug level otherwise.
+func (c *EndpointClient) logSkipped(fields log.Fields, msg string) {
+	entry := c.cfg.Log.WithFields(fields)
+	if c.cfg.LogSkipped {
+		entry.Info(msg)
+	} else {
+		entry.Debug(msg)
+	}
+}
+
+// eventCreated returns the creation time of the event, as configured by
+// EventCreatedHeader, and whether it is known.
+func (c *EndpointClient) eventCreated(evt *stripeEvent, headers map[string]string) (time.Time, bool) {
+	if c.cfg.EventCreatedHeader == "" {
+		return time.Unix(evt.Created, 0), evt.Created > 0
+	}
+
+	value := headerValue(headers, c.cfg.EventCreatedHeader)
+	if value == "" {
+		return time.Time{}, false
+	}
+	if ts, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(ts, 0), true
+	}
+	created, err := time.Parse(time.RFC3339, value)
+
+	return created, err == nil
+}
+
+// signingSecrets returns the secrets against which signatures are verified.
+func (c *EndpointClient) signingSecrets() []string {
+	if c.cfg.Secret == "" {
+		return c.cfg.Secrets
+	}
+
+	return append([]string{c.cfg.Secret}, c.cfg.Secrets...)
+}
+
+func (c *EndpointClient) onError(d *delivery, err error) {
+	if c.cfg.OnError == nil {
+		return
+	}
+
+	if d.batch != nil {
+		for _, evt := range d.batch {
+			c.cfg.OnError(evt.d.webhookID, evt.d.evt.Type, err)
+		}
+		return
+	}
+
+	c.cfg.OnError(d.webhookID, d.evt.Type, err)
+}
+
+func (c *EndpointClient) endpointResponse(d *delivery, resp *http.Response) *EndpointResponse {
+	return &EndpointResponse{
+		WebhookID: d.webhookID,
+		EventID:   d.evt.ID,
+		EventType: d.evt.Type,
+		Duration:  d.duration,
+		Success:   c.succeeded(d, resp),
+		Response:  resp,
+	}
+}
+
+// bufferResponse reads the body of the response, up to the configured limit,
+// and replaces it with an in-memory copy so that it can be consumed by
+// several readers. It returns the buffered body.
+func (c *EndpointClient) bufferResponse(resp *http.Response) []byte {
+	limit := c.maxResponseBodyBytes()
+
+	buf, err := ioutil.ReadAll(io.LimitReader(resp.Body, limit+1))
+	// Closing the body before its end closes the connection, so that the
+	// rest of a longer body is never read
+	resp.Body.Close() // #nosec G104
+	if err != nil {
+		c.cfg.Log.WithFields(log.Fields{
+			"prefix": "proxy.EndpointClient.bufferResponse",
+			"error":  err,
+		}).Warn("Failed to read response body from local endpoint")
+	}
+
+	if int64(len(buf)) > limit {
+		buf = buf[:limit]
+		c.cfg.Log.WithFields(log.Fields{
+			"prefix": "proxy.EndpointClient.bufferResponse",
+		}).Warnf("Response body from local endpoint exceeds %d bytes and was truncated, closing the connection", limit)
+	}
+
+	resp.Body = ioutil.NopCloser(bytes.NewReader(buf))
+
+	return buf
+}
+
+// discardResponse drains and closes the body of a response that won't be
+// handed to the response handler, so that the connection can be reused. The
+// connection of a body longer than MaxResponseBodyBytes is closed instead.
+func (c *EndpointClient) discardResponse(resp *http.Response) {
+	limit := c.maxResponseBodyBytes()
+
+	n, _ := io.Copy(ioutil.Discard, io.LimitReader(resp.Body, limit+1))
+	resp.Body.Close() // #nosec G104
+	if n > limit {
+		c.cfg.Log.WithFields(log.Fields{
+			"prefix": "proxy.EndpointClient.discardResponse",
+		}).Debugf("Discarded response body from local endpoint exceeds %d bytes, closing the connection", limit)
+	}
+}
+
+func (c *EndpointClient) maxResponseBodyBytes() int64 {
+	if c.cfg.MaxResponseBodyBytes > 0 {
+		return c.cfg.MaxResponseBodyBytes
+	}
+
+	return defaultMaxResponseBodyBytes
+}
+
+// pickTarget returns the target of the next attempt, avoiding the target of
+// the previous attempt if there is one.
+func (c *EndpointClient) pickTarget(previous *target) *target {
+	t := c.targets.pick(previous)
+
+	if len(c.targets.targets) > 1 {
+		c.cfg.Log.WithFields(log.Fields{
+			"prefix": "proxy.EndpointClient.Post",
+			"url":    t.url,
+			"weight": t.weight,
+		}).Debug("Selected target for request")
+	}
+
+	return t
+}
+
+// recordAttempt updates the metrics with the outcome of an attempt. resp is
+// nil if the attempt failed with a transport error.
+func (c *EndpointClient) recordAttempt(d *delivery, t *target, attempt int, start time.Time, resp *http.Response, err error) {
+	statusCode := 0
+	if resp != nil {
+		statusCode = resp.StatusCode
+	}
+
+	success := c.succeeded(d, resp)
+	c.metrics.record(success, d.duration)
+
+	if err == nil && c.cfg.SlowThreshold > 0 && d.duration > c.cfg.SlowThreshold {
+		c.cfg.Log.WithFields(d.logFields(log.Fields{
+			"duration": d.duration,
+		})).Warnf("Local endpoint took %v to respond", d.duration.Round(time.Millisecond))
+		if c.cfg.OnSlowResponse != nil {
+			c.cfg.OnSlowResponse(d.webhookID, d.evt.Type, d.duration)
+		}
+	}
+
+	if c.cfg.MetricsSink != nil {
+		c.cfg.MetricsSink.RecordAttempt(t.url, d.evt.Type, statusCode, d.duration)
+	}
+
+	if c.prometheusMetrics != nil {
+		c.prometheusMetrics.record(t.host, d.evt.Type, statusCode, success, d.duration)
+	}
+
+	if c.cfg.RecordSink != nil {
+		record := DeliveryRecord{
+			Timestamp:  start,
+			WebhookID:  d.webhookID,
+			EventID:    d.evt.ID,
+			URL:        t.url,
+			EventType:  d.evt.Type,
+			StatusCode: statusCode,
+			Duration:   d.duration,
+			Retries:    attempt - 1,
+		}
+		if err != nil {
+			record.Error = err.Error()
+		}
+		c.cfg.RecordSink.RecordDelivery(record)
+	}
+}
+
+// sendWithRetries sends the request to the local endpoint, retrying failed
+// attempts as configured. It returns the outcome of the last attempt.
+func (c *EndpointClient) sendWithRetries(ctx context.Context, d *delivery) (*http.Response, error) {
+	var resp *http.Response
+	var err error
+	var t *target
+	var delay time.Duration
+
+	if c.cfg.OverallTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.cfg.OverallTimeout)
+		defer cancel()
+	}
+
+	size := int64(d.size())
+	if err = c.byteLimiter.acquire(ctx, size); err != nil {
+		return nil, err
+	}
+	defer c.byteLimiter.release(size)
+
+	for attempt := 1; ; attempt++ {
+		if err = c.limiter.acquire(ctx); err != nil {
+			return nil, err
+		}
+
+		// Retries go to a different target, if there is one, except for
+		// deliveries pinned to a target such as batches
+		if d.target != nil {
+			t = d.target
+		} else {
+			t = c.pickTarget(t)
+		}
+
+		if err = c.hostLimiter.acquire(ctx, t.host); err != nil {
+			c.limiter.cancel()
+			return nil, err
+		}
+
+		attemptCtx, cancel := c.attemptContext(ctx, d)
+
+		// The span is started first so that its trace context is in the
+		// headers seen by the Signer and the BeforePost hook
+		reqCtx, span := c.startSpan(attemptCtx, d, t, attempt)
+
+		var req *http.Request
+		if req, err = c.newRequest(reqCtx, d, t, attempt); err != nil {
+			cancel()
+			endSpan(span, nil, err)
+			c.hostLimiter.release(t.host)
+			c.limiter.cancel()
+			return nil, err
+		}
+
+		start := c.clock.Now()
+		resp, err = c.send(req, d)
+		d.duration = c.clock.Now().Sub(start)
+		if err == nil && (attemptCtx != ctx || c.cfg.OverallTimeout > 0) {
+			// The body must be read before the deadline is canceled
+			c.bufferResponse(resp)
+		}
+		c.classify(d, resp, err)
+		cancel()
+		endSpan(span, resp, err)
+		c.recordAttempt(d, t, attempt, start, resp, err)
+		c.hostLimiter.release(t.host)
+		c.limiter.release()
+
+		if ctx.Err() != nil {
+			break
+		}
+		retry := c.cfg.RetryPolicy.shouldRetry(attempt, resp, err) && (err != nil || !c.succeeded(d, resp))
+		if d.outcome != OutcomeDefault {
+			// The classification replaces the retry policy's for responses
+			retry = d.outcome == OutcomeRetry && attempt < c.cfg.RetryPolicy.MaxAttempts
+		}
+		if handler, ok := c.cfg.ResponseHandler.(EndpointResponseActionHandler); ok && err == nil && d.batch == nil && !c.failOver(d, resp) {
+			// The handler may read the body, which must stay available
+			c.bufferResponse(resp)
+			d.action = handler.ProcessEndpointResponseAction(c.endpointResponse(d, resp))
+			retry = d.action == Retry && attempt < c.cfg.RetryPolicy.MaxAttempts
+		}
+		if !d.rewindable() || !retry {
+			break
+		}
+		if !c.retryBudget.take() {
+			c.cfg.Log.WithFields(d.logFields(log.Fields{
+				"attempt": attempt,
+			})).Debug("Retry budget is exhausted, not retrying")
+			break
+		}
+
+		fields := d.logFields(log.Fields{
+			"attempt": attempt,
+		})
+
+		delay = c.cfg.RetryPolicy.nextDelay(attempt, delay)
+		if err != nil {
+			fields["error"] = err
+		} else {
+			fields["status"] = resp.StatusCode
+			if retryAfter, ok := c.cfg.RetryPolicy.retryAfter(resp, c.clock.Now()); ok && retryAfter > delay {
+				c.cfg.Log.WithFields(fields).Debugf("Endpoint asked to retry after %v, overriding backoff of %v", retryAfter, delay)
+				delay = retryAfter
+			}
+			c.discardResponse(resp)
+			resp = nil
+		}
+		if clamped, ok := c.cfg.RetryPolicy.clampDelay(delay); ok {
+			c.cfg.Log.WithFields(fields).Infof("Clamped retry delay of %v to %v", delay, clamped)
+			delay = clamped
+		}
+
+		c.cfg.Log.WithFields(fields).Debugf("Request to local endpoint failed, retrying in %v", delay)
+
+		if err = sleepContext(ctx, c.clock, delay); err != nil {
+			break
+		}
+	}
+
+	return resp, err
+}
+
+// attemptContext returns the context of an attempt, with a deadline derived
+// from PerAttemptTimeout, PayloadTimeout and TimeoutForEvent if they are set.
+func (c *EndpointClient) attemptContext(ctx context.Context, d *delivery) (context.Context, context.CancelFunc) {
+	timeout := c.cfg.PayloadTimeout.timeout(d.size())
+	if c.cfg.PerAttemptTimeout > 0 && (timeout <= 0 || c.cfg.PerAttemptTimeout < timeout) {
+		timeout = c.cfg.PerAttemptTimeout
+	}
+	if eventTimeout := c.eventTimeout(d); eventTimeout > 0 && (timeout <= 0 || eventTimeout < timeout) {
+		timeout = eventTimeout
+	}
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+
+	c.cfg.Log.WithFields(d.logFields(log.Fields{
+		"body_size": d.size(),
+		"timeout":   timeout,
+	})).Debugf("Request deadline is %v", c.clock.Now().Add(timeout))
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// eventTimeout returns the deadline of the attempts at forwarding the event
+// given by TimeoutForEvent, or zero if it isn't set.
+func (c *EndpointClient) eventTimeout(d *delivery) time.Duration {
+	if c.cfg.TimeoutForEvent == nil {
+		return 0
+	}
+
+	var timeout time.Duration
+	if d.batch == nil {
+		timeout = c.cfg.TimeoutForEvent(d.evt.Type)
+	}
+	if timeout > 0 {
+		return timeout
+	}
+	if c.cfg.Timeout > 0 {
+		return c.cfg.Timeout
+	}
+
+	return defaultTimeout
+}
+
+// newDelivery prepares the forwarding of an event, transforming and
+// compressing its body as configured.
+func (c *EndpointClient) newDelivery(webhookID string, evt *stripeEvent, body string, headers map[string]string) (*delivery, error) {
+	d := &delivery{
+		webhookID: webhookID,
+		evt:       evt,
+		body:      []byte(body),
+		headers:   headers,
+
+		templatedHeaders: c.renderHeaderTemplates(webhookID, body),
+	}
+
+	if c.cfg.RequestIDHeader != "" {
+		requestID, err := newRequestID()
+		if err != nil {
+			return nil, err
+		}
+		d.requestID = requestID
+	}
+
+	if c.cfg.Transform != nil {
+		transformed, err := c.cfg.Transform(evt.Type, d.body)
+		if err != nil {
+			c.cfg.Log.WithFields(log.Fields{
+				"prefix":     "proxy.EndpointClient.Post",
+				"webhook_id": webhookID,
+				"event_id":   evt.ID,
+			}).Errorf("Not forwarding event, transform failed, error = %v", err)
+			return nil, err
+		}
+		d.body = transformed
+	}
+
+	if c.cfg.FormEncodeBody {
+		encoded, err := formEncode(d.body)
+		if err != nil {
+			c.cfg.Log.WithFields(d.logFields(nil)).Errorf("Not forwarding event, failed to form-encode the body, error = %v", err)
+			return nil, err
+		}
+		d.body = encoded
+		d.contentType = formContentType
+	}
+
+	// Batched events are compressed with their batch
+	if c.cfg.CompressRequests && c.batcher == nil && len(d.body) > c.compressionThreshold() {
+		compressed, err := gzipBody(d.body)
+		if err != nil {
+			return nil, err
+		}
+		d.uncompressed = d.body
+		d.body = compressed
+		d.contentEncoding = "gzip"
+	}
+
+	return d, nil
+}
+
+func (c *EndpointClient) compressionThreshold() int {
+	if c.cfg.CompressionThreshold > 0 {
+		return c.cfg.CompressionThreshold
+	}
+
+	return defaultCompressionThreshold
+}
+
+// newRequest builds the request of an attempt at forwarding the event, with
+// the trace context of the attempt's span, and runs the Signer and the
+// BeforePost hook on it. Errors of the hooks are returned as a
+// *beforePostError.
+func (c *EndpointClient) newRequest(ctx context.Context, d *delivery, t *target, attempt int) (*http.Request, error) {
+	// The request's content length is set from the body, which may differ
+	// from the original payload if it was compressed
+	requestURL := t.requestURL
+	if c.cfg.PathForEvent != nil && d.target == nil {
+		path, err := appendURLPath(requestURL, c.cfg.PathForEvent(d.evt.Type))
+		if err != nil {
+			return nil, err
+		}
+		requestURL = path
+	}
+
+	body, err := d.requestBody()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, c.method(), requestURL, body)
+	if err != nil {
+		return nil, err
+	}
+	if d.stream != nil {
+		req.ContentLength = d.contentLength
+	}
+	for k, v := range d.headers {
+		if c.forwardsHeader(k) {
+			req.Header.Add(k, v)
+		}
+	}
+	req.Header.Del("Content-Length")
+	if d.contentType != "" {
+		req.Header.Set("Content-Type", d.contentType)
+	}
+	if d.contentEncoding != "" {
+		req.Header.Set("Content-Encoding", d.contentEncoding)
+	}
+	for k, v := range d.templatedHeaders {
+		if req.Header.Get(k) == "" {
+			req.Header.Set(k, v)
+		}
+	}
+	for k, v := range c.cfg.StaticHeaders {
+		if req.Header.Get(k) == "" {
+			req.Header.Set(k, v)
+		}
+	}
+	if req.Header.Get("Content-Type") == "" && !c.cfg.DisableDefaultContentType {
+		req.Header.Set("Content-Type", defaultContentType)
+	}
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", c.userAgent())
+	}
+	for _, cookie := range c.cfg.Cookies {
+		req.AddCookie(cookie)
+	}
+	if c.cfg.BasicAuthUser != "" || c.cfg.BasicAuthPassword != "" {
+		req.SetBasicAuth(c.cfg.BasicAuthUser, c.cfg.BasicAuthPassword)
+	} else if c.cfg.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.cfg.BearerToken)
+	}
+	if c.cfg.IdempotencyKeys && d.evt.ID != "" && req.Header.Get(idempotencyKeyHeader) == "" {
+		req.Header.Set(idempotencyKeyHeader, d.evt.ID)
+	}
+	if c.connect && !c.cfg.DisableAccountHeader && d.evt.Account != "" && req.Header.Get(accountHeader) == "" {
+		req.Header.Set(accountHeader, d.evt.Account)
+	}
+	if d.requestID != "" && req.Header.Get(c.cfg.RequestIDHeader) == "" {
+		req.Header.Set(c.cfg.RequestIDHeader, d.requestID)
+	}
+	if c.cfg.AttemptHeader != "" {
+		req.Header.Set(c.cfg.AttemptHeader, strconv.Itoa(attempt))
+	}
+	c.injectTraceContext(ctx, req.Header)
+
+	if c.cfg.Signer != nil {
+		if err := c.sign(d.payload(), req.Header); err != nil {
+			return nil, &beforePostError{hook: "Signer", err: err}
+		}
+	}
+	if c.cfg.BeforePost != nil {
+		if err := c.cfg.BeforePost(req, d.body); err != nil {
+			return nil, &beforePostError{hook: "BeforePost hook", err: err}
+		}
+	}
+
+	return req, nil
+}
+
+func (c *EndpointClient) userAgent() string {
+	if c.cfg.UserAgent != "" {
+		return c.cfg.UserAgent
+	}
+
+	return "StripeCLI-Proxy/" + version.Version
+}
+
+// send makes a single attempt at sending the request to the local endpoint.
+func (c *EndpointClient) send(req *http.Request, d *delivery) (*http.Response, error) {
+	if c.cfg.DumpTraffic {
+		c.dumpRequest(req, d.body)
+	}
+
+	resp, err := c.cfg.HTTPClient.Do(req)
+	c.markActive()
+	if err != nil {
+		return nil, err
+	}
+	if resp, err = c.followRedirects(req, resp, d); err != nil {
+		return nil, err
+	}
+
+	if !c.cfg.DisableResponseDecompression {
+		decompressResponse(resp)
+	}
+	if c.cfg.DumpTraffic {
+		c.bufferResponse(resp)
+		c.dumpResponse(resp)
+	}
+
+	return resp, nil
+}
+
+//
+// Public functions
+//
+
+// NewEndpointClient returns a new EndpointClient. It returns an error if the
+// URL or the configuration is invalid, e.g. if the URL has no http or https
+// scheme or if the client certificate can't be loaded.
+func NewEndpointClient(url string, connect bool, events []string, cfg *EndpointConfig) (*EndpointClient, error) {
+	return newEndpointClient(url, []WeightedTarget{{URL: url, Weight: 1}}, connect, events, cfg)
+}
+
+//
+// Private types
+//
+
+// deliveryResult is the outcome of forwarding an event.
+type deliveryResult struct {
+	// statusCode is the status code of the endpoint's response, or zero if
+	// there is none
+	statusCode int
+
+	// sent is set when the event was sent to the endpoint, and unset when it
+	// was filtered out, e.g. sampled out or deduped, or buffered
+	sent bool
+
+	// buffered is set when the event was buffered to be delivered later
+	buffered bool
+}
+
+// delivery holds the state of an event being forwarded to the endpoint.
+type delivery struct {
+	webhookID string
+	evt       *stripeEvent
+
+	// body is the request body, which may be compressed, in which case
+	// uncompressed is the body before it was compressed
+	body         []byte
+	uncompressed []byte
+	headers      map[string]string
+
+	// templatedHeaders are the evaluated HeaderTemplates
+	templatedHeaders map[string]string
+
+	// stream, if set, is streamed to the endpoint instead of body.
+	// contentLength is its length, or -1 if unknown.
+	stream        io.Reader
+	contentLength int64
+	streamed      bool
+
+	// contentEncoding is the encoding of body, if any
+	contentEncoding string
+
+	// contentType overrides the Content-Type header of the event, if set
+	contentType string
+
+	// requestID is the ID sent in the RequestIDHeader of every attempt
+	requestID string
+
+	// duration is the duration of the last attempt
+	duration time.Duration
+
+	// action is the decision of the response handler about the response of
+	// the last attempt, if it is an EndpointResponseActionHandler
+	action ResponseAction
+
+	// outcome is the classification of the response of the last attempt by
+	// ClassifyResponse, if set
+	outcome Outcome
+
+	// target, if set, is the target of every attempt instead of the targets
+	// of the client
+	target *target
+
+	// batch holds the events of a batch delivery
+	batch []*batchedEvent
+}
+
+// payload returns the request body before it was compressed, if it was.
+func (d *delivery) payload() []byte {
+	if d.uncompressed != nil {
+		return d.uncompressed
+	}
+
+	return d.body
+}
+
+// logFields returns the fields identifying the event in logs, along with the
+// extra fields.
+func (d *delivery) logFields(extra log.Fields) log.Fields {
+	fields := log.Fields{
+		"prefix":     "proxy.EndpointClient.Post",
+		"webhook_id": d.webhookID,
+		"event_id":   d.evt.ID,
+		"event_type": d.evt.Type,
+	}
+	for k, v := range extra {
+		fields[k] = v
+	}
+
+	return fields
+}
+
+// beforePostError wraps an error returned by the Signer or the BeforePost
+// hook, so that it can be told apart from errors sending the request.
+type beforePostError struct {
+	hook string
+	err  error
+}
+
+func (e *beforePostError) Error() string {
+	return e.err.Error()
+}
+
+func (e *beforePostError) Unwrap() error {
+	return e.err
+}
+
+//
+// Private constants
+//
+
+const (
+	defaultTimeout = 30 * time.Second
+
+	defaultMaxResponseBodyBytes = 64 * 1024
+
+	defaultCompressionThreshold = 1024
+
+	defaultContentType = "application/json"
+
+	accountHeader = "Stripe-Account"
+
+	unixSocketScheme = "unix://"
+
+	// unixSocketRequestURL is the URL of requests sent over a Unix domain
+	// socket, for which the host is irrelevant
+	unixSocketRequestURL = "http://localhost/"
+)
+
+//
+// Private variables
+//
+
+// allowedMethods are the HTTP methods with which events may be forwarded
+var allowedMethods = map[string]bool{
+	http.MethodPost:  true,
+	http.MethodPut:   true,
+	http.MethodPatch: true,
+}
 
 //
 // Private functions
 //
 
+// newEndpointClient returns a new EndpointClient sending requests to the
+// targets. Only a single target may be a Unix domain socket URL.
+func newEndpointClient(url string, weightedTargets []WeightedTarget, connect bool, events []string, cfg *EndpointConfig) (*EndpointClient, error) {
+	if cfg == nil {
+		cfg = &EndpointConfig{}
+	}
+	if cfg.Log == nil {
+		cfg.Log = &log.Logger{Out: ioutil.Discard}
+	}
+
+	if cfg.VerifySignature && cfg.Secret == "" && len(cfg.Secrets) == 0 {
+		return nil, errors.New("a secret is required to verify signatures")
+	}
+	if cfg.ExpandThinEvents && cfg.APIClient == nil {
+		return nil, errors.New("an API client is required to expand thin events")
+	}
+	if cfg.SampleRate < 0 || cfg.SampleRate > 1 {
+		return nil, errors.New("the sample rate must be between 0 and 1")
+	}
+	if cfg.Method != "" && !allowedMethods[strings.ToUpper(cfg.Method)] {
+		return nil, fmt.Errorf("unsupported method %q, expected one of POST, PUT or PATCH", cfg.Method)
+	}
+	if (cfg.BasicAuthUser != "" || cfg.BasicAuthPassword != "") && cfg.BearerToken != "" {
+		return nil, errors.New("basic authentication and a bearer token can't both be configured")
+	}
+	successStatusCodes, err := newStatusCodeSet(cfg.SuccessStatusCodes)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range weightedTargets {
+		if err := validateEndpointURL(t.URL); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.BatchURL != "" {
+		if err := validateEndpointURL(cfg.BatchURL); err != nil {
+			return nil, err
+		}
+		if cfg.FormEncodeBody {
+			return nil, errors.New("events can't be form-encoded when they are batched")
+		}
+	}
+
+	headerTemplates, err := parseHeaderTemplates(cfg.HeaderTemplates)
+	if err != nil {
+		return nil, err
+	}
+
+	var prometheusMetrics *PrometheusMetrics
+	if cfg.PrometheusRegistry != nil {
+		if prometheusMetrics, err = RegisterPrometheusMetrics(cfg.PrometheusRegistry); err != nil {
+			return nil, err
+		}
+	}
+
+	clock := newClock(cfg.Clock)
+
+	dedup := newDedupCache(cfg.DedupSize, cfg.DedupTTL, clock)
+	if dedup != nil && cfg.DedupFile != "" {
+		if err := dedup.persist(cfg.DedupFile); err != nil {
+			return nil, err
+		}
+	}
+
+	targets := make([]*target, 0, len(weightedTargets))
+	socketPath := ""
+	for _, t := range weightedTargets {
+		requestURL := t.URL
+		if strings.HasPrefix(t.URL, unixSocketScheme) {
+			requestURL = unixSocketRequestURL
+			socketPath = strings.TrimPrefix(t.URL, unixSocketScheme)
+		}
+		targets = append(targets, &target{url: t.URL, requestURL: requestURL, host: targetHost(t.URL), weight: t.Weight})
+	}
+
+	var batchTarget *target
+	if cfg.BatchURL != "" {
+		requestURL := cfg.BatchURL
+		socket := strings.HasPrefix(cfg.BatchURL, unixSocketScheme)
+		if socket {
+			requestURL = unixSocketRequestURL
+		}
+		// Every request of the client is sent over the socket of the endpoint,
+		// if it is one, so batches can't go anywhere else
+		if socket != (socketPath != "") || socket && strings.TrimPrefix(cfg.BatchURL, unixSocketScheme) != socketPath {
+			return nil, errors.New("the batch URL must be on the socket of the endpoint if it is a Unix domain socket URL")
+		}
+		batchTarget = &target{url: cfg.BatchURL, requestURL: requestURL, host: targetHost(cfg.BatchURL), weight: 1}
+	}
+
+	if cfg.HTTPClient == nil {
+		httpClient, err := newHTTPClient(cfg, socketPath)
+		if err != nil {
+			return nil, err
+		}
+		cfg.HTTPClient = httpClient
+	} else if cfg.Timeout != 0 {
+		cfg.Log.WithFields(log.Fields{
+			"prefix": "proxy.NewEndpointClient",
+		}).Warn("Both an HTTP client and a timeout were configured, ignoring the timeout")
+	}
+	if cfg.ResponseHandler == nil {
+		cfg.ResponseHandler = EndpointResponseHandlerFunc(func(string, *http.Response) {})
+	}
+
+	c := &EndpointClient{
+		URL:                url,
+		targets:            &targetPicker{targets: targets},
+		connect:            connect,
+		events:             convertToMap(events),
+		excludedEvents:     convertToMap(cfg.ExcludedEvents),
+		successStatusCodes: successStatusCodes,
+		cfg:                cfg,
+		prometheusMetrics:  prometheusMetrics,
+		clock:              clock,
+		breaker:            newCircuitBreaker(cfg.CircuitBreaker, cfg.Log, clock),
+		buffer:             newEventBuffer(cfg.BufferSize, cfg.BufferOverflow),
+		limiter:            newRateLimiter(cfg.RateLimit, cfg.RateLimitBurst, cfg.MaxInFlight, clock),
+		hostLimiter:        newHostLimiter(cfg.MaxConcurrentPerHost),
+		byteLimiter:        newByteLimiter(cfg.MaxInFlightBytes),
+		dedup:              dedup,
+		retryBudget:        newRetryBudget(cfg.RetryBudget, cfg.RetryBudgetRefillRate, clock),
+		sampler:            newSampler(cfg.SampleRate, cfg.SampleSeed),
+		tracer:             newTracer(cfg.TracerProvider),
+		partitioner:        newPartitioner(cfg.PartitionKeyFunc, cfg.SerializeDelivery),
+		headerTemplates:    headerTemplates,
+		headerAllowlist:    newHeaderSet(cfg.HeaderAllowlist),
+		headerDenylist:     newHeaderSet(cfg.HeaderDenylist),
+		stopped:            make(chan struct{}),
+		jobs:               make(chan *asyncJob),
+		workersDone:        make(chan struct{}),
+		batchTarget:        batchTarget,
+		lastActivity:       clock.Now(),
+		remoteChecked:      make(chan struct{}),
+	}
+	c.backgroundCtx, c.cancelBackground = context.WithCancel(context.Background())
+	c.batcher = c.newBatcher()
+	c.warnIfRemote()
+	if cfg.KeepaliveInterval > 0 {
+		c.background.Add(1)
+		go c.keepAlive()
+	}
+
+	return c, nil
+}
+
+// method returns the HTTP method of forwarded requests.
+func (c *EndpointClient) method() string {
+	if c.cfg.Method == "" {
+		return http.MethodPost
+	}
+
+	return strings.ToUpper(c.cfg.Method)
+}
+
+// validateEndpointURL checks that the URL is an absolute http or https URL,
+// or a Unix domain socket URL, so that typos such as a missing scheme are
+// reported before the first event is forwarded.
+func validateEndpointURL(rawURL string) error {
+	if strings.HasPrefix(rawURL, unixSocketScheme) {
+		if strings.TrimPrefix(rawURL, unixSocketScheme) == "" {
+			return fmt.Errorf("invalid endpoint URL %q: missing socket path", rawURL)
+		}
+		return nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid endpoint URL %q: %w", rawURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid endpoint URL %q: unsupported scheme %q, expected http or https", rawURL, u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid endpoint URL %q: missing host", rawURL)
+	}
+
+	return nil
+}
+
 func convertToMap(events []string) map[string]bool {
 	eventsMap := make(map[string]bool)
 	for _, event := range events {
-		eventsMap[event] = true
+		eventsMap[strings.ToLower(event)] = true
 	}
 
 	return eventsMap
 }
+
+// matchesEventType returns whether the event type is matched by the map of
+// events built by convertToMap, either exactly, through the "*" catch-all or
+// through a wildcard pattern on one of its prefixes (e.g. "customer.*" or
+// "customer.subscription.*" for "customer.subscription.created").
+func matchesEventType(events map[string]bool, eventType string) bool {
+	eventType = strings.ToLower(eventType)
+
+	// Exact matches and the catch-all take priority over patterns
+	if events["*"] || events[eventType] {
+		return true
+	}
+
+	for i := strings.LastIndex(eventType, "."); i > 0; i = strings.LastIndex(eventType[:i], ".") {
+		if events[eventType[:i]+".*"] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// appendURLPath appends the path to the path of the URL, with a single slash
+// between them.
+func appendURLPath(rawURL string, path string) (string, error) {
+	if path == "" {
+		return rawURL, nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	u.Path = strings.TrimRight(u.Path, "/") + "/" + strings.TrimLeft(path, "/")
+	u.RawPath = ""
+
+	return u.String(), nil
+}
+
+// newRequestID returns a random version 4 UUID.
+func newRequestID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+func gzipBody(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -1,13 +1,24 @@
 package proxy
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/stripe-cli/pkg/proxy/proxytest"
 )
 
 func TestClientHandler(t *testing.T) {
@@ -30,7 +41,7 @@ func TestClientHandler(t *testing.T) {
 
 	rcvBody := ""
 	rcvWebhookID := ""
-	client := NewEndpointClient(
+	client, err := NewEndpointClient(
 		ts.URL,
 		false,
 		[]string{"*"},
@@ -46,6 +57,7 @@ func TestClientHandler(t *testing.T) {
 			}),
 		},
 	)
+	require.Nil(t, err)
 
 	webhookID := "wh_123"
 	payload := "{}"
@@ -54,7 +66,7 @@ func TestClientHandler(t *testing.T) {
 		"Stripe-Signature": "t=123,v1=hunter2",
 	}
 
-	err := client.Post(webhookID, payload, headers)
+	err = client.Post(webhookID, payload, headers)
 
 	wg.Wait()
 
@@ -62,3 +74,915 @@ func TestClientHandler(t *testing.T) {
 	require.Equal(t, "OK!", rcvBody)
 	require.Equal(t, "wh_123", rcvWebhookID)
 }
+
+func TestNewEndpointClientTimeout(t *testing.T) {
+	client, err := NewEndpointClient("http://localhost", false, []string{"*"}, nil)
+	require.Nil(t, err)
+	require.Equal(t, defaultTimeout, client.cfg.HTTPClient.Timeout)
+
+	client, err = NewEndpointClient("http://localhost", false, []string{"*"}, &EndpointConfig{
+		Timeout: 5 * time.Second,
+	})
+	require.Nil(t, err)
+	require.Equal(t, 5*time.Second, client.cfg.HTTPClient.Timeout)
+
+	httpClient := &http.Client{Timeout: time.Minute}
+	client, err = NewEndpointClient("http://localhost", false, []string{"*"}, &EndpointConfig{
+		HTTPClient: httpClient,
+		Timeout:    5 * time.Second,
+	})
+	require.Nil(t, err)
+	require.Equal(t, httpClient, client.cfg.HTTPClient)
+	require.Equal(t, time.Minute, client.cfg.HTTPClient.Timeout)
+}
+
+func TestPostWithContextCanceled(t *testing.T) {
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	handlerCalled := false
+	client, err := NewEndpointClient(
+		ts.URL,
+		false,
+		[]string{"*"},
+		&EndpointConfig{
+			ResponseHandler: EndpointResponseHandlerFunc(func(webhookID string, resp *http.Response) {
+				handlerCalled = true
+			}),
+		},
+	)
+	require.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err = client.PostWithContext(ctx, "wh_123", "{}", map[string]string{})
+
+	require.Equal(t, context.DeadlineExceeded, err)
+	require.False(t, handlerCalled)
+}
+
+func TestSupportsEventType(t *testing.T) {
+	client, err := NewEndpointClient("http://localhost", false, []string{"charge.succeeded", "invoice.*", "Customer.Subscription.*"}, nil)
+	require.Nil(t, err)
+
+	require.True(t, client.SupportsEventType(false, "charge.succeeded"))
+	require.True(t, client.SupportsEventType(false, "CHARGE.SUCCEEDED"))
+	require.False(t, client.SupportsEventType(false, "charge.failed"))
+	require.False(t, client.SupportsEventType(true, "charge.succeeded"))
+
+	require.True(t, client.SupportsEventType(false, "invoice.paid"))
+	require.True(t, client.SupportsEventType(false, "invoice.payment.failed"))
+	require.False(t, client.SupportsEventType(false, "invoice"))
+	require.False(t, client.SupportsEventType(false, "invoiceitem.created"))
+
+	require.True(t, client.SupportsEventType(false, "customer.subscription.created"))
+	require.False(t, client.SupportsEventType(false, "customer.created"))
+
+	catchAll, err := NewEndpointClient("http://localhost", true, []string{"*"}, nil)
+	require.Nil(t, err)
+	require.True(t, catchAll.SupportsEventType(true, "anything.at.all"))
+}
+
+func TestSupportsEventTypeExcludedEvents(t *testing.T) {
+	client, err := NewEndpointClient("http://localhost", false, []string{"*"}, &EndpointConfig{
+		ExcludedEvents: []string{"charge.updated", "balance.*"},
+	})
+	require.Nil(t, err)
+
+	require.True(t, client.SupportsEventType(false, "charge.succeeded"))
+	require.False(t, client.SupportsEventType(false, "charge.updated"))
+	require.False(t, client.SupportsEventType(false, "balance.available"))
+
+	client, err = NewEndpointClient("http://localhost", false, []string{"invoice.paid"}, &EndpointConfig{
+		ExcludedEvents: []string{"invoice.*"},
+	})
+	require.Nil(t, err)
+
+	require.False(t, client.SupportsEventType(false, "invoice.paid"))
+}
+
+func TestSupportsEventTypeLogSkipped(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New()
+	logger.Out = &buf
+
+	client, err := NewEndpointClient("http://localhost", false, []string{"charge.*"}, &EndpointConfig{
+		ExcludedEvents: []string{"charge.updated"},
+		LogSkipped:     true,
+		Log:            logger,
+	})
+	require.Nil(t, err)
+
+	require.True(t, client.SupportsEventType(false, "charge.succeeded"))
+	require.Equal(t, "", buf.String())
+
+	require.False(t, client.SupportsEventType(false, "invoice.paid"))
+	require.Contains(t, buf.String(), "invoice.paid")
+	require.Contains(t, buf.String(), "not in the list of events")
+
+	require.False(t, client.SupportsEventType(false, "charge.updated"))
+	require.Contains(t, buf.String(), "excluded")
+
+	require.False(t, client.SupportsEventType(true, "charge.succeeded"))
+	require.Contains(t, buf.String(), "Connect events aren't forwarded")
+
+	// Skipped events aren't logged by default
+	buf.Reset()
+	client.cfg.LogSkipped = false
+	require.False(t, client.SupportsEventType(false, "invoice.paid"))
+	require.Equal(t, "", buf.String())
+}
+
+func TestPostStaticHeaders(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "dev-token", r.Header.Get("X-Dev-Token"))
+		require.Equal(t, "event", r.Header.Get("X-Overridden"))
+		require.Equal(t, "t=123,v1=hunter2", r.Header.Get("Stripe-Signature"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		StaticHeaders: map[string]string{
+			"X-Dev-Token":  "dev-token",
+			"x-overridden": "static",
+		},
+	})
+	require.Nil(t, err)
+
+	err = client.Post("wh_123", "{}", map[string]string{
+		"Stripe-Signature": "t=123,v1=hunter2",
+		"X-Overridden":     "event",
+	})
+	require.Nil(t, err)
+}
+
+func TestPostBuffersResponseBody(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("0123456789"))
+	}))
+	defer ts.Close()
+
+	rcvBody := ""
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		MaxResponseBodyBytes: 4,
+		ResponseHandler: EndpointResponseHandlerFunc(func(webhookID string, resp *http.Response) {
+			buf, err := ioutil.ReadAll(resp.Body)
+			require.Nil(t, err)
+			rcvBody = string(buf)
+		}),
+	})
+	require.Nil(t, err)
+
+	err = client.Post("wh_123", "{}", map[string]string{})
+
+	require.Nil(t, err)
+	require.Equal(t, "0123", rcvBody)
+}
+
+func TestPostLimitsEndlessResponseBody(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		chunk := []byte(strings.Repeat("a", 1024))
+		for {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer ts.Close()
+
+	var rcvBody []byte
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		MaxResponseBodyBytes: 4096,
+		RetryPolicy:          RetryPolicy{MaxAttempts: 2},
+		ResponseHandler: EndpointResponseHandlerFunc(func(webhookID string, resp *http.Response) {
+			var err error
+			rcvBody, err = ioutil.ReadAll(resp.Body)
+			require.Nil(t, err)
+		}),
+	})
+	require.Nil(t, err)
+
+	// The body of the retried attempt is discarded up to the limit too
+	err = client.Post("wh_123", "{}", map[string]string{})
+	require.Nil(t, err)
+	require.Equal(t, int32(2), atomic.LoadInt32(&requests))
+	require.Len(t, rcvBody, 4096)
+}
+
+func TestPostTransform(t *testing.T) {
+	var contentLength int64
+	var rcvBody string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentLength = r.ContentLength
+		buf, _ := ioutil.ReadAll(r.Body)
+		rcvBody = string(buf)
+	}))
+	defer ts.Close()
+
+	transformErr := errors.New("unsupported event")
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		Transform: func(eventType string, body []byte) ([]byte, error) {
+			if eventType == "charge.failed" {
+				return nil, transformErr
+			}
+			return []byte(fmt.Sprintf(`{"kind":%q,"payload":%s}`, eventType, body)), nil
+		},
+	})
+	require.Nil(t, err)
+
+	body := `{"type":"invoice.paid"}`
+	err = client.Post("wh_123", body, map[string]string{"Content-Length": fmt.Sprint(len(body))})
+	require.Nil(t, err)
+
+	expected := `{"kind":"invoice.paid","payload":{"type":"invoice.paid"}}`
+	require.Equal(t, expected, rcvBody)
+	require.Equal(t, int64(len(expected)), contentLength)
+
+	rcvBody = ""
+	err = client.Post("wh_123", `{"type":"charge.failed"}`, map[string]string{})
+	require.Equal(t, transformErr, err)
+	require.Equal(t, "", rcvBody)
+}
+
+func TestPostCompressRequests(t *testing.T) {
+	payload := `{"data": "` + strings.Repeat("a", 2000) + `"}`
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, err := ioutil.ReadAll(r.Body)
+		require.Nil(t, err)
+		require.Equal(t, int64(len(raw)), r.ContentLength)
+
+		if r.Header.Get("Content-Encoding") != "gzip" {
+			require.Equal(t, "{}", string(raw))
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		require.True(t, len(raw) < len(payload))
+		gz, err := gzip.NewReader(bytes.NewReader(raw))
+		require.Nil(t, err)
+		reqBody, err := ioutil.ReadAll(gz)
+		require.Nil(t, err)
+		require.Equal(t, payload, string(reqBody))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	rcvStatus := 0
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		CompressRequests: true,
+		ResponseHandler: EndpointResponseHandlerFunc(func(webhookID string, resp *http.Response) {
+			rcvStatus = resp.StatusCode
+		}),
+	})
+	require.Nil(t, err)
+
+	err = client.Post("wh_123", payload, map[string]string{"Content-Length": "12"})
+	require.Nil(t, err)
+	require.Equal(t, http.StatusAccepted, rcvStatus)
+
+	// Small payloads are sent as is
+	err = client.Post("wh_123", "{}", map[string]string{})
+	require.Nil(t, err)
+	require.Equal(t, http.StatusOK, rcvStatus)
+}
+
+func TestPostBeforePost(t *testing.T) {
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		require.Equal(t, "hook", r.Header.Get("X-Dev-Token"))
+		require.Equal(t, "sig-{}", r.Header.Get("X-Signature"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		StaticHeaders: map[string]string{"X-Dev-Token": "static"},
+		BeforePost: func(req *http.Request, body []byte) error {
+			require.Equal(t, "static", req.Header.Get("X-Dev-Token"))
+			req.Header.Set("X-Dev-Token", "hook")
+			req.Header.Set("X-Signature", "sig-"+string(body))
+			return nil
+		},
+	})
+	require.Nil(t, err)
+
+	err = client.Post("wh_123", "{}", map[string]string{})
+	require.Nil(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&count))
+}
+
+func TestPostBeforePostError(t *testing.T) {
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+	}))
+	defer ts.Close()
+
+	hookErr := errors.New("no signing key")
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		RetryPolicy: RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+		BeforePost: func(req *http.Request, body []byte) error {
+			return hookErr
+		},
+	})
+	require.Nil(t, err)
+
+	err = client.Post("wh_123", "{}", map[string]string{})
+	require.Equal(t, hookErr, err)
+	require.Equal(t, int32(0), atomic.LoadInt32(&count))
+	require.Equal(t, int64(0), client.Metrics().Attempted)
+}
+
+func TestPostResponseHandlerV2(t *testing.T) {
+	clock := proxytest.NewFakeClock(time.Date(2019, 9, 1, 12, 0, 0, 0, time.UTC))
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clock.Advance(10 * time.Millisecond)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+
+	var rcv *EndpointResponse
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		Clock: clock,
+		ResponseHandler: EndpointResponseHandlerV2Func(func(resp *EndpointResponse) {
+			rcv = resp
+		}),
+	})
+	require.Nil(t, err)
+
+	err = client.Post("wh_123", `{"id":"evt_123","type":"charge.succeeded"}`, map[string]string{})
+	require.Nil(t, err)
+
+	require.NotNil(t, rcv)
+	require.Equal(t, "wh_123", rcv.WebhookID)
+	require.Equal(t, "evt_123", rcv.EventID)
+	require.Equal(t, "charge.succeeded", rcv.EventType)
+	require.Equal(t, http.StatusCreated, rcv.Response.StatusCode)
+	require.Equal(t, 10*time.Millisecond, rcv.Duration)
+}
+
+func TestPostLogsEventID(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	logger := log.New()
+	logger.Out = &buf
+	logger.SetLevel(log.DebugLevel)
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		Log: logger,
+	})
+	require.Nil(t, err)
+
+	require.Nil(t, client.Post("wh_123", `{"id":"evt_123","type":"charge.succeeded"}`, map[string]string{}))
+
+	output := buf.String()
+	require.Contains(t, output, "Received response from local endpoint")
+	require.Contains(t, output, "evt_123")
+	require.Contains(t, output, "charge.succeeded")
+}
+
+func TestPostResponseActionHandler(t *testing.T) {
+	var attempts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.Write([]byte("processing, try again"))
+			return
+		}
+		w.Write([]byte("done"))
+	}))
+	defer ts.Close()
+
+	handler := EndpointResponseActionHandlerFunc(func(resp *EndpointResponse) ResponseAction {
+		body, _ := ioutil.ReadAll(resp.Response.Body)
+		if string(body) == "processing, try again" {
+			return Retry
+		}
+		return Ack
+	})
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		ResponseHandler: handler,
+		RetryPolicy:     RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+	})
+	require.Nil(t, err)
+
+	err = client.Post("wh_123", "{}", map[string]string{})
+	require.Nil(t, err)
+	require.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+
+	// The handler asks for more attempts than the policy allows
+	atomic.StoreInt32(&attempts, 0)
+	client, err = NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		ResponseHandler: handler,
+		RetryPolicy:     RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond},
+	})
+	require.Nil(t, err)
+
+	err = client.Post("wh_123", "{}", map[string]string{})
+	require.Equal(t, ErrNotAcknowledged, err)
+	require.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+}
+
+func TestPostResponseActionHandlerDrop(t *testing.T) {
+	var attempts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		ResponseHandler: EndpointResponseActionHandlerFunc(func(resp *EndpointResponse) ResponseAction {
+			return Drop
+		}),
+		RetryPolicy:  RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+		FailOnNon2xx: true,
+	})
+	require.Nil(t, err)
+
+	// Dropping the event overrides the retry policy
+	err = client.Post("wh_123", "{}", map[string]string{})
+	require.Nil(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+}
+
+func TestPostSkipsStaleEvents(t *testing.T) {
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		MaxEventAge: time.Hour,
+	})
+	require.Nil(t, err)
+
+	stale := time.Now().Add(-2 * time.Hour).Unix()
+	fresh := time.Now().Add(-time.Minute).Unix()
+
+	require.Nil(t, client.Post("wh_123", fmt.Sprintf(`{"id":"evt_1","created":%d}`, stale), map[string]string{}))
+	require.Equal(t, int32(0), atomic.LoadInt32(&count))
+
+	require.Nil(t, client.Post("wh_123", fmt.Sprintf(`{"id":"evt_2","created":%d}`, fresh), map[string]string{}))
+	require.Equal(t, int32(1), atomic.LoadInt32(&count))
+
+	// Events without a creation time are forwarded
+	require.Nil(t, client.Post("wh_123", `{"id":"evt_3"}`, map[string]string{}))
+	require.Equal(t, int32(2), atomic.LoadInt32(&count))
+
+	client, err = NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		MaxEventAge:        time.Hour,
+		EventCreatedHeader: "X-Event-Created",
+	})
+	require.Nil(t, err)
+
+	require.Nil(t, client.Post("wh_123", fmt.Sprintf(`{"id":"evt_4","created":%d}`, fresh), map[string]string{
+		"x-event-created": time.Now().Add(-2 * time.Hour).Format(time.RFC3339),
+	}))
+	require.Nil(t, client.Post("wh_123", "{}", map[string]string{
+		"X-Event-Created": fmt.Sprint(stale),
+	}))
+	require.Equal(t, int32(2), atomic.LoadInt32(&count))
+
+	require.Nil(t, client.Post("wh_123", "{}", map[string]string{
+		"X-Event-Created": fmt.Sprint(fresh),
+	}))
+	require.Equal(t, int32(3), atomic.LoadInt32(&count))
+}
+
+func TestSupportsLivemode(t *testing.T) {
+	client, err := NewEndpointClient("http://localhost", false, []string{"*"}, nil)
+	require.Nil(t, err)
+	require.True(t, client.SupportsLivemode(true))
+	require.True(t, client.SupportsLivemode(false))
+
+	client, err = NewEndpointClient("http://localhost", false, []string{"*"}, &EndpointConfig{
+		AllowTestmode: true,
+	})
+	require.Nil(t, err)
+	require.False(t, client.SupportsLivemode(true))
+	require.True(t, client.SupportsLivemode(false))
+}
+
+func TestPostSkipsDisallowedLivemode(t *testing.T) {
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		AllowTestmode: true,
+	})
+	require.Nil(t, err)
+
+	err = client.Post("wh_123", `{"id":"evt_123","livemode":true}`, map[string]string{})
+	require.Nil(t, err)
+	require.Equal(t, int32(0), atomic.LoadInt32(&count))
+
+	err = client.Post("wh_123", `{"id":"evt_456","livemode":false}`, map[string]string{})
+	require.Nil(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&count))
+}
+
+func TestPostAuthorization(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer ts.Close()
+
+	rcvBody := ""
+	handler := EndpointResponseHandlerFunc(func(webhookID string, resp *http.Response) {
+		buf, err := ioutil.ReadAll(resp.Body)
+		require.Nil(t, err)
+		rcvBody = string(buf)
+	})
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		BasicAuthUser:     "user",
+		BasicAuthPassword: "pass",
+		ResponseHandler:   handler,
+	})
+	require.Nil(t, err)
+	require.Nil(t, client.Post("wh_123", "{}", map[string]string{}))
+	require.Equal(t, "Basic dXNlcjpwYXNz", rcvBody)
+
+	client, err = NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		BearerToken:     "sk_dev",
+		ResponseHandler: handler,
+	})
+	require.Nil(t, err)
+	require.Nil(t, client.Post("wh_123", "{}", map[string]string{}))
+	require.Equal(t, "Bearer sk_dev", rcvBody)
+}
+
+func TestNewEndpointClientInvalidURL(t *testing.T) {
+	for _, url := range []string{"localhost:4242", "ftp://localhost", "http://", "http://%41:4242", "unix://"} {
+		_, err := NewEndpointClient(url, false, []string{"*"}, nil)
+		require.NotNil(t, err, url)
+	}
+
+	for _, url := range []string{"http://localhost:4242/webhooks", "https://127.0.0.1", "unix:///tmp/endpoint.sock"} {
+		_, err := NewEndpointClient(url, false, []string{"*"}, nil)
+		require.Nil(t, err, url)
+	}
+}
+
+func TestNewEndpointClientConflictingAuthorization(t *testing.T) {
+	_, err := NewEndpointClient("http://localhost", false, []string{"*"}, &EndpointConfig{
+		BasicAuthUser: "user",
+		BearerToken:   "sk_dev",
+	})
+	require.NotNil(t, err)
+}
+
+func TestPostContentType(t *testing.T) {
+	var contentType []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header["Content-Type"]
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, nil)
+	require.Nil(t, err)
+
+	require.Nil(t, client.Post("wh_123", "{}", map[string]string{}))
+	require.Equal(t, []string{"application/json"}, contentType)
+
+	// The event's Content-Type wins
+	require.Nil(t, client.Post("wh_123", "{}", map[string]string{"content-type": "application/json; charset=utf-8"}))
+	require.Equal(t, []string{"application/json; charset=utf-8"}, contentType)
+
+	client, err = NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		DisableDefaultContentType: true,
+	})
+	require.Nil(t, err)
+
+	require.Nil(t, client.Post("wh_123", "{}", map[string]string{}))
+	require.Nil(t, contentType)
+}
+
+func TestPostMethod(t *testing.T) {
+	var method string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		Method: "put",
+	})
+	require.Nil(t, err)
+
+	require.Nil(t, client.Post("wh_123", "{}", map[string]string{}))
+	require.Equal(t, http.MethodPut, method)
+
+	_, err = NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		Method: http.MethodGet,
+	})
+	require.NotNil(t, err)
+}
+
+func TestPostAccountHeader(t *testing.T) {
+	var account []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		account = r.Header["Stripe-Account"]
+	}))
+	defer ts.Close()
+
+	body := `{"id":"evt_123","type":"charge.succeeded","account":"acct_123"}`
+
+	client, err := NewEndpointClient(ts.URL, true, []string{"*"}, nil)
+	require.Nil(t, err)
+
+	require.Nil(t, client.Post("wh_123", body, map[string]string{}))
+	require.Equal(t, []string{"acct_123"}, account)
+
+	client, err = NewEndpointClient(ts.URL, true, []string{"*"}, &EndpointConfig{
+		DisableAccountHeader: true,
+	})
+	require.Nil(t, err)
+
+	require.Nil(t, client.Post("wh_123", body, map[string]string{}))
+	require.Nil(t, account)
+
+	// Clients that don't forward Connect events don't set the header
+	client, err = NewEndpointClient(ts.URL, false, []string{"*"}, nil)
+	require.Nil(t, err)
+
+	require.Nil(t, client.Post("wh_123", body, map[string]string{}))
+	require.Nil(t, account)
+}
+
+func TestPostRequestIDHeader(t *testing.T) {
+	var requestIDs, attempts []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestIDs = append(requestIDs, r.Header.Get("X-Request-Id"))
+		attempts = append(attempts, r.Header.Get("X-Attempt"))
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		RequestIDHeader: "X-Request-Id",
+		AttemptHeader:   "X-Attempt",
+		RetryPolicy:     RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+	})
+	require.Nil(t, err)
+
+	// The ID is the same for all the attempts of an event
+	require.Nil(t, client.Post("wh_123", "{}", map[string]string{}))
+	require.Len(t, requestIDs, 3)
+	require.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, requestIDs[0])
+	require.Equal(t, requestIDs[0], requestIDs[1])
+	require.Equal(t, requestIDs[0], requestIDs[2])
+	require.Equal(t, []string{"1", "2", "3"}, attempts)
+
+	// Every event gets a new ID, unless it already has one
+	require.Nil(t, client.Post("wh_123", "{}", map[string]string{}))
+	require.NotEqual(t, requestIDs[0], requestIDs[3])
+
+	require.Nil(t, client.Post("wh_123", "{}", map[string]string{"X-Request-Id": "req_123"}))
+	require.Equal(t, "req_123", requestIDs[6])
+}
+
+func TestPostUserAgent(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("User-Agent")))
+	}))
+	defer ts.Close()
+
+	rcvBody := ""
+	handler := EndpointResponseHandlerFunc(func(webhookID string, resp *http.Response) {
+		buf, err := ioutil.ReadAll(resp.Body)
+		require.Nil(t, err)
+		rcvBody = string(buf)
+	})
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		ResponseHandler: handler,
+	})
+	require.Nil(t, err)
+	require.Nil(t, client.Post("wh_123", "{}", map[string]string{}))
+	require.True(t, strings.HasPrefix(rcvBody, "StripeCLI-Proxy/"))
+
+	client, err = NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		UserAgent:       "staging-listener",
+		ResponseHandler: handler,
+	})
+	require.Nil(t, err)
+	require.Nil(t, client.Post("wh_123", "{}", map[string]string{}))
+	require.Equal(t, "staging-listener", rcvBody)
+
+	// The event's User-Agent wins
+	require.Nil(t, client.Post("wh_123", "{}", map[string]string{"User-Agent": "Stripe/1.0"}))
+	require.Equal(t, "Stripe/1.0", rcvBody)
+}
+
+func TestPayloadTimeout(t *testing.T) {
+	p := PayloadTimeout{Base: time.Second, BytesPerSecond: 1000, Max: 5 * time.Second}
+
+	require.Equal(t, time.Second, p.timeout(0))
+	require.Equal(t, 3*time.Second, p.timeout(2000))
+	require.Equal(t, 5*time.Second, p.timeout(1000000))
+	require.Equal(t, time.Duration(0), PayloadTimeout{}.timeout(1000))
+}
+
+func TestPostPayloadTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > 100 {
+			// The request is only canceled once its body was read
+			ioutil.ReadAll(r.Body) // #nosec G104
+			<-r.Context().Done()
+			return
+		}
+		w.Write([]byte("OK!"))
+	}))
+	defer ts.Close()
+
+	rcvBody := ""
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		PayloadTimeout: PayloadTimeout{Base: 10 * time.Millisecond, BytesPerSecond: 1000, Max: 20 * time.Millisecond},
+		ResponseHandler: EndpointResponseHandlerFunc(func(webhookID string, resp *http.Response) {
+			buf, err := ioutil.ReadAll(resp.Body)
+			require.Nil(t, err)
+			rcvBody = string(buf)
+		}),
+	})
+	require.Nil(t, err)
+
+	// The response body can still be read once the attempt is over
+	err = client.Post("wh_123", "{}", map[string]string{})
+	require.Nil(t, err)
+	require.Equal(t, "OK!", rcvBody)
+
+	err = client.Post("wh_123", `{"data": "`+strings.Repeat("a", 200)+`"}`, map[string]string{})
+	require.NotNil(t, err)
+}
+
+func TestPostTimeoutForEvent(t *testing.T) {
+	received := make(chan struct{})
+	unblock := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf, _ := ioutil.ReadAll(r.Body)
+		if strings.Contains(string(buf), "report.ready") {
+			close(received)
+			<-unblock
+			return
+		}
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		Timeout: 10 * time.Millisecond,
+		TimeoutForEvent: func(eventType string) time.Duration {
+			if eventType == "report.ready" {
+				return time.Second
+			}
+			return 0
+		},
+		ResponseHandler: EndpointResponseHandlerFunc(func(string, *http.Response) {}),
+	})
+	require.Nil(t, err)
+	require.Equal(t, time.Duration(0), client.cfg.HTTPClient.Timeout)
+
+	errs := make(chan error)
+	go func() { errs <- client.Post("wh_123", `{"type":"report.ready"}`, map[string]string{}) }()
+	<-received
+
+	err = client.Post("wh_123", `{"type":"charge.succeeded"}`, map[string]string{})
+	require.True(t, errors.Is(err, ErrEndpointTimeout))
+
+	// The deadline of the event exceeds Timeout, which already elapsed
+	close(unblock)
+	require.Nil(t, <-errs)
+}
+
+func TestEvents(t *testing.T) {
+	client, err := NewEndpointClient("http://localhost", true, []string{"invoice.*", "charge.succeeded", "Charge.Succeeded", "balance.available"}, nil)
+	require.Nil(t, err)
+
+	events := client.Events()
+	require.Equal(t, []string{"balance.available", "charge.succeeded", "invoice.*"}, events)
+	require.True(t, client.Connect())
+
+	// The returned list is a copy
+	events[0] = "customer.created"
+	require.Equal(t, "balance.available", client.Events()[0])
+}
+
+func TestSetEvents(t *testing.T) {
+	client, err := NewEndpointClient("http://localhost", false, []string{"invoice.*"}, nil)
+	require.Nil(t, err)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			client.SupportsEventType(false, "charge.succeeded")
+		}
+	}()
+
+	client.SetEvents([]string{"Charge.Succeeded"})
+	wg.Wait()
+
+	require.True(t, client.SupportsEventType(false, "charge.succeeded"))
+	require.False(t, client.SupportsEventType(false, "invoice.paid"))
+	require.Equal(t, []string{"charge.succeeded"}, client.Events())
+}
+
+func TestAppendURLPath(t *testing.T) {
+	tests := []struct{ url, path, expected string }{
+		{"http://localhost:3000/webhooks", "invoices", "http://localhost:3000/webhooks/invoices"},
+		{"http://localhost:3000/webhooks/", "/invoices", "http://localhost:3000/webhooks/invoices"},
+		{"http://localhost:3000", "invoices/", "http://localhost:3000/invoices/"},
+		{"http://localhost:3000/webhooks?token=abc", "charges", "http://localhost:3000/webhooks/charges?token=abc"},
+		{"http://localhost:3000/webhooks", "", "http://localhost:3000/webhooks"},
+	}
+	for _, test := range tests {
+		actual, err := appendURLPath(test.url, test.path)
+		require.Nil(t, err)
+		require.Equal(t, test.expected, actual)
+	}
+}
+
+func TestPostPathForEvent(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer ts.Close()
+
+	rcvBody := ""
+	client, err := NewEndpointClient(ts.URL+"/webhooks/", false, []string{"*"}, &EndpointConfig{
+		PathForEvent: func(eventType string) string {
+			return strings.SplitN(eventType, ".", 2)[0]
+		},
+		ResponseHandler: EndpointResponseHandlerFunc(func(webhookID string, resp *http.Response) {
+			buf, err := ioutil.ReadAll(resp.Body)
+			require.Nil(t, err)
+			rcvBody = string(buf)
+		}),
+	})
+	require.Nil(t, err)
+
+	err = client.Post("wh_123", `{"type":"invoice.paid"}`, map[string]string{})
+	require.Nil(t, err)
+	require.Equal(t, "/webhooks/invoice", rcvBody)
+}
+
+func TestPostMaxRequestBodyBytes(t *testing.T) {
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		MaxRequestBodyBytes: 20,
+	})
+	require.Nil(t, err)
+
+	err = client.Post("wh_123", `{"id":"evt_123"}`, map[string]string{})
+	require.Nil(t, err)
+
+	err = client.Post("wh_123", `{"id":"evt_123","data":"`+strings.Repeat("a", 20)+`"}`, map[string]string{})
+	require.Equal(t, ErrBodyTooLarge, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&count))
+}
+
+func TestEndpointImplementations(t *testing.T) {
+	var endpoints []Endpoint
+
+	client, err := NewEndpointClient("http://localhost", false, []string{"*"}, nil)
+	require.Nil(t, err)
+	multi, err := NewMultiEndpointClient([]string{"http://localhost"}, false, []string{"*"}, nil)
+	require.Nil(t, err)
+	routing, err := NewRoutingEndpointClient(nil, "http://localhost", false, nil)
+	require.Nil(t, err)
+	failover, err := NewFailoverClient("http://localhost", "http://localhost:8080", false, []string{"*"}, nil)
+	require.Nil(t, err)
+
+	endpoints = append(endpoints, client, multi, routing, failover)
+	for _, endpoint := range endpoints {
+		require.True(t, endpoint.SupportsEventType(false, "invoice.paid"))
+	}
+}
@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+//
+// Public variables
+//
+
+// ErrEndpointUnreachable is matched by the errors of EndpointClient.Post when
+// the endpoint couldn't be reached, e.g. because its host couldn't be
+// resolved or the connection was refused.
+var ErrEndpointUnreachable = errors.New("endpoint is unreachable")
+
+// ErrHostNotFound is matched by the errors of EndpointClient.Post when the
+// host of the endpoint couldn't be resolved, e.g. because of a typo in its
+// URL. These errors also match ErrEndpointUnreachable.
+var ErrHostNotFound = errors.New("endpoint host not found")
+
+// ErrEndpointTimeout is matched by the errors of EndpointClient.Post when the
+// endpoint didn't respond in time.
+var ErrEndpointTimeout = errors.New("endpoint timed out")
+
+//
+// Public types
+//
+
+// HTTPStatusError is returned when the endpoint responded with an unexpected
+// status code, by EndpointClient.Post if FailOnNon2xx is set and by
+// EndpointClient.Ping.
+type HTTPStatusError struct {
+	Code int
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("endpoint responded with status code %d", e.Code)
+}
+
+// RedirectError is returned by EndpointClient.Post when the endpoint
+// responded with a redirect that wasn't followed, see FollowRedirects.
+type RedirectError struct {
+	Code     int
+	Location string
+}
+
+func (e *RedirectError) Error() string {
+	return fmt.Sprintf("endpoint redirected with status code %d to %s", e.Code, e.Location)
+}
+
+//
+// Private types
+//
+
+// endpointError wraps a transport error with the kind of failure it
+// denotes, so that it matches both with errors.Is.
+type endpointError struct {
+	kind error
+	err  error
+}
+
+func (e *endpointError) Error() string {
+	return fmt.Sprintf("%v: %v", e.kind, e.err)
+}
+
+func (e *endpointError) Unwrap() error {
+	return e.err
+}
+
+func (e *endpointError) Is(target error) bool {
+	// A host that isn't found is a kind of unreachable endpoint
+	return target == e.kind || (e.kind == ErrHostNotFound && target == ErrEndpointUnreachable)
+}
+
+//
+// Private functions
+//
+
+// classifyError wraps transport errors that denote a timeout, a host that
+// isn't found or an unreachable endpoint. Other errors are returned as is.
+func classifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return &endpointError{kind: ErrEndpointTimeout, err: err}
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return &endpointError{kind: ErrHostNotFound, err: err}
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return &endpointError{kind: ErrEndpointUnreachable, err: err}
+	}
+
+	return err
+}
@@ -0,0 +1,225 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostUnreachable(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	endpointURL := ts.URL
+	ts.Close()
+
+	client, err := NewEndpointClient(endpointURL, false, []string{"*"}, nil)
+	require.Nil(t, err)
+
+	err = client.Post("wh_123", "{}", map[string]string{})
+	require.True(t, errors.Is(err, ErrEndpointUnreachable))
+	require.False(t, errors.Is(err, ErrEndpointTimeout))
+
+	// The underlying error is still available
+	var urlErr *url.Error
+	require.True(t, errors.As(err, &urlErr))
+}
+
+func TestPostTimeout(t *testing.T) {
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		Timeout: 10 * time.Millisecond,
+	})
+	require.Nil(t, err)
+
+	err = client.Post("wh_123", "{}", map[string]string{})
+	require.True(t, errors.Is(err, ErrEndpointTimeout))
+	require.False(t, errors.Is(err, ErrEndpointUnreachable))
+}
+
+func TestPingHTTPStatusError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		ProbeStatusCodes: []int{http.StatusOK},
+	})
+	require.Nil(t, err)
+
+	var statusErr *HTTPStatusError
+	require.True(t, errors.As(client.Ping(context.Background()), &statusErr))
+	require.Equal(t, http.StatusNotFound, statusErr.Code)
+}
+
+func TestPostFailOnNon2xx(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fail") != "" {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	var rcv *EndpointResponse
+	handler := EndpointResponseHandlerV2Func(func(resp *EndpointResponse) {
+		rcv = resp
+	})
+
+	client, err := NewEndpointClient(ts.URL+"?fail=1", false, []string{"*"}, &EndpointConfig{
+		ResponseHandler: handler,
+	})
+	require.Nil(t, err)
+
+	// Rejections are only reported to the handler by default
+	err = client.Post("wh_123", "{}", map[string]string{})
+	require.Nil(t, err)
+	require.False(t, rcv.Success)
+
+	client, err = NewEndpointClient(ts.URL+"?fail=1", false, []string{"*"}, &EndpointConfig{
+		FailOnNon2xx:    true,
+		ResponseHandler: handler,
+	})
+	require.Nil(t, err)
+
+	rcv = nil
+	err = client.Post("wh_123", "{}", map[string]string{})
+	var statusErr *HTTPStatusError
+	require.True(t, errors.As(err, &statusErr))
+	require.Equal(t, http.StatusConflict, statusErr.Code)
+	require.NotNil(t, rcv)
+
+	client, err = NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		FailOnNon2xx:    true,
+		ResponseHandler: handler,
+	})
+	require.Nil(t, err)
+
+	err = client.Post("wh_123", "{}", map[string]string{})
+	require.Nil(t, err)
+	require.True(t, rcv.Success)
+}
+
+func TestPostSuccessStatusCodes(t *testing.T) {
+	var count int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count++
+		status, _ := strconv.Atoi(r.URL.Query().Get("status"))
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	var rcv *EndpointResponse
+	newClient := func(status int) *EndpointClient {
+		client, err := NewEndpointClient(fmt.Sprintf("%s?status=%d", ts.URL, status), false, []string{"*"}, &EndpointConfig{
+			SuccessStatusCodes: []int{http.StatusAccepted, http.StatusServiceUnavailable},
+			FailOnNon2xx:       true,
+			RetryPolicy:        RetryPolicy{MaxAttempts: 3, RetryableStatusCodes: []int{http.StatusOK, http.StatusServiceUnavailable}},
+			ResponseHandler: EndpointResponseHandlerV2Func(func(resp *EndpointResponse) {
+				rcv = resp
+			}),
+		})
+		require.Nil(t, err)
+		return client
+	}
+
+	client := newClient(http.StatusAccepted)
+	require.Nil(t, client.Post("wh_123", "{}", map[string]string{}))
+	require.True(t, rcv.Success)
+	require.Equal(t, int64(1), client.Metrics().Succeeded)
+
+	// Success status codes aren't retried, even if they are retryable
+	count = 0
+	client = newClient(http.StatusServiceUnavailable)
+	require.Nil(t, client.Post("wh_123", "{}", map[string]string{}))
+	require.True(t, rcv.Success)
+	require.Equal(t, 1, count)
+
+	// Other 2xx status codes are failures, retried according to the policy
+	count = 0
+	client = newClient(http.StatusOK)
+	err := client.Post("wh_123", "{}", map[string]string{})
+	var statusErr *HTTPStatusError
+	require.True(t, errors.As(err, &statusErr))
+	require.Equal(t, http.StatusOK, statusErr.Code)
+	require.False(t, rcv.Success)
+	require.Equal(t, 3, count)
+	require.Equal(t, int64(3), client.Metrics().Failed)
+
+	_, err = NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		SuccessStatusCodes: []int{2000},
+	})
+	require.NotNil(t, err)
+}
+
+func TestPostOnError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	var calls int
+	var rcvEventType string
+	var rcvErr error
+	onError := func(webhookID string, eventType string, err error) {
+		calls++
+		rcvEventType = eventType
+		rcvErr = err
+	}
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		OnError: onError,
+	})
+	require.Nil(t, err)
+
+	// Non-2xx responses aren't errors by default
+	require.Nil(t, client.Post("wh_123", `{"type":"invoice.paid"}`, map[string]string{}))
+	require.Equal(t, 0, calls)
+
+	client, err = NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		OnError:      onError,
+		FailOnNon2xx: true,
+	})
+	require.Nil(t, err)
+
+	err = client.Post("wh_123", `{"type":"invoice.paid"}`, map[string]string{})
+	require.NotNil(t, err)
+	require.Equal(t, 1, calls)
+	require.Equal(t, "invoice.paid", rcvEventType)
+	require.Equal(t, err, rcvErr)
+
+	client, err = NewEndpointClient("http://127.0.0.1:1", false, []string{"*"}, &EndpointConfig{
+		OnError: onError,
+	})
+	require.Nil(t, err)
+
+	err = client.Post("wh_123", `{"type":"charge.failed"}`, map[string]string{})
+	require.True(t, errors.Is(err, ErrEndpointUnreachable))
+	require.Equal(t, 2, calls)
+	require.Equal(t, "charge.failed", rcvEventType)
+	require.True(t, errors.Is(rcvErr, ErrEndpointUnreachable))
+}
+
+func TestPostHostNotFound(t *testing.T) {
+	client, err := NewEndpointClient("http://stripe-cli-test.invalid", false, []string{"*"}, nil)
+	require.Nil(t, err)
+
+	err = client.Post("wh_123", "{}", map[string]string{})
+	require.True(t, errors.Is(err, ErrHostNotFound))
+	require.True(t, errors.Is(err, ErrEndpointUnreachable))
+	require.False(t, errors.Is(err, ErrEndpointTimeout))
+}
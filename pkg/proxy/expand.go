@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//
+// Public variables
+//
+
+// ErrExpandFailed is matched by the errors of EndpointClient.Post when a
+// thin event couldn't be expanded and ForwardUnexpandedEvents isn't set.
+var ErrExpandFailed = errors.New("thin event couldn't be expanded")
+
+//
+// Private constants
+//
+
+// maxExpandedObjectBytes bounds the size of the objects fetched to expand
+// thin events
+const maxExpandedObjectBytes = 10 * 1024 * 1024
+
+//
+// Private functions
+//
+
+// maybeExpandEvent returns the body of the event with the object it refers
+// to if it is a thin event and ExpandThinEvents is set. Otherwise, or if the
+// event can't be expanded and ForwardUnexpandedEvents is set, the body is
+// returned as is.
+func (c *EndpointClient) maybeExpandEvent(ctx context.Context, webhookID string, evt *stripeEvent, body string) (string, error) {
+	if !c.cfg.ExpandThinEvents || !evt.isThin() {
+		return body, nil
+	}
+
+	expanded, err := c.expandEvent(ctx, evt, body)
+	if err == nil {
+		return expanded, nil
+	}
+
+	fields := log.Fields{
+		"prefix":     "proxy.EndpointClient.Post",
+		"webhook_id": webhookID,
+		"event_id":   evt.ID,
+	}
+	if c.cfg.ForwardUnexpandedEvents {
+		c.cfg.Log.WithFields(fields).Warnf("Failed to expand thin event, forwarding it as is, error = %v", err)
+		return body, nil
+	}
+	c.cfg.Log.WithFields(fields).Errorf("Not forwarding event, failed to expand thin event, error = %v", err)
+
+	return "", fmt.Errorf("%w: %v", ErrExpandFailed, err)
+}
+
+// expandEvent fetches the related object of the thin event and adds it to
+// the body as data.object, keeping the other fields of data, if any.
+func (c *EndpointClient) expandEvent(ctx context.Context, evt *stripeEvent, body string) (string, error) {
+	resp, err := c.cfg.APIClient.PerformRequest(http.MethodGet, evt.RelatedObject.URL, "", func(req *http.Request) {
+		*req = *req.WithContext(ctx)
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	object, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxExpandedObjectBytes))
+	if err != nil {
+		return "", err
+	}
+	if !isSuccessStatusCode(resp.StatusCode) {
+		return "", &HTTPStatusError{Code: resp.StatusCode}
+	}
+	if !json.Valid(object) {
+		return "", errors.New("the related object isn't valid JSON")
+	}
+
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(body), &payload); err != nil {
+		return "", err
+	}
+
+	fields := make(map[string]json.RawMessage)
+	if data, ok := payload["data"]; ok && string(data) != "null" {
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return "", fmt.Errorf("the data of the event isn't an object: %w", err)
+		}
+	}
+	fields["object"] = object
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+	payload["data"] = data
+
+	expanded, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	return string(expanded), nil
+}
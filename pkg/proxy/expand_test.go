@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/stripe-cli/pkg/stripe"
+)
+
+const thinEvent = `{"id":"evt_123","object":"v2.core.event","type":"v1.billing.meter.error_report_triggered","related_object":{"id":"mtr_123","type":"billing.meter","url":"/v1/billing/meters/mtr_123"}}`
+
+func newAPIClient(t *testing.T, handler http.HandlerFunc) (*stripe.Client, func()) {
+	ts := httptest.NewServer(handler)
+	baseURL, err := url.Parse(ts.URL)
+	require.Nil(t, err)
+
+	return &stripe.Client{BaseURL: baseURL, APIKey: "sk_test_123"}, ts.Close
+}
+
+func TestPostExpandThinEvents(t *testing.T) {
+	apiClient, closeAPI := newAPIClient(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodGet, r.Method)
+		require.Equal(t, "/v1/billing/meters/mtr_123", r.URL.Path)
+		require.Equal(t, "Bearer sk_test_123", r.Header.Get("Authorization"))
+		w.Write([]byte(`{"id":"mtr_123","object":"billing.meter"}`))
+	})
+	defer closeAPI()
+
+	var rcvBody string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.Nil(t, err)
+		rcvBody = string(body)
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		ExpandThinEvents: true,
+		APIClient:        apiClient,
+	})
+	require.Nil(t, err)
+
+	require.Nil(t, client.Post("wh_123", thinEvent, map[string]string{}))
+	require.JSONEq(t, `{
+		"id": "evt_123",
+		"object": "v2.core.event",
+		"type": "v1.billing.meter.error_report_triggered",
+		"related_object": {"id": "mtr_123", "type": "billing.meter", "url": "/v1/billing/meters/mtr_123"},
+		"data": {"object": {"id": "mtr_123", "object": "billing.meter"}}
+	}`, rcvBody)
+
+	// Thick events are forwarded as is
+	require.Nil(t, client.Post("wh_123", `{"id":"evt_456","data":{"object":{}}}`, map[string]string{}))
+	require.Equal(t, `{"id":"evt_456","data":{"object":{}}}`, rcvBody)
+}
+
+func TestPostExpandThinEventsWithData(t *testing.T) {
+	apiClient, closeAPI := newAPIClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"mtr_123","object":"billing.meter"}`))
+	})
+	defer closeAPI()
+
+	var rcvBody string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.Nil(t, err)
+		rcvBody = string(body)
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		ExpandThinEvents: true,
+		APIClient:        apiClient,
+	})
+	require.Nil(t, err)
+
+	// The fields of the existing data are kept
+	event := `{"id":"evt_123","type":"v1.billing.meter.error_report_triggered","related_object":{"id":"mtr_123","url":"/v1/billing/meters/mtr_123"},"data":{"developer_message_summary":"Meter not found"}}`
+	require.Nil(t, client.Post("wh_123", event, map[string]string{}))
+	require.JSONEq(t, `{
+		"id": "evt_123",
+		"type": "v1.billing.meter.error_report_triggered",
+		"related_object": {"id": "mtr_123", "url": "/v1/billing/meters/mtr_123"},
+		"data": {
+			"developer_message_summary": "Meter not found",
+			"object": {"id": "mtr_123", "object": "billing.meter"}
+		}
+	}`, rcvBody)
+}
+
+func TestPostExpandThinEventsFailure(t *testing.T) {
+	apiClient, closeAPI := newAPIClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	defer closeAPI()
+
+	var rcvBody string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.Nil(t, err)
+		rcvBody = string(body)
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		ExpandThinEvents: true,
+		APIClient:        apiClient,
+	})
+	require.Nil(t, err)
+
+	err = client.Post("wh_123", thinEvent, map[string]string{})
+	require.True(t, errors.Is(err, ErrExpandFailed))
+	require.Equal(t, "", rcvBody)
+
+	client, err = NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		ExpandThinEvents:        true,
+		APIClient:               apiClient,
+		ForwardUnexpandedEvents: true,
+	})
+	require.Nil(t, err)
+
+	require.Nil(t, client.Post("wh_123", thinEvent, map[string]string{}))
+	require.Equal(t, thinEvent, rcvBody)
+}
+
+func TestNewEndpointClientExpandThinEventsWithoutAPIClient(t *testing.T) {
+	_, err := NewEndpointClient("http://localhost", false, []string{"*"}, &EndpointConfig{
+		ExpandThinEvents: true,
+	})
+	require.NotNil(t, err)
+}
@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"fmt"
+	"time"
+)
+
+//
+// Public types
+//
+
+// EventFilter identifies one of the filters deciding whether an
+// EndpointClient forwards an event.
+type EventFilter string
+
+// Filters that can reject an event, in the order in which they are applied.
+// Events are also sampled by SampleRate, after FilterLivemode, which is
+// random and reported by Explain as a probability.
+const (
+	// FilterConnect rejects Connect events for clients that don't forward
+	// them, and other events for clients that only forward Connect events
+	FilterConnect EventFilter = "connect"
+
+	// FilterExcluded rejects the types of ExcludedEvents
+	FilterExcluded EventFilter = "excluded"
+
+	// FilterEvents rejects the types that aren't in the list of events of
+	// the client
+	FilterEvents EventFilter = "events"
+
+	// FilterLivemode rejects events whose mode isn't allowed by
+	// AllowLivemode and AllowTestmode
+	FilterLivemode EventFilter = "livemode"
+
+	// FilterMaxAge rejects events older than MaxEventAge
+	FilterMaxAge EventFilter = "max_age"
+)
+
+// ExplainedEvent describes the event whose forwarding Explain explains.
+type ExplainedEvent struct {
+	Connect  bool
+	Livemode bool
+
+	// Created is when the event was created. The zero value skips the
+	// MaxEventAge filter.
+	Created time.Time
+}
+
+// Explanation is the decision of an EndpointClient about forwarding an
+// event, as returned by Explain.
+type Explanation struct {
+	// Forwarded is set when the event passes all the filters. If
+	// SampleRate is lower than 1, it may still be sampled out.
+	Forwarded bool
+
+	// Filter is the filter that rejected the event, if any, and Reason
+	// explains why
+	Filter EventFilter
+	Reason string
+
+	// SampleRate is the probability that the event is forwarded when it
+	// passes the other filters, 1 when sampling is disabled
+	SampleRate float64
+}
+
+// Explain returns whether the client forwards events of the type with the
+// given attributes, and if not, which filter rejects them. It goes through
+// the same filters as Post, except for the sampling, which is random and
+// only reported as the SampleRate of the explanation. It doesn't log
+// anything, even with LogSkipped.
+func (c *EndpointClient) Explain(eventType string, evt ExplainedEvent) Explanation {
+	explanation := Explanation{SampleRate: 1}
+	if c.sampler != nil {
+		explanation.SampleRate = c.sampler.rate
+	}
+
+	if filter, reason := c.skipReason(evt.Connect, eventType); filter != "" {
+		explanation.Filter = filter
+		explanation.Reason = reason
+		return explanation
+	}
+
+	if !c.SupportsLivemode(evt.Livemode) {
+		explanation.Filter = FilterLivemode
+		explanation.Reason = "test mode events aren't forwarded"
+		if evt.Livemode {
+			explanation.Reason = "live mode events aren't forwarded"
+		}
+		return explanation
+	}
+
+	if !evt.Created.IsZero() && c.isStale(evt.Created) {
+		explanation.Filter = FilterMaxAge
+		explanation.Reason = fmt.Sprintf("older than %v", c.cfg.MaxEventAge)
+		return explanation
+	}
+
+	explanation.Forwarded = true
+
+	return explanation
+}
+
+// Range calls fn for each endpoint of the client, in order, with its URL,
+// whether it forwards events of the type and why, until fn returns false.
+// It applies the same filters as SupportsEventType, i.e. those of Explain
+// that only depend on the type of the event. It doesn't allocate, so that it
+// can be called for every incoming event, e.g. to display a routing table.
+func (c *MultiEndpointClient) Range(connect bool, eventType string, fn func(url string, accepted bool, reason string) bool) {
+	for _, client := range c.clients {
+		filter, reason := client.skipReason(connect, eventType)
+		if filter == "" {
+			reason = acceptedReason
+		}
+		if !fn(client.URL, filter == "", reason) {
+			return
+		}
+	}
+}
+
+//
+// Private constants
+//
+
+// acceptedReason is the reason given by MultiEndpointClient.Range for the
+// events that an endpoint forwards
+const acceptedReason = "in the list of events"
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplain(t *testing.T) {
+	client, err := NewEndpointClient("http://localhost", false, []string{"charge.*", "customer.created"}, &EndpointConfig{
+		ExcludedEvents: []string{"charge.refunded"},
+		AllowTestmode:  true,
+		MaxEventAge:    time.Hour,
+	})
+	require.Nil(t, err)
+
+	require.Equal(t, Explanation{Forwarded: true, SampleRate: 1}, client.Explain("charge.succeeded", ExplainedEvent{}))
+	require.Equal(t, Explanation{Forwarded: true, SampleRate: 1}, client.Explain("customer.created", ExplainedEvent{Created: time.Now()}))
+
+	explanation := client.Explain("charge.succeeded", ExplainedEvent{Connect: true})
+	require.False(t, explanation.Forwarded)
+	require.Equal(t, FilterConnect, explanation.Filter)
+	require.Equal(t, "Connect events aren't forwarded", explanation.Reason)
+
+	explanation = client.Explain("charge.refunded", ExplainedEvent{})
+	require.False(t, explanation.Forwarded)
+	require.Equal(t, FilterExcluded, explanation.Filter)
+
+	explanation = client.Explain("invoice.paid", ExplainedEvent{})
+	require.False(t, explanation.Forwarded)
+	require.Equal(t, FilterEvents, explanation.Filter)
+
+	explanation = client.Explain("charge.succeeded", ExplainedEvent{Livemode: true})
+	require.False(t, explanation.Forwarded)
+	require.Equal(t, FilterLivemode, explanation.Filter)
+	require.Equal(t, "live mode events aren't forwarded", explanation.Reason)
+
+	explanation = client.Explain("charge.succeeded", ExplainedEvent{Created: time.Now().Add(-2 * time.Hour)})
+	require.False(t, explanation.Forwarded)
+	require.Equal(t, FilterMaxAge, explanation.Filter)
+	require.Equal(t, "older than 1h0m0s", explanation.Reason)
+}
+
+func TestExplainSampleRate(t *testing.T) {
+	client, err := NewEndpointClient("http://localhost", true, []string{"*"}, &EndpointConfig{
+		SampleRate: 0.25,
+	})
+	require.Nil(t, err)
+
+	require.Equal(t, Explanation{Forwarded: true, SampleRate: 0.25}, client.Explain("charge.succeeded", ExplainedEvent{Connect: true}))
+
+	explanation := client.Explain("charge.succeeded", ExplainedEvent{})
+	require.Equal(t, FilterConnect, explanation.Filter)
+	require.Equal(t, "only Connect events are forwarded", explanation.Reason)
+	require.Equal(t, 0.25, explanation.SampleRate)
+}
+
+func TestMultiEndpointClientRange(t *testing.T) {
+	charges, err := NewEndpointClient("http://localhost:4242", false, []string{"charge.*"}, &EndpointConfig{
+		ExcludedEvents: []string{"charge.refunded"},
+	})
+	require.Nil(t, err)
+	all, err := NewEndpointClient("http://localhost:4243", false, []string{"*"}, nil)
+	require.Nil(t, err)
+	connect, err := NewEndpointClient("http://localhost:4244", true, []string{"*"}, nil)
+	require.Nil(t, err)
+	client := &MultiEndpointClient{clients: []*EndpointClient{charges, all, connect}}
+
+	type route struct {
+		url      string
+		accepted bool
+		reason   string
+	}
+	var routes []route
+	client.Range(false, "charge.refunded", func(url string, accepted bool, reason string) bool {
+		routes = append(routes, route{url, accepted, reason})
+		return true
+	})
+	require.Equal(t, []route{
+		{"http://localhost:4242", false, "excluded"},
+		{"http://localhost:4243", true, "in the list of events"},
+		{"http://localhost:4244", false, "only Connect events are forwarded"},
+	}, routes)
+
+	routes = nil
+	client.Range(false, "customer.created", func(url string, accepted bool, reason string) bool {
+		routes = append(routes, route{url, accepted, reason})
+		return false
+	})
+	require.Equal(t, []route{{"http://localhost:4242", false, "not in the list of events"}}, routes)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		client.Range(false, "charge.succeeded", func(string, bool, string) bool { return true })
+	})
+	require.Equal(t, float64(0), allocs)
+}
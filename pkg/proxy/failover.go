@@ -0,0 +1,175 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//
+// Public types
+//
+
+// FailoverClient forwards each event to a primary local endpoint, and to a
+// backup endpoint only if the primary couldn't take the event, i.e. if it
+// couldn't be reached or its last response still asked for a retry, see
+// failsOver. The response handler of its configuration is invoked once per
+// event, with the response of the endpoint that ultimately handled the
+// event, which is available through the response's Request.URL.
+type FailoverClient struct {
+	primary *EndpointClient
+	backup  *EndpointClient
+
+	// sampler samples the events once for both endpoints, so that the
+	// backup doesn't sample out an event that the primary kept
+	sampler *sampler
+
+	log *log.Logger
+}
+
+// SupportsEventType takes an event of a webhook and compares it to the internal
+// list of supported events
+func (c *FailoverClient) SupportsEventType(connect bool, eventType string) bool {
+	return c.primary.SupportsEventType(connect, eventType)
+}
+
+// Post sends a message to the primary endpoint, or to the backup endpoint if
+// the primary fails.
+func (c *FailoverClient) Post(webhookID string, body string, headers map[string]string) error {
+	return c.PostWithContext(context.Background(), webhookID, body, headers)
+}
+
+// PostWithContext sends a message to the primary endpoint, or to the backup
+// endpoint if the primary fails. It returns the error of the backup endpoint
+// if both fail.
+func (c *FailoverClient) PostWithContext(ctx context.Context, webhookID string, body string, headers map[string]string) error {
+	if !c.sampler.keep() {
+		c.primary.logSkipped(log.Fields{
+			"prefix":     "proxy.FailoverClient.Post",
+			"webhook_id": webhookID,
+			"reason":     "sampled out",
+		}, "Event was sampled out")
+		return nil
+	}
+
+	err := c.primary.PostWithContext(ctx, webhookID, body, headers)
+	if err == nil || ctx.Err() != nil || !isFailoverError(err) {
+		return err
+	}
+
+	c.log.WithFields(log.Fields{
+		"prefix":     "proxy.FailoverClient.Post",
+		"webhook_id": webhookID,
+		"primary":    c.primary.URL,
+		"backup":     c.backup.URL,
+	}).Warnf("Primary endpoint failed, failing over to the backup endpoint, error = %v", err)
+
+	return c.backup.PostWithContext(ctx, webhookID, body, headers)
+}
+
+//
+// Public functions
+//
+
+// NewFailoverClient returns a new FailoverClient forwarding to the primary
+// URL and failing over to the backup URL. Each endpoint gets its own copy
+// of the configuration, and its own DedupFile, see endpointFilePath. Events
+// are sampled by SampleRate once, before they are sent to either endpoint.
+// Only the backup endpoint writes the events it couldn't deliver to the
+// DeadLetterFile. The RecordSink receives the records of both endpoints,
+// which are told apart by their URL.
+func NewFailoverClient(primaryURL string, backupURL string, connect bool, events []string, cfg *EndpointConfig) (*FailoverClient, error) {
+	if cfg == nil {
+		cfg = &EndpointConfig{}
+	}
+	if cfg.SampleRate < 0 || cfg.SampleRate > 1 {
+		return nil, errors.New("the sample rate must be between 0 and 1")
+	}
+
+	primaryCfg := *cfg
+	primaryCfg.DedupFile = endpointFilePath(cfg.DedupFile, primaryURL)
+	primaryCfg.DeadLetterFile = ""
+	primaryCfg.SampleRate = 0
+	primary, err := NewEndpointClient(primaryURL, connect, events, &primaryCfg)
+	if err != nil {
+		return nil, err
+	}
+	primary.failsOver = true
+
+	backupCfg := *cfg
+	backupCfg.DedupFile = endpointFilePath(cfg.DedupFile, backupURL)
+	backupCfg.SampleRate = 0
+	backup, err := NewEndpointClient(backupURL, connect, events, &backupCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FailoverClient{
+		primary: primary,
+		backup:  backup,
+		sampler: newSampler(cfg.SampleRate, cfg.SampleSeed),
+		log:     primaryCfg.Log,
+	}, nil
+}
+
+//
+// Private types
+//
+
+// failoverError is returned by the primary client of a FailoverClient when
+// the last response of the endpoint fails over to the backup endpoint.
+type failoverError struct {
+	status *HTTPStatusError
+}
+
+func (e *failoverError) Error() string {
+	return e.status.Error()
+}
+
+func (e *failoverError) Unwrap() error {
+	return e.status
+}
+
+//
+// Private functions
+//
+
+// failOver returns whether the last response of the endpoint is left to the
+// backup endpoint of a FailoverClient rather than handled. It is the case
+// when the response isn't a success and ClassifyResponse classified it as
+// OutcomeRetry, or left it to the default classification and its status
+// code is 5xx. A response classified as OutcomeFailure is handled, since
+// the backup would reject the event as well.
+func (c *EndpointClient) failOver(d *delivery, resp *http.Response) bool {
+	if !c.failsOver || resp == nil || c.succeeded(d, resp) {
+		return false
+	}
+	if d.outcome != OutcomeDefault {
+		return d.outcome == OutcomeRetry
+	}
+
+	return resp.StatusCode >= http.StatusInternalServerError
+}
+
+// isFailoverError returns whether the error denotes that the endpoint
+// couldn't take the event, as opposed to the event being rejected before it
+// was sent, e.g. because of its signature.
+func isFailoverError(err error) bool {
+	var urlErr *url.Error
+	var failoverErr *failoverError
+
+	switch {
+	case errors.Is(err, ErrNotAcknowledged), errors.Is(err, ErrCircuitOpen):
+		return true
+	case errors.Is(err, ErrEndpointUnreachable), errors.Is(err, ErrEndpointTimeout):
+		return true
+	case errors.As(err, &failoverErr):
+		return true
+	default:
+		// Transport errors of an http.Client are *url.Error
+		return errors.As(err, &urlErr)
+	}
+}
@@ -0,0 +1,216 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailoverClientPrimary(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer primary.Close()
+
+	var backupCount int32
+	backup := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&backupCount, 1)
+	}))
+	defer backup.Close()
+
+	var handledBy []string
+	client, err := NewFailoverClient(primary.URL, backup.URL, false, []string{"*"}, &EndpointConfig{
+		ResponseHandler: EndpointResponseHandlerFunc(func(webhookID string, resp *http.Response) {
+			handledBy = append(handledBy, resp.Request.URL.String())
+		}),
+	})
+	require.Nil(t, err)
+
+	require.Nil(t, client.Post("wh_123", "{}", map[string]string{}))
+	require.Equal(t, []string{primary.URL}, handledBy)
+	require.Equal(t, int32(0), atomic.LoadInt32(&backupCount))
+}
+
+func TestFailoverClientServerError(t *testing.T) {
+	var primaryCount int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&primaryCount, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer primary.Close()
+
+	backup := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backup.Close()
+
+	var handled []*EndpointResponse
+	client, err := NewFailoverClient(primary.URL, backup.URL, false, []string{"*"}, &EndpointConfig{
+		RetryPolicy: RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond},
+		ResponseHandler: EndpointResponseHandlerV2Func(func(resp *EndpointResponse) {
+			handled = append(handled, resp)
+		}),
+	})
+	require.Nil(t, err)
+
+	require.Nil(t, client.Post("wh_123", `{"id":"evt_123"}`, map[string]string{}))
+
+	// The primary was retried before failing over, and only the response of
+	// the backup was handled
+	require.Equal(t, int32(2), atomic.LoadInt32(&primaryCount))
+	require.Len(t, handled, 1)
+	require.Equal(t, "evt_123", handled[0].EventID)
+	require.Equal(t, backup.URL, handled[0].Response.Request.URL.String())
+}
+
+func TestFailoverClientUnreachable(t *testing.T) {
+	backup := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backup.Close()
+
+	var handledBy string
+	client, err := NewFailoverClient("http://localhost:0", backup.URL, false, []string{"*"}, &EndpointConfig{
+		ResponseHandler: EndpointResponseHandlerFunc(func(webhookID string, resp *http.Response) {
+			handledBy = resp.Request.URL.String()
+		}),
+	})
+	require.Nil(t, err)
+
+	require.Nil(t, client.Post("wh_123", "{}", map[string]string{}))
+	require.Equal(t, backup.URL, handledBy)
+
+	// Both endpoints failing returns the error of the backup
+	backup.Close()
+	require.NotNil(t, client.Post("wh_123", "{}", map[string]string{}))
+}
+
+func TestFailoverClientClientError(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer primary.Close()
+
+	var backupCount int32
+	backup := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&backupCount, 1)
+	}))
+	defer backup.Close()
+
+	client, err := NewFailoverClient(primary.URL, backup.URL, false, []string{"*"}, nil)
+	require.Nil(t, err)
+
+	// 4xx responses are handled by the primary
+	require.Nil(t, client.Post("wh_123", "{}", map[string]string{}))
+	require.Equal(t, int32(0), atomic.LoadInt32(&backupCount))
+}
+
+func TestFailoverClientClassification(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/busy" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"ok":false}`)) // #nosec G104
+	}))
+	defer primary.Close()
+
+	var backupCount int32
+	backup := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&backupCount, 1)
+	}))
+	defer backup.Close()
+
+	// A 200 classified as a retry fails over
+	client, err := NewFailoverClient(primary.URL, backup.URL, false, []string{"*"}, &EndpointConfig{
+		ClassifyResponse: func(resp *http.Response, body []byte) Outcome {
+			if strings.Contains(string(body), `"ok":false`) {
+				return OutcomeRetry
+			}
+			return OutcomeDefault
+		},
+	})
+	require.Nil(t, err)
+	require.Nil(t, client.Post("wh_123", "{}", map[string]string{}))
+	require.Equal(t, int32(1), atomic.LoadInt32(&backupCount))
+
+	// A 503 that is one of the success status codes doesn't
+	client, err = NewFailoverClient(primary.URL+"/busy", backup.URL, false, []string{"*"}, &EndpointConfig{
+		SuccessStatusCodes: []int{http.StatusOK, http.StatusServiceUnavailable},
+	})
+	require.Nil(t, err)
+	require.Nil(t, client.Post("wh_123", "{}", map[string]string{}))
+	require.Equal(t, int32(1), atomic.LoadInt32(&backupCount))
+}
+
+func TestFailoverClientSampleRate(t *testing.T) {
+	var primaryCount int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&primaryCount, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer primary.Close()
+
+	var backupCount int32
+	backup := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&backupCount, 1)
+	}))
+	defer backup.Close()
+
+	client, err := NewFailoverClient(primary.URL, backup.URL, false, []string{"*"}, &EndpointConfig{
+		RetryPolicy: RetryPolicy{MaxAttempts: 1},
+		SampleRate:  0.5,
+		SampleSeed:  42,
+	})
+	require.Nil(t, err)
+
+	for i := 0; i < 20; i++ {
+		require.Nil(t, client.Post("wh_123", "{}", map[string]string{}))
+	}
+
+	// Every event kept for the primary fails over to the backup
+	require.True(t, atomic.LoadInt32(&primaryCount) > 0)
+	require.True(t, atomic.LoadInt32(&primaryCount) < 20)
+	require.Equal(t, atomic.LoadInt32(&primaryCount), atomic.LoadInt32(&backupCount))
+}
+
+func TestFailoverClientDedupFile(t *testing.T) {
+	var primaryCount int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&primaryCount, 1)
+	}))
+	defer primary.Close()
+
+	backup := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backup.Close()
+
+	dir, err := ioutil.TempDir("", "stripe-cli-proxy")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := &EndpointConfig{
+		DedupSize: 10,
+		DedupFile: filepath.Join(dir, "dedup.jsonl"),
+	}
+	client, err := NewFailoverClient(primary.URL, backup.URL, false, []string{"*"}, cfg)
+	require.Nil(t, err)
+	require.Nil(t, client.Post("wh_123", `{"id":"evt_123"}`, map[string]string{}))
+
+	// The backup doesn't overwrite the IDs persisted by the primary
+	client, err = NewFailoverClient(primary.URL, backup.URL, false, []string{"*"}, cfg)
+	require.Nil(t, err)
+	require.Nil(t, client.Post("wh_123", `{"id":"evt_123"}`, map[string]string{}))
+	require.Equal(t, int32(1), atomic.LoadInt32(&primaryCount))
+
+	files, err := filepath.Glob(filepath.Join(dir, "dedup.*.jsonl"))
+	require.Nil(t, err)
+	require.Len(t, files, 2)
+}
@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+)
+
+//
+// Private constants
+//
+
+const formContentType = "application/x-www-form-urlencoded"
+
+//
+// Private functions
+//
+
+// formEncode transcodes a JSON object into form-encoded key/values. Nested
+// objects and arrays are flattened with dotted keys, array elements being
+// keyed by their index, so that {"data":{"items":[{"id":"ii_1"}]}} becomes
+// data.items.0.id=ii_1. Strings are encoded as is, numbers as they appear in
+// the JSON, booleans as true or false, and nulls, empty objects and empty
+// arrays as an empty value. The keys are sorted.
+func formEncode(body []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var obj map[string]interface{}
+	if err := decoder.Decode(&obj); err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return nil, errors.New("the event isn't a JSON object")
+	}
+
+	values := url.Values{}
+	for k, v := range obj {
+		flattenFormValue(values, k, v)
+	}
+
+	return []byte(values.Encode()), nil
+}
+
+func flattenFormValue(values url.Values, key string, value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			values.Set(key, "")
+		}
+		for k, child := range v {
+			flattenFormValue(values, key+"."+k, child)
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			values.Set(key, "")
+		}
+		for i, child := range v {
+			flattenFormValue(values, key+"."+strconv.Itoa(i), child)
+		}
+	case string:
+		values.Set(key, v)
+	case json.Number:
+		values.Set(key, v.String())
+	case bool:
+		values.Set(key, strconv.FormatBool(v))
+	default:
+		values.Set(key, "")
+	}
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormEncode(t *testing.T) {
+	body := `{
+		"id": "evt_123",
+		"livemode": false,
+		"created": 1600000000,
+		"amount": 12.50,
+		"request": null,
+		"data": {
+			"object": {
+				"id": "in_123",
+				"metadata": {},
+				"discounts": [],
+				"lines": [
+					{"id": "il_1", "tags": ["a", "b"]},
+					{"id": "il_2", "period": {"start": 1, "end": 2}}
+				]
+			}
+		}
+	}`
+
+	encoded, err := formEncode([]byte(body))
+	require.Nil(t, err)
+
+	values, err := url.ParseQuery(string(encoded))
+	require.Nil(t, err)
+	require.Equal(t, url.Values{
+		"id":                               {"evt_123"},
+		"livemode":                         {"false"},
+		"created":                          {"1600000000"},
+		"amount":                           {"12.50"},
+		"request":                          {""},
+		"data.object.id":                   {"in_123"},
+		"data.object.metadata":             {""},
+		"data.object.discounts":            {""},
+		"data.object.lines.0.id":           {"il_1"},
+		"data.object.lines.0.tags.0":       {"a"},
+		"data.object.lines.0.tags.1":       {"b"},
+		"data.object.lines.1.id":           {"il_2"},
+		"data.object.lines.1.period.start": {"1"},
+		"data.object.lines.1.period.end":   {"2"},
+	}, values)
+
+	// The keys are sorted
+	encoded, err = formEncode([]byte(`{"b":"2","a":{"d":"4","c":"3 &"}}`))
+	require.Nil(t, err)
+	require.Equal(t, "a.c=3+%26&a.d=4&b=2", string(encoded))
+
+	_, err = formEncode([]byte(`["evt_123"]`))
+	require.NotNil(t, err)
+	_, err = formEncode([]byte(`null`))
+	require.NotNil(t, err)
+}
+
+func TestPostFormEncodeBody(t *testing.T) {
+	var contentType string
+	var rcvBody string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		buf, _ := ioutil.ReadAll(r.Body)
+		rcvBody = string(buf)
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		FormEncodeBody: true,
+	})
+	require.Nil(t, err)
+
+	err = client.Post("wh_123", `{"type":"charge.succeeded","data":{"object":{"id":"ch_123"}}}`, map[string]string{
+		"Content-Type": "application/json; charset=utf-8",
+	})
+	require.Nil(t, err)
+	require.Equal(t, formContentType, contentType)
+	require.Equal(t, "data.object.id=ch_123&type=charge.succeeded", rcvBody)
+
+	// Bodies that aren't JSON objects aren't sent
+	rcvBody = ""
+	require.NotNil(t, client.Post("wh_123", `not json`, map[string]string{}))
+	require.Equal(t, "", rcvBody)
+}
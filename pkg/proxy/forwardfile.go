@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//
+// Public types
+//
+
+// ForwardSummary counts the outcomes of the events forwarded by
+// ForwardFromFile.
+type ForwardSummary struct {
+	// Forwarded is the number of events that were delivered
+	Forwarded int
+
+	// Skipped is the number of events that weren't sent to the endpoint:
+	// those that the client isn't configured to forward, e.g. because of
+	// their type, mode or age, and those that were sampled out, deduped or
+	// buffered
+	Skipped int
+
+	// Failed is the number of events that couldn't be delivered or got a
+	// non-2xx response, including lines that aren't JSON objects
+	Failed int
+}
+
+//
+// Public functions
+//
+
+// ForwardFromFile posts the events of a file, one JSON event per line, e.g.
+// to reproduce a bug or to test a handler against recorded events. Events
+// are given the synthetic webhook IDs wh_file_1, wh_file_2, etc. in the
+// order of the file, and go through the same filters, retries and metrics
+// as events posted with Post. Events that fail to be delivered don't stop the
+// forwarding.
+func (c *EndpointClient) ForwardFromFile(ctx context.Context, path string) (ForwardSummary, error) {
+	var summary ForwardSummary
+
+	lines, err := readLines(path)
+	if err != nil {
+		return summary, err
+	}
+
+	for i, line := range lines {
+		if ctx.Err() != nil {
+			return summary, ctx.Err()
+		}
+
+		webhookID := fmt.Sprintf("wh_file_%d", i+1)
+		fields := log.Fields{
+			"prefix":     "proxy.EndpointClient.ForwardFromFile",
+			"webhook_id": webhookID,
+		}
+
+		var obj map[string]interface{}
+		if err := json.Unmarshal(line, &obj); err != nil || obj == nil {
+			c.cfg.Log.WithFields(fields).Warn("Skipping line that isn't a JSON event")
+			summary.Failed++
+			continue
+		}
+
+		evt := parseStripeEvent(string(line))
+		if !c.SupportsEventType(evt.isConnect(), evt.Type) {
+			summary.Skipped++
+			continue
+		}
+
+		result, err := c.post(ctx, webhookID, string(line), map[string]string{})
+		if err != nil {
+			c.cfg.Log.WithFields(fields).Debugf("Failed to forward event, error = %v", err)
+			summary.Failed++
+			continue
+		}
+		if !result.sent {
+			summary.Skipped++
+			continue
+		}
+		if !c.isSuccess(result.statusCode) {
+			summary.Failed++
+			continue
+		}
+		summary.Forwarded++
+	}
+
+	return summary, nil
+}
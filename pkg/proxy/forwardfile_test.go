@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestForwardFromFile(t *testing.T) {
+	var rcvBodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf, _ := ioutil.ReadAll(r.Body)
+		rcvBodies = append(rcvBodies, string(buf))
+		if r.URL.Query().Get("fail") != "" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "proxy")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "events.jsonl")
+	content := `{"id":"evt_1","type":"charge.succeeded"}
+{"id":"evt_2","type":"customer.created"}
+
+not json
+{"id":"evt_3","type":"charge.succeeded","account":"acct_123"}
+{"id":"evt_4","type":"charge.succeeded"}
+`
+	require.Nil(t, ioutil.WriteFile(path, []byte(content), 0600))
+
+	var webhookIDs []string
+	client, err := NewEndpointClient(ts.URL, false, []string{"charge.succeeded"}, &EndpointConfig{
+		ResponseHandler: EndpointResponseHandlerFunc(func(webhookID string, resp *http.Response) {
+			webhookIDs = append(webhookIDs, webhookID)
+		}),
+	})
+	require.Nil(t, err)
+
+	// Other event types and Connect events are skipped
+	summary, err := client.ForwardFromFile(context.Background(), path)
+	require.Nil(t, err)
+	require.Equal(t, ForwardSummary{Forwarded: 2, Skipped: 2, Failed: 1}, summary)
+	require.Equal(t, []string{`{"id":"evt_1","type":"charge.succeeded"}`, `{"id":"evt_4","type":"charge.succeeded"}`}, rcvBodies)
+	require.Equal(t, []string{"wh_file_1", "wh_file_5"}, webhookIDs)
+
+	failing, err := NewEndpointClient(ts.URL+"?fail=1", false, []string{"*"}, nil)
+	require.Nil(t, err)
+
+	summary, err = failing.ForwardFromFile(context.Background(), path)
+	require.Nil(t, err)
+	require.Equal(t, ForwardSummary{Skipped: 1, Failed: 4}, summary)
+
+	// Events that are filtered out by their mode aren't forwarded either
+	rcvBodies = nil
+	liveOnly, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		AllowLivemode: true,
+	})
+	require.Nil(t, err)
+
+	summary, err = liveOnly.ForwardFromFile(context.Background(), path)
+	require.Nil(t, err)
+	require.Equal(t, ForwardSummary{Skipped: 4, Failed: 1}, summary)
+	require.Empty(t, rcvBodies)
+
+	_, err = client.ForwardFromFile(context.Background(), filepath.Join(dir, "missing.jsonl"))
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"net/http"
+)
+
+//
+// Private functions
+//
+
+// newHeaderSet returns the set of the canonical forms of the header names, or
+// nil if there are none.
+func newHeaderSet(names []string) map[string]bool {
+	if len(names) == 0 {
+		return nil
+	}
+
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[http.CanonicalHeaderKey(name)] = true
+	}
+
+	return set
+}
+
+// forwardsHeader returns whether the header of an event passes the
+// HeaderAllowlist and HeaderDenylist.
+func (c *EndpointClient) forwardsHeader(name string) bool {
+	name = http.CanonicalHeaderKey(name)
+	if c.headerDenylist[name] {
+		return false
+	}
+
+	return c.headerAllowlist == nil || c.headerAllowlist[name]
+}
@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostHeaderAllowlist(t *testing.T) {
+	var header http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+	}))
+	defer ts.Close()
+
+	headers := map[string]string{
+		"Stripe-Signature": "t=1,v1=abc",
+		"Content-Type":     "application/json; charset=utf-8",
+		"X-Request-Id":     "req_123",
+		"Cache-Control":    "no-cache",
+	}
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		HeaderAllowlist: []string{"stripe-signature", "X-REQUEST-ID"},
+		HeaderDenylist:  []string{"x-request-id"},
+		StaticHeaders:   map[string]string{"X-Source": "static"},
+	})
+	require.Nil(t, err)
+
+	// The denylist wins, and the headers set by the client aren't filtered
+	require.Nil(t, client.Post("wh_123", `{"id":"evt_123"}`, headers))
+	require.Equal(t, "t=1,v1=abc", header.Get("Stripe-Signature"))
+	require.Equal(t, "", header.Get("X-Request-Id"))
+	require.Equal(t, "", header.Get("Cache-Control"))
+	require.Equal(t, "static", header.Get("X-Source"))
+	require.Equal(t, defaultContentType, header.Get("Content-Type"))
+}
+
+func TestPostHeaderDenylist(t *testing.T) {
+	var header http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		HeaderDenylist: []string{"Cache-Control"},
+	})
+	require.Nil(t, err)
+
+	require.Nil(t, client.Post("wh_123", `{"id":"evt_123"}`, map[string]string{
+		"Stripe-Signature": "t=1,v1=abc",
+		"cache-control":    "no-cache",
+	}))
+	require.Equal(t, "t=1,v1=abc", header.Get("Stripe-Signature"))
+	require.Equal(t, "", header.Get("Cache-Control"))
+}
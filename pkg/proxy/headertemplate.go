@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//
+// Private functions
+//
+
+// parseHeaderTemplates parses the templates of HeaderTemplates. Fields that
+// are missing from an event make the evaluation of a template fail.
+func parseHeaderTemplates(headerTemplates map[string]string) (map[string]*template.Template, error) {
+	if len(headerTemplates) == 0 {
+		return nil, nil
+	}
+
+	templates := make(map[string]*template.Template, len(headerTemplates))
+	for name, text := range headerTemplates {
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template for header %s: %w", name, err)
+		}
+		templates[name] = tmpl
+	}
+
+	return templates, nil
+}
+
+// renderHeaderTemplates evaluates the header templates against the event
+// payload. Headers whose template fails to evaluate are skipped.
+func (c *EndpointClient) renderHeaderTemplates(webhookID string, body string) map[string]string {
+	if len(c.headerTemplates) == 0 {
+		return nil
+	}
+
+	var evt map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &evt); err != nil {
+		c.cfg.Log.WithFields(log.Fields{
+			"prefix":     "proxy.EndpointClient.renderHeaderTemplates",
+			"webhook_id": webhookID,
+		}).Warnf("Not setting templated headers, event isn't valid JSON, error = %v", err)
+		return nil
+	}
+
+	headers := make(map[string]string, len(c.headerTemplates))
+	for name, tmpl := range c.headerTemplates {
+		var value strings.Builder
+		if err := tmpl.Execute(&value, evt); err != nil {
+			c.cfg.Log.WithFields(log.Fields{
+				"prefix":     "proxy.EndpointClient.renderHeaderTemplates",
+				"webhook_id": webhookID,
+				"header":     name,
+			}).Warnf("Skipping templated header, error = %v", err)
+			continue
+		}
+		headers[name] = value.String()
+	}
+
+	return headers
+}
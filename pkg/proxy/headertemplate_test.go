@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostHeaderTemplates(t *testing.T) {
+	var header http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		HeaderTemplates: map[string]string{
+			"X-Stripe-Event-Type": "{{.type}}",
+			"X-Stripe-Account":    "{{.account}}",
+			"X-Object-ID":         "{{.data.object.id}}",
+			"X-Source":            "template",
+		},
+		StaticHeaders: map[string]string{
+			"X-Source": "static",
+		},
+	})
+	require.Nil(t, err)
+
+	err = client.Post("wh_123", `{"id":"evt_123","type":"invoice.paid","data":{"object":{"id":"in_123"}}}`, map[string]string{})
+	require.Nil(t, err)
+	require.Equal(t, "invoice.paid", header.Get("X-Stripe-Event-Type"))
+	require.Equal(t, "in_123", header.Get("X-Object-ID"))
+	require.Equal(t, "template", header.Get("X-Source"))
+
+	// Headers of missing fields are skipped
+	_, ok := header["X-Stripe-Account"]
+	require.False(t, ok)
+
+	// Headers of the event take precedence
+	err = client.Post("wh_123", `{"type":"invoice.paid","account":"acct_123"}`, map[string]string{
+		"X-Stripe-Event-Type": "custom",
+	})
+	require.Nil(t, err)
+	require.Equal(t, "custom", header.Get("X-Stripe-Event-Type"))
+	require.Equal(t, "acct_123", header.Get("X-Stripe-Account"))
+
+	// Malformed events are forwarded without templated headers
+	err = client.Post("wh_123", "not json", map[string]string{})
+	require.Nil(t, err)
+	require.Equal(t, "", header.Get("X-Stripe-Event-Type"))
+	require.Equal(t, "static", header.Get("X-Source"))
+}
+
+func TestNewEndpointClientInvalidHeaderTemplate(t *testing.T) {
+	_, err := NewEndpointClient("http://localhost", false, []string{"*"}, &EndpointConfig{
+		HeaderTemplates: map[string]string{"X-Stripe-Event-Type": "{{.type"},
+	})
+	require.NotNil(t, err)
+}
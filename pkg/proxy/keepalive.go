@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//
+// Private functions
+//
+
+// keepAlive sends a probe request to every target each time the client has
+// been idle for KeepaliveInterval, until the client is closed.
+func (c *EndpointClient) keepAlive() {
+	defer c.background.Done()
+
+	for {
+		wait := c.cfg.KeepaliveInterval - c.clock.Now().Sub(c.lastActive())
+		if wait <= 0 {
+			c.sendKeepalives()
+			wait = c.cfg.KeepaliveInterval
+		}
+
+		select {
+		case <-c.clock.After(wait):
+		case <-c.backgroundCtx.Done():
+			return
+		}
+	}
+}
+
+// sendKeepalives probes every target as Ping does, without checking the
+// status codes of the responses.
+func (c *EndpointClient) sendKeepalives() {
+	for _, t := range c.targets.targets {
+		ctx, cancel := context.WithTimeout(c.backgroundCtx, defaultProbeTimeout)
+		statusCode, err := c.sendProbe(ctx, t)
+		cancel()
+
+		fields := log.Fields{
+			"prefix": "proxy.EndpointClient.keepAlive",
+			"url":    t.url,
+		}
+		if err != nil {
+			fields["error"] = err
+			c.cfg.Log.WithFields(fields).Debug("Keepalive probe to local endpoint failed")
+			continue
+		}
+		fields["status"] = statusCode
+		c.cfg.Log.WithFields(fields).Debug("Sent keepalive probe to local endpoint")
+	}
+}
+
+// markActive records that a request was just sent to the endpoint, which
+// postpones the next keepalive probes.
+func (c *EndpointClient) markActive() {
+	c.activityMu.Lock()
+	c.lastActivity = c.clock.Now()
+	c.activityMu.Unlock()
+}
+
+func (c *EndpointClient) lastActive() time.Time {
+	c.activityMu.Lock()
+	defer c.activityMu.Unlock()
+
+	return c.lastActivity
+}
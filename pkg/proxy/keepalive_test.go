@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/stripe-cli/pkg/proxy/proxytest"
+)
+
+func TestKeepalive(t *testing.T) {
+	endpoint := proxytest.NewRecordingEndpoint()
+	defer endpoint.Close()
+
+	clock := proxytest.NewFakeClock(time.Date(2019, 9, 1, 12, 0, 0, 0, time.UTC))
+	client, err := NewEndpointClient(endpoint.URL, false, []string{"*"}, &EndpointConfig{
+		Clock:             clock,
+		KeepaliveInterval: time.Minute,
+	})
+	require.Nil(t, err)
+
+	// A probe is sent once the client has been idle for the interval
+	require.True(t, clock.WaitForWaiters(1, time.Second))
+	clock.Advance(time.Minute)
+	require.True(t, endpoint.WaitForRequests(1, time.Second))
+	require.Equal(t, http.MethodHead, endpoint.Last().Method)
+
+	// Forwarding an event postpones the next probe
+	require.True(t, clock.WaitForWaiters(1, time.Second))
+	clock.Advance(30 * time.Second)
+	require.Nil(t, client.Post("wh_123", "{}", map[string]string{}))
+	require.True(t, clock.WaitForWaiters(1, time.Second))
+	clock.Advance(30 * time.Second)
+	require.True(t, clock.WaitForWaiters(1, time.Second))
+	require.Equal(t, 2, endpoint.Count())
+
+	clock.Advance(30 * time.Second)
+	require.True(t, endpoint.WaitForRequests(3, time.Second))
+	require.Equal(t, http.MethodHead, endpoint.Last().Method)
+
+	// The probes stop once the client is closed
+	require.Equal(t, 0, client.Close(context.Background()))
+	clock.Advance(time.Minute)
+	require.Equal(t, 3, endpoint.Count())
+}
+
+func TestKeepaliveDisabled(t *testing.T) {
+	endpoint := proxytest.NewRecordingEndpoint()
+	defer endpoint.Close()
+
+	clock := proxytest.NewFakeClock(time.Date(2019, 9, 1, 12, 0, 0, 0, time.UTC))
+	_, err := NewEndpointClient(endpoint.URL, false, []string{"*"}, &EndpointConfig{
+		Clock: clock,
+	})
+	require.Nil(t, err)
+
+	require.False(t, clock.WaitForWaiters(1, 50*time.Millisecond))
+	require.Equal(t, 0, endpoint.Count())
+}
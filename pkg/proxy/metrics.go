@@ -0,0 +1,294 @@
+package proxy
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+//
+// Public types
+//
+
+// EndpointMetrics is a snapshot of the delivery metrics of an EndpointClient.
+// Every attempt is counted, including retries.
+type EndpointMetrics struct {
+	// Attempted is the number of requests sent to the endpoint
+	Attempted int64
+
+	// Succeeded is the number of requests that got a response with one of
+	// the success status codes, 2xx by default, or classified as a success
+	// by ClassifyResponse
+	Succeeded int64
+
+	// Failed is the number of requests that got any other response or failed
+	// with a transport error
+	Failed int64
+
+	// TotalLatency is the sum of the durations of all attempts
+	TotalLatency time.Duration
+
+	// MinLatency is the duration of the fastest attempt
+	MinLatency time.Duration
+
+	// MaxLatency is the duration of the slowest attempt
+	MaxLatency time.Duration
+
+	// LatencyBuckets is the histogram of the durations of the attempts, from
+	// 5ms to 10s like the default buckets of Prometheus. Every bucket counts
+	// the attempts that took longer than the UpperBound of the previous
+	// bucket and at most its own. The last bucket counts the attempts slower
+	// than 10s, and its UpperBound is zero.
+	LatencyBuckets []LatencyBucket
+}
+
+// MeanLatency returns the average duration of an attempt.
+func (m EndpointMetrics) MeanLatency() time.Duration {
+	if m.Attempted == 0 {
+		return 0
+	}
+
+	return m.TotalLatency / time.Duration(m.Attempted)
+}
+
+// LatencyPercentile returns an upper bound of the duration within which the
+// given percentage of the attempts completed, e.g. 99 for the p99, from the
+// upper bound of the bucket holding that attempt. It returns MaxLatency for
+// the attempts of the last bucket, and zero if there were no attempts.
+func (m EndpointMetrics) LatencyPercentile(p float64) time.Duration {
+	var total int64
+	for _, bucket := range m.LatencyBuckets {
+		total += bucket.Count
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := int64(math.Ceil(p / 100 * float64(total)))
+	if rank < 1 {
+		rank = 1
+	}
+
+	var count int64
+	for _, bucket := range m.LatencyBuckets {
+		count += bucket.Count
+		if count >= rank {
+			if bucket.UpperBound == 0 || bucket.UpperBound > m.MaxLatency {
+				return m.MaxLatency
+			}
+			return bucket.UpperBound
+		}
+	}
+
+	return m.MaxLatency
+}
+
+// LatencyBucket is a bucket of EndpointMetrics.LatencyBuckets.
+type LatencyBucket struct {
+	UpperBound time.Duration
+	Count      int64
+}
+
+// MetricsSink receives a report after every attempt made by an
+// EndpointClient. The status code is zero when the attempt failed with a
+// transport error. Implementations must be safe for concurrent use.
+type MetricsSink interface {
+	RecordAttempt(url string, eventType string, statusCode int, duration time.Duration)
+}
+
+// DeliveryRecord is a machine readable record of an attempt at forwarding an
+// event to an endpoint.
+type DeliveryRecord struct {
+	// Timestamp is when the attempt started
+	Timestamp time.Time
+
+	WebhookID string
+	EventID   string
+	URL       string
+	EventType string
+
+	// StatusCode is the status code of the response, or zero if the attempt
+	// failed with a transport error
+	StatusCode int
+
+	Duration time.Duration
+
+	// Retries is the number of attempts that preceded this one
+	Retries int
+
+	// Error is the transport error of the attempt, if any
+	Error string
+}
+
+// RecordSink receives a DeliveryRecord after every attempt made by an
+// EndpointClient, including attempts that failed with a transport error.
+// Implementations must be safe for concurrent use.
+type RecordSink interface {
+	RecordDelivery(record DeliveryRecord)
+}
+
+// RecordedResponse is the last response of an endpoint, as returned by
+// EndpointClient.LastResponse.
+type RecordedResponse struct {
+	StatusCode int
+
+	// Body is the beginning of the response body, up to 4KB
+	Body []byte
+
+	// Timestamp is when the response was received
+	Timestamp time.Time
+}
+
+//
+// Private constants
+//
+
+// maxRecordedResponseBody bounds the size of the body of a RecordedResponse
+const maxRecordedResponseBody = 4 * 1024
+
+//
+// Private types
+//
+
+// endpointMetrics accumulates metrics safely across concurrent attempts.
+type endpointMetrics struct {
+	mu       sync.Mutex
+	snapshot EndpointMetrics
+
+	// buckets are the counts of the latency buckets
+	buckets [len(latencyBucketBounds) + 1]int64
+
+	last *RecordedResponse
+}
+
+func (m *endpointMetrics) record(success bool, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.snapshot.Attempted++
+	if success {
+		m.snapshot.Succeeded++
+	} else {
+		m.snapshot.Failed++
+	}
+
+	m.snapshot.TotalLatency += duration
+	if m.snapshot.Attempted == 1 || duration < m.snapshot.MinLatency {
+		m.snapshot.MinLatency = duration
+	}
+	if duration > m.snapshot.MaxLatency {
+		m.snapshot.MaxLatency = duration
+	}
+
+	i := sort.Search(len(latencyBucketBounds), func(i int) bool {
+		return duration <= latencyBucketBounds[i]
+	})
+	m.buckets[i]++
+}
+
+func (m *endpointMetrics) recordResponse(statusCode int, body []byte, now time.Time) {
+	if len(body) > maxRecordedResponseBody {
+		body = body[:maxRecordedResponseBody]
+	}
+
+	last := &RecordedResponse{
+		StatusCode: statusCode,
+		Body:       append([]byte(nil), body...),
+		Timestamp:  now,
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.last = last
+}
+
+func (m *endpointMetrics) lastResponse() (RecordedResponse, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.last == nil {
+		return RecordedResponse{}, false
+	}
+
+	last := *m.last
+	last.Body = append([]byte(nil), m.last.Body...)
+
+	return last, true
+}
+
+func (m *endpointMetrics) get() EndpointMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := m.snapshot
+	snapshot.LatencyBuckets = make([]LatencyBucket, len(m.buckets))
+	for i, count := range m.buckets {
+		snapshot.LatencyBuckets[i].Count = count
+		if i < len(latencyBucketBounds) {
+			snapshot.LatencyBuckets[i].UpperBound = latencyBucketBounds[i]
+		}
+	}
+
+	return snapshot
+}
+
+//
+// Private variables
+//
+
+// latencyBucketBounds are the upper bounds of the latency buckets, but for
+// the last bucket that has none
+var latencyBucketBounds = [...]time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+//
+// Private functions
+//
+
+func isSuccessStatusCode(statusCode int) bool {
+	return statusCode >= http.StatusOK && statusCode < http.StatusMultipleChoices
+}
+
+// isSuccess returns whether the status code is one of the success status
+// codes of the client. It is false for transport errors, whose status code
+// is zero.
+func (c *EndpointClient) isSuccess(statusCode int) bool {
+	if c.successStatusCodes == nil {
+		return isSuccessStatusCode(statusCode)
+	}
+
+	return c.successStatusCodes[statusCode]
+}
+
+// newStatusCodeSet returns the set of the status codes, or nil if there are
+// none.
+func newStatusCodeSet(statusCodes []int) (map[int]bool, error) {
+	if len(statusCodes) == 0 {
+		return nil, nil
+	}
+
+	set := make(map[int]bool, len(statusCodes))
+	for _, statusCode := range statusCodes {
+		if statusCode < 100 || statusCode > 599 {
+			return nil, fmt.Errorf("invalid success status code %d", statusCode)
+		}
+		set[statusCode] = true
+	}
+
+	return set, nil
+}
@@ -0,0 +1,223 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/stripe-cli/pkg/proxy/proxytest"
+)
+
+type attemptRecord struct {
+	url        string
+	eventType  string
+	statusCode int
+}
+
+type testMetricsSink struct {
+	mu       sync.Mutex
+	attempts []attemptRecord
+}
+
+func (s *testMetricsSink) RecordAttempt(url string, eventType string, statusCode int, duration time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempts = append(s.attempts, attemptRecord{url, eventType, statusCode})
+}
+
+func TestEndpointClientMetrics(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fail") != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	sink := &testMetricsSink{}
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		MetricsSink: sink,
+	})
+	require.Nil(t, err)
+	failing, err := NewEndpointClient(ts.URL+"?fail=1", false, []string{"*"}, &EndpointConfig{
+		MetricsSink: sink,
+	})
+	require.Nil(t, err)
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client.Post("wh_123", `{"type": "charge.created"}`, map[string]string{})
+		}()
+	}
+	wg.Wait()
+	failing.Post("wh_123", `{"type": "charge.created"}`, map[string]string{})
+
+	metrics := client.Metrics()
+	require.Equal(t, int64(10), metrics.Attempted)
+	require.Equal(t, int64(10), metrics.Succeeded)
+	require.Equal(t, int64(0), metrics.Failed)
+	require.True(t, metrics.MinLatency <= metrics.MeanLatency())
+	require.True(t, metrics.MeanLatency() <= metrics.MaxLatency)
+	var bucketed int64
+	for _, bucket := range metrics.LatencyBuckets {
+		bucketed += bucket.Count
+	}
+	require.Equal(t, int64(10), bucketed)
+
+	metrics = failing.Metrics()
+	require.Equal(t, int64(1), metrics.Attempted)
+	require.Equal(t, int64(1), metrics.Failed)
+
+	require.Len(t, sink.attempts, 11)
+	require.Equal(t, attemptRecord{ts.URL + "?fail=1", "charge.created", http.StatusBadRequest}, sink.attempts[10])
+}
+
+func TestEndpointMetricsLatencyBuckets(t *testing.T) {
+	var m endpointMetrics
+	require.Equal(t, time.Duration(0), m.get().LatencyPercentile(50))
+
+	for _, d := range []time.Duration{
+		time.Millisecond,
+		5 * time.Millisecond,
+		7 * time.Millisecond,
+		30 * time.Millisecond,
+		time.Minute,
+	} {
+		m.record(true, d)
+	}
+
+	metrics := m.get()
+	require.Len(t, metrics.LatencyBuckets, 12)
+	require.Equal(t, LatencyBucket{UpperBound: 5 * time.Millisecond, Count: 2}, metrics.LatencyBuckets[0])
+	require.Equal(t, LatencyBucket{UpperBound: 10 * time.Millisecond, Count: 1}, metrics.LatencyBuckets[1])
+	require.Equal(t, LatencyBucket{UpperBound: 50 * time.Millisecond, Count: 1}, metrics.LatencyBuckets[3])
+	require.Equal(t, LatencyBucket{Count: 1}, metrics.LatencyBuckets[11])
+
+	require.Equal(t, 5*time.Millisecond, metrics.LatencyPercentile(0))
+	require.Equal(t, 5*time.Millisecond, metrics.LatencyPercentile(40))
+	require.Equal(t, 10*time.Millisecond, metrics.LatencyPercentile(50))
+	require.Equal(t, 50*time.Millisecond, metrics.LatencyPercentile(80))
+	require.Equal(t, time.Minute, metrics.LatencyPercentile(99))
+
+	// Snapshots don't share their buckets
+	metrics.LatencyBuckets[0].Count = 100
+	require.Equal(t, int64(2), m.get().LatencyBuckets[0].Count)
+}
+
+type testRecordSink struct {
+	mu      sync.Mutex
+	records []DeliveryRecord
+}
+
+func (s *testRecordSink) RecordDelivery(record DeliveryRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, record)
+}
+
+func TestEndpointClientRecordSink(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	sink := &testRecordSink{}
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		RecordSink:  sink,
+		RetryPolicy: RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond},
+	})
+	require.Nil(t, err)
+
+	before := time.Now()
+	client.Post("wh_123", `{"type": "charge.created"}`, map[string]string{})
+
+	require.Len(t, sink.records, 2)
+	for i, record := range sink.records {
+		require.Equal(t, "wh_123", record.WebhookID)
+		require.Equal(t, ts.URL, record.URL)
+		require.Equal(t, "charge.created", record.EventType)
+		require.Equal(t, http.StatusServiceUnavailable, record.StatusCode)
+		require.Equal(t, i, record.Retries)
+		require.Empty(t, record.Error)
+		require.False(t, record.Timestamp.Before(before))
+	}
+
+	// Transport failures are recorded too
+	ts.Close()
+	sink.records = nil
+	client.Post("wh_123", `{"type": "charge.created"}`, map[string]string{})
+
+	require.Len(t, sink.records, 2)
+	require.Equal(t, 0, sink.records[0].StatusCode)
+	require.NotEmpty(t, sink.records[0].Error)
+}
+
+func TestPostSlowThreshold(t *testing.T) {
+	clock := proxytest.NewFakeClock(time.Date(2019, 9, 1, 12, 0, 0, 0, time.UTC))
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("slow") != "" {
+			clock.Advance(20 * time.Millisecond)
+		}
+	}))
+	defer ts.Close()
+
+	var out bytes.Buffer
+	logger := log.New()
+	logger.SetOutput(&out)
+
+	var slow []string
+	cfg := &EndpointConfig{
+		Log:           logger,
+		SlowThreshold: 10 * time.Millisecond,
+		Clock:         clock,
+		OnSlowResponse: func(webhookID string, eventType string, duration time.Duration) {
+			require.Equal(t, 20*time.Millisecond, duration)
+			slow = append(slow, eventType)
+		},
+	}
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, cfg)
+	require.Nil(t, err)
+	require.Nil(t, client.Post("wh_123", `{"type":"invoice.paid"}`, map[string]string{}))
+	require.Nil(t, slow)
+
+	client, err = NewEndpointClient(ts.URL+"?slow=1", false, []string{"*"}, cfg)
+	require.Nil(t, err)
+	require.Nil(t, client.Post("wh_123", `{"type":"invoice.paid"}`, map[string]string{}))
+	require.Equal(t, []string{"invoice.paid"}, slow)
+	require.Contains(t, out.String(), "Local endpoint took")
+}
+
+func TestEndpointClientLastResponse(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(strings.Repeat("x", 2*maxRecordedResponseBody)))
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{})
+	require.Nil(t, err)
+
+	_, ok := client.LastResponse()
+	require.False(t, ok)
+
+	start := time.Now()
+	require.Nil(t, client.Post("wh_123", "{}", map[string]string{}))
+
+	last, ok := client.LastResponse()
+	require.True(t, ok)
+	require.Equal(t, http.StatusAccepted, last.StatusCode)
+	require.Len(t, last.Body, maxRecordedResponseBody)
+	require.False(t, last.Timestamp.Before(start))
+}
@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//
+// Public types
+//
+
+// MultiEndpointClient forwards each event to several local endpoints
+// concurrently. The response handler of its configuration is invoked once
+// per endpoint; the endpoint a response comes from is available through the
+// response's Request.URL.
+type MultiEndpointClient struct {
+	clients []*EndpointClient
+}
+
+// MultiEndpointError is returned when forwarding an event failed for some of
+// the endpoints of a MultiEndpointClient.
+type MultiEndpointError struct {
+	// Errors maps the URL of each endpoint that failed to its error
+	Errors map[string]error
+}
+
+func (e *MultiEndpointError) Error() string {
+	urls := make([]string, 0, len(e.Errors))
+	for url := range e.Errors {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+
+	msgs := make([]string, 0, len(urls))
+	for _, url := range urls {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", url, e.Errors[url]))
+	}
+
+	return fmt.Sprintf("failed to forward event to %d endpoint(s): %s", len(urls), strings.Join(msgs, "; "))
+}
+
+// SupportsEventType takes an event of a webhook and compares it to the internal
+// list of supported events
+func (c *MultiEndpointClient) SupportsEventType(connect bool, eventType string) bool {
+	if len(c.clients) == 0 {
+		return false
+	}
+
+	return c.clients[0].SupportsEventType(connect, eventType)
+}
+
+// Post sends a message to all the local endpoints.
+func (c *MultiEndpointClient) Post(webhookID string, body string, headers map[string]string) error {
+	return c.PostWithContext(context.Background(), webhookID, body, headers)
+}
+
+// PostWithContext sends a message to all the local endpoints concurrently and
+// waits for all of them to complete. The event is delivered to every endpoint
+// that can be reached even if others fail, in which case a
+// *MultiEndpointError is returned.
+func (c *MultiEndpointClient) PostWithContext(ctx context.Context, webhookID string, body string, headers map[string]string) error {
+	var mu sync.Mutex
+	errs := make(map[string]error)
+
+	wg := &sync.WaitGroup{}
+	for _, client := range c.clients {
+		wg.Add(1)
+		go func(client *EndpointClient) {
+			defer wg.Done()
+
+			err := client.PostWithContext(ctx, webhookID, body, headers)
+			if err != nil {
+				mu.Lock()
+				errs[client.URL] = err
+				mu.Unlock()
+			}
+		}(client)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return &MultiEndpointError{Errors: errs}
+	}
+
+	return nil
+}
+
+//
+// Public functions
+//
+
+// NewMultiEndpointClient returns a new MultiEndpointClient that forwards to
+// all the given URLs. Each endpoint gets its own copy of the configuration,
+// and its own DedupFile and DeadLetterFile, see endpointFilePath.
+func NewMultiEndpointClient(urls []string, connect bool, events []string, cfg *EndpointConfig) (*MultiEndpointClient, error) {
+	if cfg == nil {
+		cfg = &EndpointConfig{}
+	}
+
+	clients := make([]*EndpointClient, 0, len(urls))
+	for _, url := range urls {
+		clientCfg := *cfg
+		withEndpointFiles(&clientCfg, url)
+
+		client, err := NewEndpointClient(url, connect, events, &clientCfg)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+
+	return &MultiEndpointClient{
+		clients: clients,
+	}, nil
+}
+
+//
+// Private functions
+//
+
+// withEndpointFiles gives the configuration of one of several endpoints its
+// own DedupFile and DeadLetterFile. Each client rewrites these files with its
+// own entries only, so sharing them would erase the entries of the other
+// endpoints, and an endpoint would skip the events delivered to the others.
+func withEndpointFiles(cfg *EndpointConfig, url string) {
+	cfg.DedupFile = endpointFilePath(cfg.DedupFile, url)
+	cfg.DeadLetterFile = endpointFilePath(cfg.DeadLetterFile, url)
+}
+
+// endpointFilePath returns the path of the endpoint's file, which is the
+// path with a hash of the endpoint's URL inserted before its extension, e.g.
+// dedup.1a2b3c4d.jsonl, so that it doesn't change when endpoints are added
+// or reordered. An empty path is returned unchanged.
+func endpointFilePath(path string, url string) string {
+	if path == "" {
+		return ""
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(url)) // #nosec G104
+	ext := filepath.Ext(path)
+
+	return fmt.Sprintf("%s.%08x%s", strings.TrimSuffix(path, ext), h.Sum32(), ext)
+}
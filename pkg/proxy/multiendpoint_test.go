@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiEndpointClientPost(t *testing.T) {
+	ts1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts1.Close()
+
+	ts2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts2.Close()
+
+	var mu sync.Mutex
+	rcvStatuses := make(map[string]int)
+	client, err := NewMultiEndpointClient(
+		[]string{ts1.URL, ts2.URL},
+		false,
+		[]string{"*"},
+		&EndpointConfig{
+			ResponseHandler: EndpointResponseHandlerFunc(func(webhookID string, resp *http.Response) {
+				mu.Lock()
+				defer mu.Unlock()
+				rcvStatuses[resp.Request.URL.String()] = resp.StatusCode
+			}),
+		},
+	)
+	require.Nil(t, err)
+
+	err = client.Post("wh_123", "{}", map[string]string{})
+
+	require.Nil(t, err)
+	require.Equal(t, map[string]int{ts1.URL: http.StatusOK, ts2.URL: http.StatusAccepted}, rcvStatuses)
+}
+
+func TestMultiEndpointClientPartialFailure(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	downURL := down.URL
+	down.Close()
+
+	delivered := false
+	client, err := NewMultiEndpointClient(
+		[]string{ts.URL, downURL},
+		false,
+		[]string{"*"},
+		&EndpointConfig{
+			ResponseHandler: EndpointResponseHandlerFunc(func(webhookID string, resp *http.Response) {
+				delivered = true
+			}),
+		},
+	)
+	require.Nil(t, err)
+
+	err = client.Post("wh_123", "{}", map[string]string{})
+
+	require.True(t, delivered)
+	require.IsType(t, &MultiEndpointError{}, err)
+	multiErr := err.(*MultiEndpointError)
+	require.Len(t, multiErr.Errors, 1)
+	require.Contains(t, multiErr.Errors, downURL)
+	require.Contains(t, err.Error(), downURL)
+}
+
+func TestMultiEndpointClientSupportsEventType(t *testing.T) {
+	client, err := NewMultiEndpointClient([]string{"http://localhost:1", "http://localhost:2"}, false, []string{"charge.created"}, nil)
+	require.Nil(t, err)
+
+	require.True(t, client.SupportsEventType(false, "charge.created"))
+	require.False(t, client.SupportsEventType(false, "charge.updated"))
+	require.False(t, client.SupportsEventType(true, "charge.created"))
+}
+
+func TestMultiEndpointClientDedupFile(t *testing.T) {
+	var count1, count2 int32
+	ts1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count1, 1)
+	}))
+	defer ts1.Close()
+
+	ts2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&count2, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts2.Close()
+
+	dir, err := ioutil.TempDir("", "stripe-cli-proxy")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := &EndpointConfig{
+		DedupSize: 10,
+		DedupFile: filepath.Join(dir, "dedup.jsonl"),
+	}
+	client, err := NewMultiEndpointClient([]string{ts1.URL, ts2.URL}, false, []string{"*"}, cfg)
+	require.Nil(t, err)
+	require.Nil(t, client.Post("wh_123", `{"id":"evt_123"}`, map[string]string{}))
+
+	// After a restart, only the endpoint that failed receives the event
+	client, err = NewMultiEndpointClient([]string{ts1.URL, ts2.URL}, false, []string{"*"}, cfg)
+	require.Nil(t, err)
+	require.Nil(t, client.Post("wh_123", `{"id":"evt_123"}`, map[string]string{}))
+	require.Equal(t, int32(1), atomic.LoadInt32(&count1))
+	require.Equal(t, int32(2), atomic.LoadInt32(&count2))
+
+	files, err := filepath.Glob(filepath.Join(dir, "dedup.*.jsonl"))
+	require.Nil(t, err)
+	require.Len(t, files, 2)
+}
+
+func TestEndpointFilePath(t *testing.T) {
+	path := endpointFilePath("/tmp/dedup.jsonl", "http://localhost:4242")
+	require.Regexp(t, `^/tmp/dedup\.[0-9a-f]{8}\.jsonl$`, path)
+	require.Equal(t, path, endpointFilePath("/tmp/dedup.jsonl", "http://localhost:4242"))
+	require.NotEqual(t, path, endpointFilePath("/tmp/dedup.jsonl", "http://localhost:4243"))
+	require.Equal(t, "", endpointFilePath("", "http://localhost:4242"))
+}
@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+)
+
+//
+// Private constants
+//
+
+// serialKey is the partition key of all events when SerializeDelivery is set
+const serialKey = "*"
+
+//
+// Private types
+//
+
+// partitioner serializes the delivery of events sharing a partition key.
+// Every key maps to the done channel of the last event queued for it, which
+// the next event with the same key waits for, so that events are delivered
+// in the order in which they were posted.
+type partitioner struct {
+	mu    sync.Mutex
+	tails map[string]chan struct{}
+}
+
+// acquire waits until the events previously queued with the key were
+// delivered, or until the context is done. The returned function must be
+// called once the event was delivered, even if acquire returned an error,
+// and may be called again.
+func (p *partitioner) acquire(ctx context.Context, key string) (func(), error) {
+	p.mu.Lock()
+	prev := p.tails[key]
+	done := make(chan struct{})
+	p.tails[key] = done
+	p.mu.Unlock()
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			p.mu.Lock()
+			if p.tails[key] == done {
+				delete(p.tails, key)
+			}
+			p.mu.Unlock()
+			close(done)
+		})
+	}
+
+	if prev == nil {
+		return release, nil
+	}
+
+	select {
+	case <-prev:
+		return release, nil
+	case <-ctx.Done():
+		// The events queued after this one must still wait for the previous
+		// ones
+		var once sync.Once
+		return func() {
+			once.Do(func() {
+				go func() {
+					<-prev
+					release()
+				}()
+			})
+		}, ctx.Err()
+	}
+}
+
+//
+// Private functions
+//
+
+func newPartitioner(keyFunc func(body string) string, serialize bool) *partitioner {
+	if keyFunc == nil && !serialize {
+		return nil
+	}
+
+	return &partitioner{tails: make(map[string]chan struct{})}
+}
+
+// awaitPartition waits for the turn of the event in its partition, which is
+// the same for all events when SerializeDelivery is set. It returns a
+// function to call once the event was delivered, or joined a batch.
+func (c *EndpointClient) awaitPartition(ctx context.Context, body string) (func(), error) {
+	if c.partitioner == nil {
+		return func() {}, nil
+	}
+
+	key := serialKey
+	if !c.cfg.SerializeDelivery {
+		key = c.cfg.PartitionKeyFunc(body)
+	}
+	if key == "" {
+		return func() {}, nil
+	}
+
+	return c.partitioner.acquire(ctx, key)
+}
@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostPartitionKey(t *testing.T) {
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	received := make(chan string, 3)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Event")
+		if id == "evt_1" {
+			close(started)
+			<-unblock
+		}
+		received <- id
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		PartitionKeyFunc: func(body string) string {
+			var evt struct {
+				Data struct {
+					Object struct {
+						Customer string `json:"customer"`
+					} `json:"object"`
+				} `json:"data"`
+			}
+			json.Unmarshal([]byte(body), &evt) // #nosec G104
+			return evt.Data.Object.Customer
+		},
+	})
+	require.Nil(t, err)
+
+	post := func(id string, customer string) {
+		body := `{"id":"` + id + `","data":{"object":{"customer":"` + customer + `"}}}`
+		go client.Post("wh_123", body, map[string]string{"X-Event": id}) // #nosec G104
+	}
+
+	post("evt_1", "cus_a")
+	<-started
+	post("evt_2", "cus_a")
+	post("evt_3", "cus_b")
+
+	// Events with a different key aren't held back
+	require.Equal(t, "evt_3", <-received)
+
+	select {
+	case id := <-received:
+		require.Fail(t, "event delivered out of order", id)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(unblock)
+	require.Equal(t, "evt_1", <-received)
+	require.Equal(t, "evt_2", <-received)
+}
+
+func TestPartitionerCanceled(t *testing.T) {
+	p := newPartitioner(strings.TrimSpace, false)
+
+	release1, err := p.acquire(context.Background(), "cus_a")
+	require.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	release2, err := p.acquire(ctx, "cus_a")
+	require.Equal(t, context.Canceled, err)
+	release2()
+
+	acquired := make(chan struct{})
+	released := make(chan struct{})
+	go func() {
+		release3, err := p.acquire(context.Background(), "cus_a")
+		require.Nil(t, err)
+		close(acquired)
+		release3()
+		close(released)
+	}()
+
+	select {
+	case <-acquired:
+		require.Fail(t, "acquired before the first event was released")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	release1()
+	<-acquired
+	<-released
+
+	// The keys are forgotten once all their events were delivered
+	require.Empty(t, p.tails)
+}
+
+func TestPostSerializeDelivery(t *testing.T) {
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	received := make(chan string, 3)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Event")
+		if id == "evt_1" {
+			close(started)
+			<-unblock
+		}
+		received <- id
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		SerializeDelivery: true,
+	})
+	require.Nil(t, err)
+
+	go client.Post("wh_123", `{"id":"evt_1"}`, map[string]string{"X-Event": "evt_1"}) // #nosec G104
+	<-started
+	for _, id := range []string{"evt_2", "evt_3"} {
+		tail := partitionTail(client, serialKey)
+		go client.Post("wh_123", `{"id":"`+id+`"}`, map[string]string{"X-Event": id}) // #nosec G104
+		waitForPartitionTail(client, serialKey, tail)
+	}
+
+	// All events wait for the first one
+	select {
+	case id := <-received:
+		require.Fail(t, "event delivered out of order", id)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(unblock)
+	require.Equal(t, "evt_1", <-received)
+	require.Equal(t, "evt_2", <-received)
+	require.Equal(t, "evt_3", <-received)
+}
+
+func partitionTail(client *EndpointClient, key string) chan struct{} {
+	client.partitioner.mu.Lock()
+	defer client.partitioner.mu.Unlock()
+
+	return client.partitioner.tails[key]
+}
+
+// waitForPartitionTail waits for an event to be queued after the tail of the
+// partition with the key, for up to a second.
+func waitForPartitionTail(client *EndpointClient, key string, tail chan struct{}) {
+	deadline := time.Now().Add(time.Second)
+	for partitionTail(client, key) == tail && time.Now().Before(deadline) {
+		runtime.Gosched()
+	}
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//
+// Public functions
+//
+
+// Pause stops the forwarding of events until Resume is called, e.g. to
+// inspect an event without more piling in. While the client is paused, new
+// events are buffered if BufferSize is set, following the overflow policy of
+// the buffer once it is full, and otherwise Post, PostReader and the batches
+// of BatchURL block until the client is resumed or their context is done.
+// Events already being forwarded aren't affected. Pausing a paused client
+// does nothing.
+func (c *EndpointClient) Pause() {
+	c.pauseMu.Lock()
+	defer c.pauseMu.Unlock()
+
+	if c.resumed != nil {
+		return
+	}
+	c.resumed = make(chan struct{})
+
+	c.cfg.Log.WithFields(log.Fields{
+		"prefix": "proxy.EndpointClient.Pause",
+	}).Info("Paused forwarding to local endpoint")
+}
+
+// Resume resumes the forwarding of events after Pause, unblocking the
+// waiting calls to Post and delivering the buffered events in order.
+// Resuming a client that isn't paused does nothing.
+func (c *EndpointClient) Resume() {
+	c.pauseMu.Lock()
+	defer c.pauseMu.Unlock()
+
+	if c.resumed == nil {
+		return
+	}
+	close(c.resumed)
+	c.resumed = nil
+
+	c.cfg.Log.WithFields(log.Fields{
+		"prefix": "proxy.EndpointClient.Resume",
+	}).Info("Resumed forwarding to local endpoint")
+}
+
+// IsPaused returns whether the forwarding is paused.
+func (c *EndpointClient) IsPaused() bool {
+	return c.resumedChan() != nil
+}
+
+//
+// Private functions
+//
+
+// resumedChan returns the channel closed by the next call to Resume, or nil
+// if the client isn't paused.
+func (c *EndpointClient) resumedChan() chan struct{} {
+	c.pauseMu.Lock()
+	defer c.pauseMu.Unlock()
+
+	return c.resumed
+}
+
+// awaitResume waits until the client isn't paused, or until the context is
+// done, in which case it returns the context's error.
+func (c *EndpointClient) awaitResume(ctx context.Context) error {
+	resumed := c.resumedChan()
+	if resumed == nil {
+		return nil
+	}
+
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/stripe-cli/pkg/proxy/proxytest"
+)
+
+func TestPauseBlocksPost(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		received = append(received, string(buf))
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, nil)
+	require.Nil(t, err)
+
+	client.Pause()
+	client.Pause()
+	require.True(t, client.IsPaused())
+
+	// Posts honor their context while paused
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, client.PostWithContext(ctx, "wh_1", `{"id":"evt_1"}`, map[string]string{}))
+
+	done := make(chan error)
+	go func() { done <- client.Post("wh_2", `{"id":"evt_2"}`, map[string]string{}) }()
+
+	select {
+	case <-done:
+		t.Fatal("Post returned while paused")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	client.Resume()
+	client.Resume()
+	require.False(t, client.IsPaused())
+	require.Nil(t, <-done)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []string{`{"id":"evt_2"}`}, received)
+}
+
+func TestPauseBlocksPostReader(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, nil)
+	require.Nil(t, err)
+	require.False(t, client.needsPayload(-1))
+
+	client.Pause()
+
+	body := `{"id":"evt_1"}`
+	done := make(chan error)
+	go func() {
+		done <- client.PostReader(context.Background(), "wh_1", strings.NewReader(body), int64(len(body)), map[string]string{})
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("PostReader returned while paused")
+	case <-time.After(20 * time.Millisecond):
+	}
+	require.Equal(t, int32(0), atomic.LoadInt32(&requests))
+
+	client.Resume()
+	require.Nil(t, <-done)
+	require.Equal(t, int32(1), atomic.LoadInt32(&requests))
+}
+
+func TestPauseBuffersEvents(t *testing.T) {
+	received := make(chan string, 2)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf, _ := ioutil.ReadAll(r.Body)
+		received <- string(buf)
+	}))
+	defer ts.Close()
+
+	clock := proxytest.NewFakeClock(time.Date(2019, 9, 1, 12, 0, 0, 0, time.UTC))
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		BufferSize:     2,
+		BufferOverflow: RejectNewest,
+		Clock:          clock,
+	})
+	require.Nil(t, err)
+
+	client.Pause()
+	require.Nil(t, client.Post("wh_1", `{"id":"evt_1"}`, map[string]string{}))
+	require.Nil(t, client.Post("wh_2", `{"id":"evt_2"}`, map[string]string{}))
+	require.Equal(t, ErrBufferFull, client.Post("wh_3", `{"id":"evt_3"}`, map[string]string{}))
+
+	// Nothing is flushed while paused
+	require.True(t, clock.WaitForWaiters(1, time.Second))
+	clock.Advance(minFlushDelay)
+	select {
+	case body := <-received:
+		require.Fail(t, "event flushed while paused", body)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	client.Resume()
+	require.Equal(t, `{"id":"evt_1"}`, <-received)
+	require.Equal(t, `{"id":"evt_2"}`, <-received)
+}
+
+func TestClosePaused(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		BufferSize: 10,
+	})
+	require.Nil(t, err)
+
+	client.Pause()
+	require.Nil(t, client.Post("wh_1", `{"id":"evt_1"}`, map[string]string{}))
+
+	// The buffered events are dropped without waiting for the client to be
+	// resumed
+	require.Equal(t, 1, client.Close(context.Background()))
+}
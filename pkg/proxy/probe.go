@@ -0,0 +1,190 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//
+// Public functions
+//
+
+// Ping checks that the endpoint is reachable by sending it a probe request,
+// as configured by ProbeMethod, ProbePath and ProbeStatusCodes. It returns an
+// error naming the endpoint's URL if the endpoint didn't respond within a few
+// seconds or responded with an unexpected status code, in which case the
+// error matches *HTTPStatusError with errors.As. For clients with several
+// targets, every target is probed.
+func (c *EndpointClient) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, defaultProbeTimeout)
+	defer cancel()
+
+	for _, t := range c.targets.targets {
+		if err := c.probe(ctx, t); err != nil {
+			return fmt.Errorf("%s: %w", t.url, err)
+		}
+	}
+
+	return nil
+}
+
+// Warmup primes the endpoint before events are forwarded, e.g. to absorb the
+// cold start of a serverless emulator, by sending it n probe requests as
+// configured for Ping. The first probe is sent alone and the others
+// concurrently, so that up to n connections are opened and kept alive for
+// the first events. Endpoints that respond to the first probe with 404, 405
+// or 501 don't support it and aren't probed again, which isn't an error.
+// Unlike Ping, the probes are only bounded by the context and the timeout of
+// the client, since cold starts can be slow. For clients with several
+// targets, every target is warmed up.
+func (c *EndpointClient) Warmup(ctx context.Context, n int) error {
+	if n <= 0 {
+		return nil
+	}
+
+	for _, t := range c.targets.targets {
+		if err := c.warmup(ctx, t, n); err != nil {
+			return fmt.Errorf("%s: %w", t.url, err)
+		}
+	}
+
+	return nil
+}
+
+// Ping checks that all the endpoints are reachable, as EndpointClient.Ping
+// does, and returns the first error.
+func (c *MultiEndpointClient) Ping(ctx context.Context) error {
+	return pingAll(ctx, c.clients)
+}
+
+// Ping checks that both the primary and backup endpoints are reachable, as
+// EndpointClient.Ping does, and returns the first error.
+func (c *FailoverClient) Ping(ctx context.Context) error {
+	return pingAll(ctx, []*EndpointClient{c.primary, c.backup})
+}
+
+// Ping checks that the endpoints of all the routes are reachable, as
+// EndpointClient.Ping does, and returns the first error.
+func (c *RoutingEndpointClient) Ping(ctx context.Context) error {
+	return pingAll(ctx, c.clients)
+}
+
+//
+// Private constants
+//
+
+const defaultProbeTimeout = 3 * time.Second
+
+//
+// Private variables
+//
+
+// unsupportedProbeStatusCodes are the status codes with which an endpoint
+// signals that it doesn't support the probe requests
+var unsupportedProbeStatusCodes = map[int]bool{
+	http.StatusNotFound:         true,
+	http.StatusMethodNotAllowed: true,
+	http.StatusNotImplemented:   true,
+}
+
+//
+// Private functions
+//
+
+func pingAll(ctx context.Context, clients []*EndpointClient) error {
+	for _, client := range clients {
+		if err := client.Ping(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *EndpointClient) probe(ctx context.Context, t *target) error {
+	statusCode, err := c.sendProbe(ctx, t)
+	if err != nil {
+		return err
+	}
+
+	if len(c.cfg.ProbeStatusCodes) == 0 {
+		return nil
+	}
+	for _, expected := range c.cfg.ProbeStatusCodes {
+		if statusCode == expected {
+			return nil
+		}
+	}
+
+	return &HTTPStatusError{Code: statusCode}
+}
+
+func (c *EndpointClient) warmup(ctx context.Context, t *target, n int) error {
+	statusCode, err := c.sendProbe(ctx, t)
+	if err != nil {
+		return err
+	}
+	if unsupportedProbeStatusCodes[statusCode] {
+		c.cfg.Log.WithFields(log.Fields{
+			"prefix": "proxy.EndpointClient.Warmup",
+			"url":    t.url,
+			"status": statusCode,
+		}).Debug("Endpoint doesn't support probes, skipping warmup")
+		return nil
+	}
+
+	errs := make(chan error, n-1)
+	for i := 1; i < n; i++ {
+		go func() {
+			_, err := c.sendProbe(ctx, t)
+			errs <- err
+		}()
+	}
+
+	var firstErr error
+	for i := 1; i < n; i++ {
+		if err := <-errs; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// sendProbe sends a probe request to the target and returns the status code
+// of the response.
+func (c *EndpointClient) sendProbe(ctx context.Context, t *target) (int, error) {
+	method := c.cfg.ProbeMethod
+	if method == "" {
+		method = http.MethodHead
+	}
+
+	probeURL, err := url.Parse(t.requestURL)
+	if err != nil {
+		return 0, err
+	}
+	if c.cfg.ProbePath != "" {
+		probeURL.Path = c.cfg.ProbePath
+		probeURL.RawQuery = ""
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, probeURL.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", c.userAgent())
+
+	resp, err := c.cfg.HTTPClient.Do(req)
+	c.markActive()
+	if err != nil {
+		return 0, classifyError(err)
+	}
+	c.discardResponse(resp)
+
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPing(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == "/healthz" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}))
+	defer ts.Close()
+
+	// Any response means the endpoint is reachable by default
+	client, err := NewEndpointClient(ts.URL+"/webhooks", false, []string{"*"}, nil)
+	require.Nil(t, err)
+	require.Nil(t, client.Ping(context.Background()))
+
+	client, err = NewEndpointClient(ts.URL+"/webhooks", false, []string{"*"}, &EndpointConfig{
+		ProbeStatusCodes: []int{http.StatusOK},
+	})
+	require.Nil(t, err)
+	require.NotNil(t, client.Ping(context.Background()))
+
+	client, err = NewEndpointClient(ts.URL+"/webhooks", false, []string{"*"}, &EndpointConfig{
+		ProbeMethod:      http.MethodGet,
+		ProbePath:        "/healthz",
+		ProbeStatusCodes: []int{http.StatusOK},
+	})
+	require.Nil(t, err)
+	require.Nil(t, client.Ping(context.Background()))
+}
+
+func TestPingUnreachable(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := ts.URL
+	ts.Close()
+
+	client, err := NewEndpointClient(url, false, []string{"*"}, nil)
+	require.Nil(t, err)
+	require.NotNil(t, client.Ping(context.Background()))
+}
+
+func TestWarmup(t *testing.T) {
+	var probes int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&probes, 1)
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		ProbePath: "/healthz",
+	})
+	require.Nil(t, err)
+
+	require.Nil(t, client.Warmup(context.Background(), 5))
+	require.Equal(t, int32(5), atomic.LoadInt32(&probes))
+
+	// Endpoints that don't support probes are only probed once
+	atomic.StoreInt32(&probes, 0)
+	client, err = NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		ProbePath: "/missing",
+	})
+	require.Nil(t, err)
+
+	require.Nil(t, client.Warmup(context.Background(), 5))
+	require.Equal(t, int32(1), atomic.LoadInt32(&probes))
+
+	ts.Close()
+	err = client.Warmup(context.Background(), 5)
+	require.True(t, errors.Is(err, ErrEndpointUnreachable))
+	require.Contains(t, err.Error(), ts.URL)
+}
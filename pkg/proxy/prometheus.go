@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+//
+// Public types
+//
+
+// PrometheusMetrics are the forwarding metrics of one or more
+// EndpointClients, as a prometheus.Collector. They are registered into a
+// registry by RegisterPrometheusMetrics, and updated after every attempt by
+// the clients whose PrometheusRegistry is that registry. They are safe for
+// concurrent use.
+//
+// To keep the number of series bounded, endpoints are labelled with their
+// host rather than their full URL, and the event type and the status code
+// are counted in separate metrics instead of being combined.
+type PrometheusMetrics struct {
+	// attempts counts the attempts by endpoint and status
+	attempts *prometheus.CounterVec
+
+	// eventTypes counts the attempts by event type and result
+	eventTypes *prometheus.CounterVec
+
+	// durations is the histogram of the durations of the attempts by
+	// endpoint
+	durations *prometheus.HistogramVec
+}
+
+// Describe implements prometheus.Collector.
+func (m *PrometheusMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.attempts.Describe(ch)
+	m.eventTypes.Describe(ch)
+	m.durations.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (m *PrometheusMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.attempts.Collect(ch)
+	m.eventTypes.Collect(ch)
+	m.durations.Collect(ch)
+}
+
+// RecordAttempt implements MetricsSink, so that the metrics may also be fed
+// by something else than an EndpointClient.
+func (m *PrometheusMetrics) RecordAttempt(url string, eventType string, statusCode int, duration time.Duration) {
+	m.record(targetHost(url), eventType, statusCode, isSuccessStatusCode(statusCode), duration)
+}
+
+//
+// Public functions
+//
+
+// RegisterPrometheusMetrics registers the forwarding metrics into the
+// registry and returns them. If the registry already has them, e.g. because
+// another client uses the same registry, the registered metrics are
+// returned.
+func RegisterPrometheusMetrics(registry *prometheus.Registry) (*PrometheusMetrics, error) {
+	metrics := newPrometheusMetrics()
+	if err := registry.Register(metrics); err != nil {
+		if registered, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			if existing, ok := registered.ExistingCollector.(*PrometheusMetrics); ok {
+				return existing, nil
+			}
+		}
+		return nil, err
+	}
+
+	return metrics, nil
+}
+
+// PrometheusHandler returns an http.Handler serving the metrics of the
+// registry, to be scraped by Prometheus.
+func PrometheusHandler(registry *prometheus.Registry) http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+//
+// Private constants
+//
+
+// Names of the forwarding metrics
+const (
+	attemptsMetric   = "stripe_cli_forward_attempts_total"
+	eventTypesMetric = "stripe_cli_forward_attempts_by_event_type_total"
+	durationMetric   = "stripe_cli_forward_duration_seconds"
+)
+
+//
+// Private functions
+//
+
+func newPrometheusMetrics() *PrometheusMetrics {
+	return &PrometheusMetrics{
+		attempts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: attemptsMetric,
+			Help: "Number of requests sent to local endpoints, by endpoint and status code.",
+		}, []string{"endpoint", "status"}),
+		eventTypes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: eventTypesMetric,
+			Help: "Number of requests sent to local endpoints, by event type and result.",
+		}, []string{"event_type", "result"}),
+		durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    durationMetric,
+			Help:    "Duration of the requests sent to local endpoints, by endpoint.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"endpoint"}),
+	}
+}
+
+func (m *PrometheusMetrics) record(endpoint string, eventType string, statusCode int, success bool, duration time.Duration) {
+	status := "error"
+	if statusCode != 0 {
+		status = strconv.Itoa(statusCode)
+	}
+
+	result := "failure"
+	if success {
+		result = "success"
+	}
+
+	if eventType == "" {
+		// Streamed events aren't parsed
+		eventType = "unknown"
+	}
+
+	m.attempts.WithLabelValues(endpoint, status).Inc()
+	m.eventTypes.WithLabelValues(eventType, result).Inc()
+	m.durations.WithLabelValues(endpoint).Observe(duration.Seconds())
+}
@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheusRegistry(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	require.Nil(t, err)
+
+	registry := prometheus.NewRegistry()
+	client, err := NewEndpointClient(ts.URL+"/ok", false, []string{"*"}, &EndpointConfig{
+		PrometheusRegistry: registry,
+	})
+	require.Nil(t, err)
+	failing, err := NewEndpointClient(ts.URL+"/fail", false, []string{"*"}, &EndpointConfig{
+		PrometheusRegistry: registry,
+	})
+	require.Nil(t, err)
+
+	require.Nil(t, client.Post("wh_123", `{"type": "charge.created"}`, map[string]string{}))
+	require.Nil(t, client.Post("wh_123", `{"type": "charge.created"}`, map[string]string{}))
+	require.Nil(t, failing.Post("wh_123", `{"type": "charge.failed"}`, map[string]string{}))
+
+	text := scrapePrometheus(t, registry)
+
+	// Both clients share the endpoint label, as the path isn't part of it
+	require.Contains(t, text, "# TYPE stripe_cli_forward_attempts_total counter\n")
+	require.Contains(t, text, `stripe_cli_forward_attempts_total{endpoint="`+u.Host+`",status="200"} 2`+"\n")
+	require.Contains(t, text, `stripe_cli_forward_attempts_total{endpoint="`+u.Host+`",status="400"} 1`+"\n")
+	require.Contains(t, text, `stripe_cli_forward_attempts_by_event_type_total{event_type="charge.created",result="success"} 2`+"\n")
+	require.Contains(t, text, `stripe_cli_forward_attempts_by_event_type_total{event_type="charge.failed",result="failure"} 1`+"\n")
+	require.Contains(t, text, "# TYPE stripe_cli_forward_duration_seconds histogram\n")
+	require.Contains(t, text, `stripe_cli_forward_duration_seconds_bucket{endpoint="`+u.Host+`",le="+Inf"} 3`+"\n")
+	require.Contains(t, text, `stripe_cli_forward_duration_seconds_count{endpoint="`+u.Host+`"} 3`+"\n")
+	require.NotContains(t, text, "/ok")
+}
+
+func TestPrometheusMetricsRecordAttempt(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics, err := RegisterPrometheusMetrics(registry)
+	require.Nil(t, err)
+
+	metrics.RecordAttempt("http://localhost:4242/webhooks", "", 0, 30*time.Millisecond)
+	metrics.RecordAttempt("http://localhost:4242/webhooks", "customer.created", 503, 2*time.Second)
+
+	text := scrapePrometheus(t, registry)
+
+	require.Contains(t, text, `stripe_cli_forward_attempts_total{endpoint="localhost:4242",status="error"} 1`+"\n")
+	require.Contains(t, text, `stripe_cli_forward_attempts_total{endpoint="localhost:4242",status="503"} 1`+"\n")
+	require.Contains(t, text, `stripe_cli_forward_attempts_by_event_type_total{event_type="unknown",result="failure"} 1`+"\n")
+	require.Contains(t, text, `stripe_cli_forward_duration_seconds_bucket{endpoint="localhost:4242",le="0.025"} 0`+"\n")
+	require.Contains(t, text, `stripe_cli_forward_duration_seconds_bucket{endpoint="localhost:4242",le="0.05"} 1`+"\n")
+	require.Contains(t, text, `stripe_cli_forward_duration_seconds_bucket{endpoint="localhost:4242",le="2.5"} 2`+"\n")
+	require.Contains(t, text, `stripe_cli_forward_duration_seconds_sum{endpoint="localhost:4242"} 2.03`+"\n")
+}
+
+func TestRegisterPrometheusMetricsTwice(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics, err := RegisterPrometheusMetrics(registry)
+	require.Nil(t, err)
+
+	again, err := RegisterPrometheusMetrics(registry)
+	require.Nil(t, err)
+	require.True(t, metrics == again)
+}
+
+func scrapePrometheus(t *testing.T, registry *prometheus.Registry) string {
+	rec := httptest.NewRecorder()
+	PrometheusHandler(registry).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	return rec.Body.String()
+}
@@ -1,13 +1,14 @@
 package proxy
 
 import (
-	"crypto/tls"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -24,7 +25,7 @@ import (
 
 // EndpointRoute describes a local endpoint's routing configuration.
 type EndpointRoute struct {
-	// URL is the endpoint's URL.
+	// URL is the endpoint's URL. Events are forwarded over a WebSocket to ws:// and wss:// URLs.
 	URL string
 
 	// Connect indicates whether the endpoint should receive normal (when false) or Connect (when true) events.
@@ -45,6 +46,10 @@ type Config struct {
 	// EndpointsMap is a mapping of local webhook endpoint urls to the events they consume
 	EndpointRoutes []EndpointRoute
 
+	// Endpoints are endpoints to which events are forwarded in addition to
+	// the endpoints built from EndpointRoutes, e.g. fakes in tests
+	Endpoints []Endpoint
+
 	APIBaseURL string
 
 	// WebSocketFeature is the feature specified for the websocket connection
@@ -59,6 +64,12 @@ type Config struct {
 	// Indicates whether to skip certificate verification when forwarding webhooks to HTTPS endpoints
 	SkipVerify bool
 
+	// Indicates whether to log the events that aren't forwarded to an endpoint, along with the reason
+	LogSkipped bool
+
+	// Indicates whether to silence the warning logged when forwarding to an endpoint that isn't on this machine or a private network
+	AllowRemote bool
+
 	Log *log.Logger
 
 	// Force use of unencrypted ws:// protocol instead of wss://
@@ -71,11 +82,21 @@ type Config struct {
 type Proxy struct {
 	cfg *Config
 
-	endpointClients  []*EndpointClient
+	endpointClients  []Endpoint
 	stripeAuthClient *stripeauth.Client
 	webSocketClient  *websocket.Client
 
 	interruptCh chan os.Signal
+
+	// ctx is canceled first on shutdown, so that no new events are
+	// forwarded while the endpoints are drained
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// forwardCtx is canceled once the endpoints were drained, to abort the
+	// forwards that are still in flight
+	forwardCtx    context.Context
+	cancelForward context.CancelFunc
 }
 
 // Run sets the websocket connection and starts the Goroutines to forward
@@ -108,6 +129,10 @@ func (p *Proxy) Run() error {
 	color := ansi.Color(p.cfg.Log.Out)
 	ansi.StopSpinner(s, fmt.Sprintf("Ready! Your webhook signing secret is %s (^C to quit)", color.Bold(session.Secret)), p.cfg.Log.Out)
 
+	// The endpoints are pinged in the background so that Ctrl+C isn't held
+	// up by an endpoint that doesn't respond
+	go p.pingEndpoints()
+
 	// Block until Ctrl+C is received
 	<-p.interruptCh
 
@@ -115,6 +140,18 @@ func (p *Proxy) Run() error {
 		"prefix": "proxy.Proxy.Run",
 	}).Debug("Ctrl+C received, cleaning up...")
 
+	// New events are ignored from now on, while the in-flight forwards
+	// complete and their responses are still sent back over the WebSocket
+	p.cancel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	for _, endpoint := range p.endpointClients {
+		endpoint.Close(ctx)
+	}
+	cancel()
+
+	p.cancelForward()
+
 	if p.webSocketClient != nil {
 		p.webSocketClient.Stop()
 	}
@@ -126,6 +163,25 @@ func (p *Proxy) Run() error {
 	return nil
 }
 
+// pingEndpoints warns about the endpoints that don't seem to be reachable.
+// Each ping is bounded by defaultProbeTimeout, and the remaining endpoints
+// aren't pinged once the proxy is shutting down.
+func (p *Proxy) pingEndpoints() {
+	for _, endpoint := range p.endpointClients {
+		if p.ctx.Err() != nil {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(p.ctx, defaultProbeTimeout)
+		err := endpoint.Ping(ctx)
+		cancel()
+
+		if err != nil && p.ctx.Err() == nil {
+			p.cfg.Log.Warnf("Local endpoint doesn't seem to be reachable: %v", err)
+		}
+	}
+}
+
 func (p *Proxy) filterWebhookEvent(msg *websocket.WebhookEvent) bool {
 	if msg.Endpoint.APIVersion != nil && !p.cfg.UseLatestAPIVersion {
 		p.cfg.Log.WithFields(log.Fields{
@@ -158,6 +214,14 @@ func (p *Proxy) processWebhookEvent(msg websocket.IncomingMessage) {
 		"webhook_id": webhookEvent.WebhookID,
 	}).Debugf("Processing webhook event")
 
+	if p.ctx.Err() != nil {
+		p.cfg.Log.WithFields(log.Fields{
+			"prefix":     "proxy.Proxy.processWebhookEvent",
+			"webhook_id": webhookEvent.WebhookID,
+		}).Debug("Shutting down, not forwarding event")
+		return
+	}
+
 	if p.filterWebhookEvent(webhookEvent) {
 		return
 	}
@@ -186,7 +250,7 @@ func (p *Proxy) processWebhookEvent(msg websocket.IncomingMessage) {
 
 	for _, endpoint := range p.endpointClients {
 		if endpoint.SupportsEventType(evt.isConnect(), evt.Type) {
-			go endpoint.Post(webhookEvent.WebhookID, webhookEvent.EventPayload, webhookEvent.HTTPHeaders)
+			go endpoint.PostWithContext(p.forwardCtx, webhookEvent.WebhookID, webhookEvent.EventPayload, webhookEvent.HTTPHeaders)
 		}
 	}
 	// TODO: handle errors returned by endpointClients
@@ -226,7 +290,7 @@ func (p *Proxy) processEndpointResponse(webhookID string, resp *http.Response) {
 //
 
 // New creates a new Proxy
-func New(cfg *Config) *Proxy {
+func New(cfg *Config) (*Proxy, error) {
 	if cfg.Log == nil {
 		cfg.Log = &log.Logger{Out: ioutil.Discard}
 	}
@@ -238,27 +302,49 @@ func New(cfg *Config) *Proxy {
 		}),
 		interruptCh: make(chan os.Signal, 1),
 	}
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	p.forwardCtx, p.cancelForward = context.WithCancel(context.Background())
 
 	for _, route := range cfg.EndpointRoutes {
-		// append to endpointClients
-		p.endpointClients = append(p.endpointClients, NewEndpointClient(
-			route.URL,
-			route.Connect,
-			route.EventTypes,
-			&EndpointConfig{
-				HTTPClient: &http.Client{
-					Timeout: defaultTimeout,
-					Transport: &http.Transport{
-						TLSClientConfig: &tls.Config{InsecureSkipVerify: cfg.SkipVerify},
-					},
+		var endpointClient Endpoint
+		var err error
+		if strings.HasPrefix(route.URL, "ws://") || strings.HasPrefix(route.URL, "wss://") {
+			endpointClient, err = NewWebSocketEndpointClient(
+				route.URL,
+				route.Connect,
+				route.EventTypes,
+				&WebSocketEndpointConfig{
+					InsecureSkipVerify: cfg.SkipVerify,
+					LogSkipped:         cfg.LogSkipped,
+					AllowRemote:        cfg.AllowRemote,
+					Log:                p.cfg.Log,
+					ResponseHandler:    EndpointResponseHandlerFunc(p.processEndpointResponse),
+				},
+			)
+		} else {
+			endpointClient, err = NewEndpointClient(
+				route.URL,
+				route.Connect,
+				route.EventTypes,
+				&EndpointConfig{
+					InsecureSkipVerify: cfg.SkipVerify,
+					LogSkipped:         cfg.LogSkipped,
+					AllowRemote:        cfg.AllowRemote,
+					Log:                p.cfg.Log,
+					ResponseHandler:    EndpointResponseHandlerFunc(p.processEndpointResponse),
 				},
-				Log:             p.cfg.Log,
-				ResponseHandler: EndpointResponseHandlerFunc(p.processEndpointResponse),
-			},
-		))
+			)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// append to endpointClients
+		p.endpointClients = append(p.endpointClients, endpointClient)
 	}
+	p.endpointClients = append(p.endpointClients, cfg.Endpoints...)
 
-	return p
+	return p, nil
 }
 
 //
@@ -270,6 +356,10 @@ const (
 	maxNumHeaders      = 20
 	maxHeaderKeySize   = 50
 	maxHeaderValueSize = 200
+
+	// drainTimeout is how long in-flight forwards may take to complete on
+	// shutdown
+	drainTimeout = 5 * time.Second
 )
 
 //
@@ -1,7 +1,9 @@
 package proxy
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -9,8 +11,10 @@ import (
 )
 
 func TestFilterWebhookEvent(t *testing.T) {
-	proxyUseDefault := New(&Config{UseLatestAPIVersion: false})
-	proxyUseLatest := New(&Config{UseLatestAPIVersion: true})
+	proxyUseDefault, err := New(&Config{UseLatestAPIVersion: false})
+	require.Nil(t, err)
+	proxyUseLatest, err := New(&Config{UseLatestAPIVersion: true})
+	require.Nil(t, err)
 
 	evtDefault := &websocket.WebhookEvent{
 		Endpoint: websocket.WebhookEndpoint{
@@ -41,3 +45,103 @@ func TestTruncate(t *testing.T) {
 	require.Equal(t, "Hello, 世", truncate("Hello, 世界", 12, false))
 	require.Equal(t, "Hello, ...", truncate("Hello, 世界", 12, true))
 }
+
+type fakeEndpoint struct {
+	posted chan string
+	ping   func(ctx context.Context) error
+}
+
+func (e *fakeEndpoint) SupportsEventType(connect bool, eventType string) bool {
+	return eventType == "invoice.paid"
+}
+
+func (e *fakeEndpoint) Post(webhookID string, body string, headers map[string]string) error {
+	return e.PostWithContext(context.Background(), webhookID, body, headers)
+}
+
+func (e *fakeEndpoint) PostWithContext(ctx context.Context, webhookID string, body string, headers map[string]string) error {
+	e.posted <- webhookID
+	return nil
+}
+
+func (e *fakeEndpoint) Ping(ctx context.Context) error {
+	if e.ping != nil {
+		return e.ping(ctx)
+	}
+	return nil
+}
+
+func (e *fakeEndpoint) Close(ctx context.Context) int {
+	return 0
+}
+
+func TestProcessWebhookEventFakeEndpoint(t *testing.T) {
+	endpoint := &fakeEndpoint{posted: make(chan string, 2)}
+	p, err := New(&Config{Endpoints: []Endpoint{endpoint}})
+	require.Nil(t, err)
+
+	p.processWebhookEvent(websocket.IncomingMessage{WebhookEvent: &websocket.WebhookEvent{
+		WebhookID:    "wh_ignored",
+		EventPayload: `{"id":"evt_1","type":"charge.succeeded"}`,
+	}})
+	p.processWebhookEvent(websocket.IncomingMessage{WebhookEvent: &websocket.WebhookEvent{
+		WebhookID:    "wh_123",
+		EventPayload: `{"id":"evt_2","type":"invoice.paid"}`,
+	}})
+
+	require.Equal(t, "wh_123", <-endpoint.posted)
+}
+
+func TestProcessWebhookEventShuttingDown(t *testing.T) {
+	endpoint := &fakeEndpoint{posted: make(chan string, 1)}
+	p, err := New(&Config{Endpoints: []Endpoint{endpoint}})
+	require.Nil(t, err)
+
+	// Events received once the shutdown started aren't forwarded, while the
+	// forwards in flight aren't canceled until the endpoints were drained
+	p.cancel()
+	p.processWebhookEvent(websocket.IncomingMessage{WebhookEvent: &websocket.WebhookEvent{
+		WebhookID:    "wh_123",
+		EventPayload: `{"id":"evt_1","type":"invoice.paid"}`,
+	}})
+
+	select {
+	case webhookID := <-endpoint.posted:
+		t.Fatalf("Forwarded %s while shutting down", webhookID)
+	case <-time.After(20 * time.Millisecond):
+	}
+	require.Nil(t, p.forwardCtx.Err())
+}
+
+func TestPingEndpointsNeverConnects(t *testing.T) {
+	pinged := make(chan context.Context, 1)
+	endpoint := &fakeEndpoint{ping: func(ctx context.Context) error {
+		// Like a WebSocket endpoint that never connects, the ping only
+		// returns once its context is done
+		pinged <- ctx
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+	p, err := New(&Config{Endpoints: []Endpoint{endpoint, endpoint}})
+	require.Nil(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		p.pingEndpoints()
+		close(done)
+	}()
+
+	ctx := <-pinged
+	deadline, ok := ctx.Deadline()
+	require.True(t, ok)
+	require.WithinDuration(t, time.Now().Add(defaultProbeTimeout), deadline, time.Second)
+
+	// Shutting down stops the ping in progress and skips the other endpoint
+	p.cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("pingEndpoints didn't return after the shutdown started")
+	}
+	require.Len(t, pinged, 0)
+}
@@ -0,0 +1,123 @@
+package proxytest
+
+import (
+	"sync"
+	"time"
+)
+
+//
+// Public types
+//
+
+// FakeClock is a clock whose time only moves when it is advanced, to test
+// the timing features of an EndpointClient deterministically. It implements
+// proxy.Clock and is safe for concurrent use.
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*waiter
+
+	// changed is closed and replaced every time a waiter is added
+	changed chan struct{}
+}
+
+// Now returns the current time of the clock.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// After returns a channel that receives the time of the clock once it was
+// advanced by at least d.
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+
+	c.waiters = append(c.waiters, &waiter{deadline: c.now.Add(d), ch: ch})
+	close(c.changed)
+	c.changed = make(chan struct{})
+
+	return ch
+}
+
+// Advance moves the time of the clock forward by d, firing the channels
+// returned by After whose duration elapsed.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+
+	pending := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.deadline.After(c.now) {
+			pending = append(pending, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = pending
+}
+
+// Waiters returns the number of channels returned by After that haven't
+// fired yet.
+func (c *FakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.waiters)
+}
+
+// WaitForWaiters waits until at least n channels returned by After are
+// waiting for the clock to be advanced, e.g. until a client is sleeping
+// before a retry, and returns whether they were before the timeout.
+func (c *FakeClock) WaitForWaiters(n int, timeout time.Duration) bool {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		c.mu.Lock()
+		count := len(c.waiters)
+		changed := c.changed
+		c.mu.Unlock()
+
+		if count >= n {
+			return true
+		}
+
+		select {
+		case <-changed:
+		case <-deadline.C:
+			return false
+		}
+	}
+}
+
+//
+// Public functions
+//
+
+// NewFakeClock returns a new FakeClock set to the given time.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{
+		now:     now,
+		changed: make(chan struct{}),
+	}
+}
+
+//
+// Private types
+//
+
+type waiter struct {
+	deadline time.Time
+	ch       chan time.Time
+}
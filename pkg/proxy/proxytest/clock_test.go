@@ -0,0 +1,43 @@
+package proxytest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2019, 9, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	require.Equal(t, start, clock.Now())
+
+	short := clock.After(time.Second)
+	long := clock.After(time.Minute)
+	require.Equal(t, 2, clock.Waiters())
+
+	clock.Advance(time.Second)
+	require.Equal(t, start.Add(time.Second), <-short)
+	require.Equal(t, 1, clock.Waiters())
+
+	select {
+	case <-long:
+		require.Fail(t, "fired before its duration elapsed")
+	default:
+	}
+
+	clock.Advance(time.Hour)
+	require.Equal(t, start.Add(time.Hour+time.Second), <-long)
+	require.Equal(t, 0, clock.Waiters())
+
+	// Durations that are already elapsed fire immediately
+	require.Equal(t, clock.Now(), <-clock.After(0))
+}
+
+func TestFakeClockWaitForWaiters(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	require.False(t, clock.WaitForWaiters(1, 10*time.Millisecond))
+
+	go clock.After(time.Second)
+	require.True(t, clock.WaitForWaiters(1, time.Second))
+}
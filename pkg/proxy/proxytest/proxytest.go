@@ -0,0 +1,243 @@
+// Package proxytest provides a fake local endpoint for testing the clients
+// that forward events to it, and a fake clock to control their timing.
+package proxytest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+)
+
+//
+// Public types
+//
+
+// RecordedRequest is a request received by a RecordingEndpoint.
+type RecordedRequest struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   []byte
+
+	// EventType is the type of the event in the body, or empty if the body
+	// isn't an event
+	EventType string
+
+	// Received is when the request was received
+	Received time.Time
+}
+
+// Response is a canned response of a RecordingEndpoint.
+type Response struct {
+	// StatusCode defaults to 200
+	StatusCode int
+	Header     http.Header
+	Body       string
+
+	// Delay is how long the endpoint waits before responding. The wait ends
+	// early if the client gives up on the request.
+	Delay time.Duration
+
+	// CloseConnection makes the endpoint close the connection without
+	// responding, which the client sees as a transport error
+	CloseConnection bool
+}
+
+// RecordingEndpoint is a local HTTP server that records the requests it
+// receives and answers them with scripted responses.
+type RecordingEndpoint struct {
+	// URL of the endpoint, of the form http://127.0.0.1:port
+	URL string
+
+	server *httptest.Server
+
+	mu              sync.Mutex
+	requests        []*RecordedRequest
+	responses       []Response
+	defaultResponse Response
+	received        chan struct{}
+}
+
+// Close shuts down the endpoint.
+func (e *RecordingEndpoint) Close() {
+	e.server.Close()
+}
+
+// Enqueue scripts the responses of the next requests, in order. Once they
+// have all been used, requests get the default response.
+func (e *RecordingEndpoint) Enqueue(responses ...Response) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.responses = append(e.responses, responses...)
+}
+
+// SetDefault sets the response of requests for which no response was
+// enqueued. Defaults to an empty 200 response.
+func (e *RecordingEndpoint) SetDefault(resp Response) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.defaultResponse = resp
+}
+
+// Requests returns the requests received so far, in order.
+func (e *RecordingEndpoint) Requests() []*RecordedRequest {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	requests := make([]*RecordedRequest, len(e.requests))
+	copy(requests, e.requests)
+
+	return requests
+}
+
+// Count returns the number of requests received so far.
+func (e *RecordingEndpoint) Count() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return len(e.requests)
+}
+
+// Last returns the last request received, or nil if there is none.
+func (e *RecordingEndpoint) Last() *RecordedRequest {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.requests) == 0 {
+		return nil
+	}
+
+	return e.requests[len(e.requests)-1]
+}
+
+// Reset forgets the received requests and the enqueued responses.
+func (e *RecordingEndpoint) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.requests = nil
+	e.responses = nil
+}
+
+// WaitForRequests waits until the endpoint received at least n requests and
+// returns whether it did before the timeout.
+func (e *RecordingEndpoint) WaitForRequests(n int, timeout time.Duration) bool {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		e.mu.Lock()
+		count := len(e.requests)
+		received := e.received
+		e.mu.Unlock()
+
+		if count >= n {
+			return true
+		}
+
+		select {
+		case <-received:
+		case <-deadline.C:
+			return false
+		}
+	}
+}
+
+//
+// Public functions
+//
+
+// NewRecordingEndpoint starts a RecordingEndpoint. It must be closed once
+// done.
+func NewRecordingEndpoint() *RecordingEndpoint {
+	e := &RecordingEndpoint{
+		received: make(chan struct{}),
+	}
+	e.server = httptest.NewServer(http.HandlerFunc(e.serveHTTP))
+	e.URL = e.server.URL
+
+	return e
+}
+
+//
+// Private types
+//
+
+type event struct {
+	Type string `json:"type"`
+}
+
+//
+// Private functions
+//
+
+func (e *RecordingEndpoint) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	var evt event
+	json.Unmarshal(body, &evt) // #nosec G104
+
+	resp := e.record(&RecordedRequest{
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Header:    r.Header.Clone(),
+		Body:      body,
+		EventType: evt.Type,
+		Received:  time.Now(),
+	})
+
+	if resp.Delay > 0 {
+		select {
+		case <-time.After(resp.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if resp.CloseConnection {
+		if hijacker, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hijacker.Hijack(); err == nil {
+				conn.Close() // #nosec G104
+				return
+			}
+		}
+	}
+
+	for k, values := range resp.Header {
+		for _, v := range values {
+			w.Header().Add(k, v)
+		}
+	}
+
+	statusCode := resp.StatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+	w.WriteHeader(statusCode)
+	w.Write([]byte(resp.Body)) // #nosec G104
+}
+
+// record saves the request, wakes up the waiters and returns the response
+// to send.
+func (e *RecordingEndpoint) record(req *RecordedRequest) Response {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.requests = append(e.requests, req)
+	close(e.received)
+	e.received = make(chan struct{})
+
+	if len(e.responses) == 0 {
+		return e.defaultResponse
+	}
+
+	resp := e.responses[0]
+	e.responses = e.responses[1:]
+
+	return resp
+}
@@ -0,0 +1,96 @@
+package proxytest
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordingEndpoint(t *testing.T) {
+	endpoint := NewRecordingEndpoint()
+	defer endpoint.Close()
+
+	endpoint.Enqueue(Response{
+		StatusCode: http.StatusInternalServerError,
+		Header:     http.Header{"X-Attempt": []string{"1"}},
+		Body:       "try again",
+	})
+
+	req, err := http.NewRequest(http.MethodPost, endpoint.URL+"/webhooks", strings.NewReader(`{"id":"evt_123","type":"invoice.paid"}`))
+	require.Nil(t, err)
+	req.Header.Set("Stripe-Signature", "t=123,v1=abc")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.Nil(t, err)
+	require.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	require.Equal(t, "1", resp.Header.Get("X-Attempt"))
+	require.Equal(t, "try again", string(body))
+
+	// Once the scripted responses are used, requests get the default one
+	resp, err = http.Post(endpoint.URL+"/webhooks", "application/json", strings.NewReader("not an event"))
+	require.Nil(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	require.Equal(t, 2, endpoint.Count())
+
+	first := endpoint.Requests()[0]
+	require.Equal(t, http.MethodPost, first.Method)
+	require.Equal(t, "/webhooks", first.Path)
+	require.Equal(t, "t=123,v1=abc", first.Header.Get("Stripe-Signature"))
+	require.Equal(t, "invoice.paid", first.EventType)
+
+	last := endpoint.Last()
+	require.Equal(t, "not an event", string(last.Body))
+	require.Equal(t, "", last.EventType)
+
+	endpoint.Reset()
+	require.Equal(t, 0, endpoint.Count())
+	require.Nil(t, endpoint.Last())
+}
+
+func TestRecordingEndpointFailures(t *testing.T) {
+	endpoint := NewRecordingEndpoint()
+	defer endpoint.Close()
+
+	endpoint.SetDefault(Response{CloseConnection: true})
+
+	_, err := http.Post(endpoint.URL, "application/json", strings.NewReader("{}"))
+	require.NotNil(t, err)
+
+	endpoint.Enqueue(Response{Delay: time.Second})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, strings.NewReader("{}"))
+	require.Nil(t, err)
+
+	_, err = http.DefaultClient.Do(req)
+	require.NotNil(t, err)
+	require.Equal(t, 2, endpoint.Count())
+}
+
+func TestRecordingEndpointWaitForRequests(t *testing.T) {
+	endpoint := NewRecordingEndpoint()
+	defer endpoint.Close()
+
+	go func() {
+		for i := 0; i < 3; i++ {
+			resp, err := http.Post(endpoint.URL, "application/json", strings.NewReader("{}"))
+			if err == nil {
+				resp.Body.Close()
+			}
+		}
+	}()
+
+	require.True(t, endpoint.WaitForRequests(3, time.Second))
+	require.False(t, endpoint.WaitForRequests(4, 10*time.Millisecond))
+}
@@ -0,0 +1,269 @@
+package proxy
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//
+// Private types
+//
+
+// rateLimiter throttles the requests of an EndpointClient with a token bucket
+// and caps the number of requests in flight.
+type rateLimiter struct {
+	// rate is the number of tokens added to the bucket per second. Zero
+	// disables the token bucket.
+	rate  float64
+	burst float64
+	clock Clock
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+
+	// slots holds a value for every request in flight. It is nil when the
+	// number of requests in flight isn't capped.
+	slots chan struct{}
+
+	// waiting is the number of requests blocked in acquire
+	waiting int32
+}
+
+// acquire blocks until the request may be sent, or until the context is done.
+// The slot of the request is taken before its token, so that a request whose
+// context is done while it waits for a slot doesn't use up a token. Every
+// successful call must be followed by a call to release.
+func (l *rateLimiter) acquire(ctx context.Context) error {
+	if l.rate <= 0 && l.slots == nil {
+		return nil
+	}
+
+	atomic.AddInt32(&l.waiting, 1)
+	defer atomic.AddInt32(&l.waiting, -1)
+
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if l.rate > 0 {
+		if err := sleepContext(ctx, l.clock, l.reserve()); err != nil {
+			l.cancelReservation()
+			l.release()
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (l *rateLimiter) release() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// cancel releases a request that acquire let through but that wasn't sent,
+// giving its token back.
+func (l *rateLimiter) cancel() {
+	if l.rate > 0 {
+		l.cancelReservation()
+	}
+	l.release()
+}
+
+func (l *rateLimiter) queueDepth() int {
+	return int(atomic.LoadInt32(&l.waiting))
+}
+
+// reserve takes a token from the bucket, possibly going into debt, and
+// returns how long to wait until the token is actually available.
+func (l *rateLimiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// cancelReservation gives back a token that was reserved but not used.
+func (l *rateLimiter) cancelReservation() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.tokens++
+}
+
+// hostLimiter caps the number of requests in flight to every host. It is nil
+// when the number of requests per host isn't capped.
+type hostLimiter struct {
+	size int
+
+	mu    sync.Mutex
+	slots map[string]chan struct{}
+}
+
+// acquire blocks until a request may be sent to the host, or until the
+// context is done. Every successful call must be followed by a call to
+// release.
+func (l *hostLimiter) acquire(ctx context.Context, host string) error {
+	if l == nil {
+		return nil
+	}
+
+	select {
+	case l.hostSlots(host) <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *hostLimiter) release(host string) {
+	if l == nil {
+		return
+	}
+
+	<-l.hostSlots(host)
+}
+
+func (l *hostLimiter) hostSlots(host string) chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	slots, ok := l.slots[host]
+	if !ok {
+		slots = make(chan struct{}, l.size)
+		l.slots[host] = slots
+	}
+
+	return slots
+}
+
+// byteLimiter caps the total size of the request bodies in flight. It is nil
+// when the size isn't capped.
+type byteLimiter struct {
+	limit int64
+
+	mu       sync.Mutex
+	inFlight int64
+
+	// released is closed and replaced every time bytes are released, to
+	// wake up the requests waiting in acquire
+	released chan struct{}
+}
+
+// acquire blocks until a request body of the given size fits under the
+// limit, or until the context is done. A body larger than the limit is let
+// through once nothing else is in flight. Every successful call must be
+// followed by a call to release with the same size.
+func (l *byteLimiter) acquire(ctx context.Context, size int64) error {
+	if l == nil || size <= 0 {
+		return nil
+	}
+
+	for {
+		l.mu.Lock()
+		if l.inFlight == 0 || l.inFlight+size <= l.limit {
+			l.inFlight += size
+			l.mu.Unlock()
+			return nil
+		}
+		released := l.released
+		l.mu.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (l *byteLimiter) release(size int64) {
+	if l == nil || size <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight -= size
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+//
+// Private functions
+//
+
+func newRateLimiter(rate float64, burst int, maxInFlight int, clock Clock) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+
+	l := &rateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		clock:  clock,
+		tokens: float64(burst),
+	}
+	if maxInFlight > 0 {
+		l.slots = make(chan struct{}, maxInFlight)
+	}
+
+	return l
+}
+
+func newHostLimiter(size int) *hostLimiter {
+	if size <= 0 {
+		return nil
+	}
+
+	return &hostLimiter{
+		size:  size,
+		slots: make(map[string]chan struct{}),
+	}
+}
+
+func newByteLimiter(limit int64) *byteLimiter {
+	if limit <= 0 {
+		return nil
+	}
+
+	return &byteLimiter{
+		limit:    limit,
+		released: make(chan struct{}),
+	}
+}
+
+// targetHost returns the host of the URL, or the URL itself if it has none,
+// e.g. for Unix domain sockets.
+func targetHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+
+	return u.Host
+}
@@ -0,0 +1,285 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/stripe-cli/pkg/proxy/proxytest"
+)
+
+func TestRateLimiterTokenBucket(t *testing.T) {
+	limiter := newRateLimiter(100, 2, 0, realClock{})
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		require.Nil(t, limiter.acquire(context.Background()))
+		limiter.release()
+	}
+
+	// The first 2 requests are let through immediately, the next 2 have to
+	// wait for 10ms each
+	require.True(t, time.Since(start) >= 15*time.Millisecond)
+}
+
+func TestRateLimiterCanceled(t *testing.T) {
+	limiter := newRateLimiter(0, 0, 1, realClock{})
+	require.Nil(t, limiter.acquire(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, limiter.acquire(ctx))
+
+	limiter.release()
+	require.Nil(t, limiter.acquire(context.Background()))
+}
+
+func TestRateLimiterCanceledKeepsToken(t *testing.T) {
+	clock := proxytest.NewFakeClock(time.Date(2019, 9, 1, 12, 0, 0, 0, time.UTC))
+	limiter := newRateLimiter(1, 1, 1, clock)
+	require.Nil(t, limiter.acquire(context.Background()))
+	clock.Advance(time.Second)
+
+	// The request waiting for the slot gives up without taking the token
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error, 1)
+	go func() { canceled <- limiter.acquire(ctx) }()
+	for limiter.queueDepth() == 0 {
+		runtime.Gosched()
+	}
+	cancel()
+	require.Equal(t, context.Canceled, <-canceled)
+	limiter.release()
+
+	acquired := make(chan error, 1)
+	go func() { acquired <- limiter.acquire(context.Background()) }()
+	select {
+	case err := <-acquired:
+		require.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("the token was used by the canceled request")
+	}
+	limiter.release()
+}
+
+func TestPostMaxInFlight(t *testing.T) {
+	var inFlight, maxInFlight int32
+	arrived := make(chan struct{}, 8)
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		arrived <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		MaxInFlight: 2,
+	})
+	require.Nil(t, err)
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client.Post("wh_123", "{}", map[string]string{})
+		}()
+	}
+
+	// While two requests are held by the endpoint, the others queue up
+	<-arrived
+	<-arrived
+	deadline := time.After(time.Second)
+	for client.QueueDepth() < 6 {
+		select {
+		case <-deadline:
+			t.Fatalf("expected 6 queued requests, got %d", client.QueueDepth())
+		default:
+			runtime.Gosched()
+		}
+	}
+	require.Len(t, arrived, 0)
+
+	close(release)
+	wg.Wait()
+
+	require.Equal(t, int32(2), atomic.LoadInt32(&maxInFlight))
+	require.Equal(t, 0, client.QueueDepth())
+}
+
+func TestPostMaxConcurrentPerHost(t *testing.T) {
+	arrived := make(chan struct{}, 8)
+	release := make(chan struct{})
+	newServer := func(maxInFlight *int32) *httptest.Server {
+		var inFlight int32
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := atomic.AddInt32(&inFlight, 1)
+			defer atomic.AddInt32(&inFlight, -1)
+			for {
+				max := atomic.LoadInt32(maxInFlight)
+				if n <= max || atomic.CompareAndSwapInt32(maxInFlight, max, n) {
+					break
+				}
+			}
+			arrived <- struct{}{}
+			<-release
+			w.WriteHeader(http.StatusOK)
+		}))
+	}
+
+	var maxInFlightA, maxInFlightB int32
+	a := newServer(&maxInFlightA)
+	defer a.Close()
+	b := newServer(&maxInFlightB)
+	defer b.Close()
+
+	client, err := NewWeightedEndpointClient([]WeightedTarget{
+		{URL: a.URL, Weight: 1},
+		{URL: b.URL, Weight: 1},
+	}, false, []string{"*"}, &EndpointConfig{
+		MaxConcurrentPerHost: 1,
+	})
+	require.Nil(t, err)
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client.Post("wh_123", "{}", map[string]string{})
+		}()
+	}
+
+	// Each host holds a single request while the others wait for a slot
+	<-arrived
+	<-arrived
+	select {
+	case <-arrived:
+		require.Fail(t, "more than one request in flight to a host")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	wg.Wait()
+
+	require.Equal(t, int32(1), atomic.LoadInt32(&maxInFlightA))
+	require.Equal(t, int32(1), atomic.LoadInt32(&maxInFlightB))
+}
+
+func TestHostLimiterCanceled(t *testing.T) {
+	limiter := newHostLimiter(1)
+	require.Nil(t, limiter.acquire(context.Background(), "localhost:3000"))
+
+	// Other hosts have their own slots
+	require.Nil(t, limiter.acquire(context.Background(), "localhost:4000"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, limiter.acquire(ctx, "localhost:3000"))
+
+	limiter.release("localhost:3000")
+	require.Nil(t, limiter.acquire(context.Background(), "localhost:3000"))
+}
+
+func TestPostMaxInFlightBytes(t *testing.T) {
+	var inFlightBytes, maxInFlightBytes int64
+	arrived := make(chan struct{}, 8)
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&inFlightBytes, r.ContentLength)
+		defer atomic.AddInt64(&inFlightBytes, -r.ContentLength)
+		for {
+			max := atomic.LoadInt64(&maxInFlightBytes)
+			if n <= max || atomic.CompareAndSwapInt64(&maxInFlightBytes, max, n) {
+				break
+			}
+		}
+		arrived <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		MaxInFlightBytes: 250,
+	})
+	require.Nil(t, err)
+
+	body := `{"data":"` + strings.Repeat("a", 89) + `"}`
+	require.Len(t, body, 100)
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client.Post("wh_123", body, map[string]string{})
+		}()
+	}
+
+	// Two events fit in the limit, the third one waits for their bytes
+	<-arrived
+	<-arrived
+	select {
+	case <-arrived:
+		require.Fail(t, "more bytes in flight than the limit")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	wg.Wait()
+
+	require.Equal(t, int64(200), atomic.LoadInt64(&maxInFlightBytes))
+}
+
+func TestByteLimiter(t *testing.T) {
+	limiter := newByteLimiter(100)
+	require.Nil(t, limiter.acquire(context.Background(), 60))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, limiter.acquire(ctx, 60))
+
+	acquired := make(chan struct{})
+	go func() {
+		require.Nil(t, limiter.acquire(context.Background(), 60))
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		require.Fail(t, "acquired over the limit")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	limiter.release(60)
+	<-acquired
+	limiter.release(60)
+
+	// Bodies larger than the limit go through alone
+	require.Nil(t, limiter.acquire(context.Background(), 1000))
+	limiter.release(1000)
+	require.Equal(t, int64(0), limiter.inFlight)
+
+	// A nil limiter doesn't cap anything
+	var unlimited *byteLimiter
+	require.Nil(t, unlimited.acquire(context.Background(), 1000))
+	unlimited.release(1000)
+}
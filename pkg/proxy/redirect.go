@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//
+// Private constants
+//
+
+const defaultMaxRedirects = 10
+
+//
+// Private functions
+//
+
+// followRedirects follows the 307 and 308 redirects of the endpoint when
+// FollowRedirects is set, sending the same request with its body to their
+// location. It returns the response of the last request, which is a redirect
+// if it wasn't followed.
+func (c *EndpointClient) followRedirects(req *http.Request, resp *http.Response, d *delivery) (*http.Response, error) {
+	for hops := 0; c.cfg.FollowRedirects && isPreservingRedirect(resp.StatusCode); hops++ {
+		location, err := resp.Location()
+		if err != nil {
+			return resp, nil
+		}
+
+		fields := d.logFields(log.Fields{
+			"status":   resp.StatusCode,
+			"location": location.String(),
+		})
+		if hops >= c.maxRedirects() {
+			c.cfg.Log.WithFields(fields).Warnf("Not following redirect, the endpoint redirected more than %d times", c.maxRedirects())
+			return resp, nil
+		}
+		if !d.rewindable() {
+			c.cfg.Log.WithFields(fields).Warn("Not following redirect, the body of the event can't be sent again")
+			return resp, nil
+		}
+
+		body, err := d.requestBody()
+		if err != nil {
+			c.discardResponse(resp)
+			return nil, err
+		}
+		c.discardResponse(resp)
+
+		c.cfg.Log.WithFields(fields).Debug("Following redirect")
+
+		next := req.Clone(req.Context())
+		next.URL = location
+		next.Host = ""
+		next.Body = ioutil.NopCloser(body)
+		next.GetBody = nil
+		if location.Host != req.URL.Host {
+			// Credentials aren't sent to other hosts, as with the redirects
+			// followed by http.Client
+			next.Header.Del("Authorization")
+			next.Header.Del("Cookie")
+		}
+		if c.cfg.DumpTraffic {
+			c.dumpRequest(next, d.body)
+		}
+
+		req = next
+		if resp, err = c.cfg.HTTPClient.Do(req); err != nil {
+			return nil, err
+		}
+	}
+
+	return resp, nil
+}
+
+func (c *EndpointClient) maxRedirects() int {
+	if c.cfg.MaxRedirects > 0 {
+		return c.cfg.MaxRedirects
+	}
+
+	return defaultMaxRedirects
+}
+
+// redirectError returns the error of a redirect that wasn't followed, or nil
+// if the response isn't a redirect.
+func redirectError(resp *http.Response) error {
+	if resp.StatusCode < 300 || resp.StatusCode > 399 || resp.Header.Get("Location") == "" {
+		return nil
+	}
+
+	return &RedirectError{Code: resp.StatusCode, Location: resp.Header.Get("Location")}
+}
+
+// isPreservingRedirect returns whether the redirect preserves the method and
+// the body of the request.
+func isPreservingRedirect(statusCode int) bool {
+	return statusCode == http.StatusTemporaryRedirect || statusCode == http.StatusPermanentRedirect
+}
+
+// noRedirects stops http.Client from following redirects, so that the
+// client follows them as configured by FollowRedirects.
+func noRedirects(*http.Request, []*http.Request) error {
+	return http.ErrUseLastResponse
+}
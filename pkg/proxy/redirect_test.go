@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostFollowsRedirect(t *testing.T) {
+	var body string
+	var authorization string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/new", http.StatusTemporaryRedirect)
+		case "/moved":
+			http.Redirect(w, r, "/new", http.StatusPermanentRedirect)
+		case "/new":
+			require.Equal(t, http.MethodPost, r.Method)
+			buf, err := ioutil.ReadAll(r.Body)
+			require.Nil(t, err)
+			body = string(buf)
+			authorization = r.Header.Get("Authorization")
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer ts.Close()
+
+	for _, path := range []string{"/old", "/moved"} {
+		body = ""
+		var statusCode int
+		client, err := NewEndpointClient(ts.URL+path, false, []string{"*"}, &EndpointConfig{
+			FollowRedirects: true,
+			ResponseHandler: EndpointResponseHandlerFunc(func(_ string, resp *http.Response) {
+				statusCode = resp.StatusCode
+			}),
+		})
+		require.Nil(t, err)
+
+		err = client.Post("wh_1", `{"id":"evt_1"}`, map[string]string{"Authorization": "Bearer token"})
+		require.Nil(t, err)
+		require.Equal(t, `{"id":"evt_1"}`, body)
+		require.Equal(t, "Bearer token", authorization)
+		require.Equal(t, http.StatusCreated, statusCode)
+	}
+}
+
+func TestPostRedirectToOtherHost(t *testing.T) {
+	var authorization string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer other.Close()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, other.URL, http.StatusTemporaryRedirect)
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		FollowRedirects: true,
+		ResponseHandler: EndpointResponseHandlerFunc(func(string, *http.Response) {}),
+	})
+	require.Nil(t, err)
+
+	err = client.Post("wh_1", `{"id":"evt_1"}`, map[string]string{"Authorization": "Bearer token"})
+	require.Nil(t, err)
+	require.Equal(t, "", authorization)
+}
+
+func TestPostRedirectError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/temporary":
+			http.Redirect(w, r, "/new", http.StatusTemporaryRedirect)
+		case "/found":
+			http.Redirect(w, r, "/new", http.StatusFound)
+		}
+	}))
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		path            string
+		followRedirects bool
+		statusCode      int
+	}{
+		{"/temporary", false, http.StatusTemporaryRedirect},
+		{"/found", false, http.StatusFound},
+		{"/found", true, http.StatusFound},
+	} {
+		var handled int
+		var errs int
+		client, err := NewEndpointClient(ts.URL+tc.path, false, []string{"*"}, &EndpointConfig{
+			FollowRedirects: tc.followRedirects,
+			ResponseHandler: EndpointResponseHandlerFunc(func(_ string, resp *http.Response) {
+				handled = resp.StatusCode
+			}),
+			OnError: func(string, string, error) { errs++ },
+		})
+		require.Nil(t, err)
+
+		err = client.Post("wh_1", `{"id":"evt_1"}`, map[string]string{})
+		var redirectErr *RedirectError
+		require.True(t, errors.As(err, &redirectErr))
+		require.Equal(t, tc.statusCode, redirectErr.Code)
+		require.Equal(t, "/new", redirectErr.Location)
+		require.Equal(t, tc.statusCode, handled)
+		require.Equal(t, 1, errs)
+	}
+}
+
+func TestPostMaxRedirects(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Redirect(w, r, "/loop", http.StatusTemporaryRedirect)
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		FollowRedirects: true,
+		MaxRedirects:    3,
+		ResponseHandler: EndpointResponseHandlerFunc(func(string, *http.Response) {}),
+	})
+	require.Nil(t, err)
+
+	err = client.Post("wh_1", `{"id":"evt_1"}`, map[string]string{})
+	var redirectErr *RedirectError
+	require.True(t, errors.As(err, &redirectErr))
+	require.Equal(t, 4, requests)
+}
@@ -0,0 +1,211 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//
+// Private constants
+//
+
+// remoteCheckTimeout bounds the resolution of the hosts of the targets when
+// checking whether they are remote
+const remoteCheckTimeout = 2 * time.Second
+
+//
+// Private variables
+//
+
+// privateNetworks are the address ranges of private networks, to which
+// forwarding is considered local, e.g. to a container or a VM
+var privateNetworks = mustParseCIDRs(
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"100.64.0.0/10",
+	"fc00::/7",
+)
+
+//
+// Private functions
+//
+
+// warnIfRemote logs a warning if the host of a target resolves to an address
+// that is neither a loopback nor a private address, since forwarding test
+// events to a public server is usually a mistake. It is silenced by
+// AllowRemote. Hosts that can't be resolved aren't reported. It is called
+// once when the client is created. Hosts that are IP addresses or localhost
+// are checked right away, and only the others are resolved in a background
+// goroutine, with its own timeout so that requests never wait for the
+// resolution, which is abandoned when the client is closed. remoteChecked
+// is closed once all the hosts were checked.
+func (c *EndpointClient) warnIfRemote() {
+	if c.cfg.AllowRemote {
+		close(c.remoteChecked)
+		return
+	}
+
+	urls := make([]string, 0, len(c.targets.targets)+1)
+	for _, t := range c.targets.targets {
+		urls = append(urls, t.url)
+	}
+	if c.batchTarget != nil {
+		urls = append(urls, c.batchTarget.url)
+	}
+
+	prefix := "proxy.EndpointClient.warnIfRemote"
+	unresolved := warnIfRemoteIPs(c.cfg.Log, prefix, urls)
+	if len(unresolved) == 0 {
+		close(c.remoteChecked)
+		return
+	}
+
+	c.background.Add(1)
+	go func() {
+		defer c.background.Done()
+		defer close(c.remoteChecked)
+
+		warnIfRemoteHosts(c.backgroundCtx, c.cfg.Log, prefix, unresolved)
+	}()
+}
+
+// warnIfRemote is like EndpointClient.warnIfRemote for the URL of a
+// WebSocketEndpointClient.
+func (c *WebSocketEndpointClient) warnIfRemote() {
+	if c.cfg.AllowRemote {
+		close(c.remoteChecked)
+		return
+	}
+
+	prefix := "proxy.WebSocketEndpointClient.warnIfRemote"
+	unresolved := warnIfRemoteIPs(c.cfg.Log, prefix, []string{c.URL})
+	if len(unresolved) == 0 {
+		close(c.remoteChecked)
+		return
+	}
+
+	go func() {
+		defer close(c.remoteChecked)
+
+		warnIfRemoteHosts(c.backgroundCtx, c.cfg.Log, prefix, unresolved)
+	}()
+}
+
+// warnIfRemoteIPs logs the warning of warnIfRemote for every URL whose host
+// is a remote IP address, and returns the URLs whose host must be resolved
+// to be checked. Unix domain sockets and localhost are always local.
+func warnIfRemoteIPs(logger *log.Logger, prefix string, urls []string) []string {
+	var unresolved []string
+	for _, u := range urls {
+		if strings.HasPrefix(u, unixSocketScheme) {
+			continue
+		}
+
+		parsed, err := url.Parse(u)
+		if err != nil {
+			continue
+		}
+		host := parsed.Hostname()
+		if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+			continue
+		}
+
+		ip := net.ParseIP(host)
+		if ip == nil {
+			unresolved = append(unresolved, u)
+			continue
+		}
+		if !isLocalIP(ip) {
+			warnRemote(logger, prefix, u, ip.String())
+		}
+	}
+
+	return unresolved
+}
+
+// warnIfRemoteHosts logs the warning of warnIfRemote for every URL whose host
+// resolves to a remote address, giving up on the resolution after
+// remoteCheckTimeout or once the context is done.
+func warnIfRemoteHosts(ctx context.Context, logger *log.Logger, prefix string, urls []string) {
+	ctx, cancel := context.WithTimeout(ctx, remoteCheckTimeout)
+	defer cancel()
+
+	for _, u := range urls {
+		addr, err := remoteAddr(ctx, u)
+		if err != nil {
+			logger.WithFields(log.Fields{
+				"prefix": prefix,
+				"url":    u,
+			}).Debugf("Couldn't check whether the endpoint is remote, error = %v", err)
+			continue
+		}
+		if addr != "" {
+			warnRemote(logger, prefix, u, addr)
+		}
+	}
+}
+
+func warnRemote(logger *log.Logger, prefix string, u string, addr string) {
+	logger.WithFields(log.Fields{
+		"prefix":  prefix,
+		"url":     u,
+		"address": addr,
+	}).Warn("Forwarding events to a remote address, not to this machine or a private network. Set AllowRemote if this is intended")
+}
+
+// remoteAddr resolves the host of the URL and returns the first of its
+// addresses that isn't local, or an empty string if they all are.
+func remoteAddr(ctx context.Context, rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return "", err
+	}
+
+	for _, addr := range addrs {
+		if !isLocalIP(addr.IP) {
+			return addr.IP.String(), nil
+		}
+	}
+
+	return "", nil
+}
+
+// isLocalIP returns whether the address is a loopback, link-local or private
+// address.
+func isLocalIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+		return true
+	}
+
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks
+}
@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostWarnsRemote(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New()
+	logger.Out = &buf
+
+	// Dry runs don't send anything to the documentation address
+	client, err := NewEndpointClient("http://203.0.113.10/webhooks", false, []string{"*"}, &EndpointConfig{
+		DryRun: true,
+		Log:    logger,
+	})
+	require.Nil(t, err)
+
+	require.Nil(t, client.Post("wh_1", `{"id":"evt_1"}`, map[string]string{}))
+	require.Nil(t, client.Post("wh_2", `{"id":"evt_2"}`, map[string]string{}))
+	<-client.remoteChecked
+	require.Contains(t, buf.String(), "203.0.113.10")
+	require.Equal(t, 1, strings.Count(buf.String(), "Set AllowRemote"))
+
+	buf.Reset()
+	client, err = NewEndpointClient("http://203.0.113.10/webhooks", false, []string{"*"}, &EndpointConfig{
+		DryRun:      true,
+		AllowRemote: true,
+		Log:         logger,
+	})
+	require.Nil(t, err)
+
+	require.Nil(t, client.Post("wh_1", `{"id":"evt_1"}`, map[string]string{}))
+	<-client.remoteChecked
+	require.NotContains(t, buf.String(), "AllowRemote")
+}
+
+func TestPostDoesNotWarnLocal(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	logger := log.New()
+	logger.Out = &buf
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		Log: logger,
+	})
+	require.Nil(t, err)
+
+	require.Nil(t, client.Post("wh_1", `{"id":"evt_1"}`, map[string]string{}))
+	<-client.remoteChecked
+	require.NotContains(t, buf.String(), "AllowRemote")
+}
+
+func TestWarnIfRemoteWithoutResolution(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New()
+	logger.Out = &buf
+
+	// IP addresses and localhost are checked without starting a goroutine
+	for _, u := range []string{"http://203.0.113.10/webhooks", "http://127.0.0.1:4242", "http://localhost:4242"} {
+		client, err := NewEndpointClient(u, false, []string{"*"}, &EndpointConfig{
+			Log: logger,
+		})
+		require.Nil(t, err)
+
+		select {
+		case <-client.remoteChecked:
+		default:
+			t.Fatalf("%s wasn't checked right away", u)
+		}
+		client.Close(context.Background())
+	}
+	require.Equal(t, 1, strings.Count(buf.String(), "Set AllowRemote"))
+	require.Contains(t, buf.String(), "203.0.113.10")
+}
+
+func TestIsLocalIP(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1", "::1", "10.1.2.3", "172.17.0.2", "192.168.1.10", "169.254.1.1", "fd00::1", "0.0.0.0"} {
+		require.True(t, isLocalIP(net.ParseIP(addr)), addr)
+	}
+	for _, addr := range []string{"203.0.113.10", "8.8.8.8", "172.32.0.1", "2001:db8::1"} {
+		require.False(t, isLocalIP(net.ParseIP(addr)), addr)
+	}
+}
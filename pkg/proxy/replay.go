@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//
+// Public functions
+//
+
+// ReplayStream posts the events one after the other, preserving the delays
+// between their timestamps scaled down by speed, e.g. a speed of 2 replays
+// the events twice as fast as they were recorded. Every event is scheduled
+// relative to the start of the replay, so that slow deliveries don't shift
+// the events that follow. Events that fail to be delivered don't stop the
+// replay. It returns the number of delivered events.
+func (c *EndpointClient) ReplayStream(ctx context.Context, events []RecordedEvent, speed float64) (int, error) {
+	if speed <= 0 {
+		return 0, errors.New("the replay speed must be positive")
+	}
+
+	delivered := 0
+	start := c.clock.Now()
+
+	for i, evt := range events {
+		if i > 0 {
+			offset := time.Duration(float64(evt.Timestamp.Sub(events[0].Timestamp)) / speed)
+			if err := sleepContext(ctx, c.clock, start.Add(offset).Sub(c.clock.Now())); err != nil {
+				return delivered, err
+			}
+		}
+
+		if _, err := c.post(ctx, evt.WebhookID, evt.Body, evt.Headers); err != nil {
+			c.cfg.Log.WithFields(log.Fields{
+				"prefix":     "proxy.EndpointClient.ReplayStream",
+				"webhook_id": evt.WebhookID,
+			}).Debugf("Failed to replay event, error = %v", err)
+			if ctx.Err() != nil {
+				return delivered, ctx.Err()
+			}
+			continue
+		}
+		delivered++
+	}
+
+	return delivered, nil
+}
+
+// ReadRecordedEvents reads the events of a file written in the format of the
+// dead letter files, e.g. to replay them with ReplayStream.
+func ReadRecordedEvents(path string) ([]RecordedEvent, error) {
+	lines, err := readLines(path)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]RecordedEvent, 0, len(lines))
+	for _, line := range lines {
+		var evt RecordedEvent
+		if err := json.Unmarshal(line, &evt); err != nil {
+			return nil, err
+		}
+		events = append(events, evt)
+	}
+
+	return events, nil
+}
@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/stripe-cli/pkg/proxy/proxytest"
+)
+
+func TestReplayStream(t *testing.T) {
+	recorded := time.Date(2019, 9, 1, 12, 0, 0, 0, time.UTC)
+	clock := proxytest.NewFakeClock(recorded)
+
+	received := make(chan time.Duration, 3)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- clock.Now().Sub(recorded)
+		if r.Header.Get("Fail") != "" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		FailOnNon2xx: true,
+		Clock:        clock,
+	})
+	require.Nil(t, err)
+
+	events := []RecordedEvent{
+		{WebhookID: "wh_1", Body: "{}", Timestamp: recorded},
+		{WebhookID: "wh_2", Body: "{}", Headers: map[string]string{"Fail": "1"}, Timestamp: recorded.Add(100 * time.Millisecond)},
+		{WebhookID: "wh_3", Body: "{}", Timestamp: recorded.Add(400 * time.Millisecond)},
+	}
+
+	type result struct {
+		delivered int
+		err       error
+	}
+	done := make(chan result, 1)
+	go func() {
+		delivered, err := client.ReplayStream(context.Background(), events, 2)
+		done <- result{delivered, err}
+	}()
+
+	// The first event is sent right away, and the others once the clock
+	// reaches their offsets scaled down by the speed
+	require.Equal(t, time.Duration(0), <-received)
+
+	require.True(t, clock.WaitForWaiters(1, time.Second))
+	clock.Advance(49 * time.Millisecond)
+	require.Equal(t, 1, clock.Waiters())
+	clock.Advance(time.Millisecond)
+	require.Equal(t, 50*time.Millisecond, <-received)
+
+	require.True(t, clock.WaitForWaiters(1, time.Second))
+	clock.Advance(150 * time.Millisecond)
+	require.Equal(t, 200*time.Millisecond, <-received)
+
+	res := <-done
+	require.Nil(t, res.err)
+	require.Equal(t, 2, res.delivered)
+}
+
+func TestReplayStreamCanceled(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	recorded := time.Date(2019, 9, 1, 12, 0, 0, 0, time.UTC)
+	clock := proxytest.NewFakeClock(recorded)
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		Clock: clock,
+	})
+	require.Nil(t, err)
+
+	events := []RecordedEvent{
+		{WebhookID: "wh_1", Body: "{}", Timestamp: recorded},
+		{WebhookID: "wh_2", Body: "{}", Timestamp: recorded.Add(time.Hour)},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The replay is canceled while it waits for the second event
+	go func() {
+		if clock.WaitForWaiters(1, time.Second) {
+			cancel()
+		}
+	}()
+
+	delivered, err := client.ReplayStream(ctx, events, 1)
+	require.Equal(t, context.Canceled, err)
+	require.Equal(t, 1, delivered)
+
+	_, err = client.ReplayStream(context.Background(), events, 0)
+	require.NotNil(t, err)
+}
+
+func TestReadRecordedEvents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stripe-cli-proxy")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "events.jsonl")
+	require.Nil(t, ioutil.WriteFile(path, []byte(
+		`{"webhook_id":"wh_1","body":"{}","timestamp":"2019-09-01T12:00:00Z"}`+"\n"+
+			`{"webhook_id":"wh_2","body":"{}","timestamp":"2019-09-01T12:00:01Z"}`+"\n",
+	), 0600))
+
+	events, err := ReadRecordedEvents(path)
+	require.Nil(t, err)
+	require.Len(t, events, 2)
+	require.Equal(t, "wh_2", events[1].WebhookID)
+	require.Equal(t, time.Second, events[1].Timestamp.Sub(events[0].Timestamp))
+}
@@ -0,0 +1,236 @@
+package proxy
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+//
+// Public types
+//
+
+// RetryPolicy describes how an EndpointClient retries requests that failed.
+// By default, connection errors and 5xx responses are retried. The zero value
+// disables retries. Retries of 429 and 503 responses wait at least as long as
+// their Retry-After header asks, up to MaxDelay.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one.
+	// Values lower than 2 disable retries.
+	MaxAttempts int
+
+	// Strategy is how the delay between two attempts is computed. Defaults
+	// to ExponentialBackoff.
+	Strategy BackoffStrategy
+
+	// BaseDelay is the delay before the first retry. With
+	// ExponentialBackoff, it doubles with every subsequent retry.
+	BaseDelay time.Duration
+
+	// MaxDelay caps the delay between two attempts. Zero means no cap.
+	MaxDelay time.Duration
+
+	// MinRetryAfter and MaxRetryAfter clamp the delay before every retry,
+	// whether it was computed by the strategy or asked for by the endpoint
+	// with a Retry-After header, so that a misbehaving endpoint can't make
+	// the client retry in a tight loop or stall for hours. They apply after
+	// MaxDelay, and a clamped delay is logged. Zero means no bound.
+	MinRetryAfter time.Duration
+	MaxRetryAfter time.Duration
+
+	// Jitter is the fraction of the delay, between 0 and 1, that is
+	// randomized to avoid retrying in lockstep. It isn't used by
+	// DecorrelatedJitterBackoff, which is always randomized.
+	Jitter float64
+
+	// RetryableStatusCodes, if not nil, restricts the responses that are
+	// retried to those with one of the status codes, while connection errors
+	// are always retried. An empty set, i.e. []int{}, retries only
+	// connection errors. When it is nil, connection errors and 5xx responses
+	// are retried, unless RetryOnConnectionError is set, which then retries
+	// only connection errors as an empty set does.
+	RetryableStatusCodes   []int
+	RetryOnConnectionError bool
+}
+
+// BackoffStrategy computes the delay between two attempts of a RetryPolicy.
+type BackoffStrategy int
+
+// Possible backoff strategies of a retry policy.
+const (
+	// ExponentialBackoff doubles the delay with every retry. It backs off
+	// quickly from an endpoint that is down, but events that failed at the
+	// same time are retried in lockstep unless Jitter is set.
+	ExponentialBackoff BackoffStrategy = iota
+
+	// FixedBackoff waits BaseDelay before every retry. It suits endpoints
+	// that recover quickly, but doesn't lighten the load of an endpoint that
+	// is struggling.
+	FixedBackoff
+
+	// DecorrelatedJitterBackoff picks every delay at random between
+	// BaseDelay and three times the previous delay. It grows like an
+	// exponential backoff while spreading out the retries of events that
+	// failed at the same time, at the cost of less predictable delays.
+	DecorrelatedJitterBackoff
+)
+
+//
+// Private functions
+//
+
+// nextDelay returns how long to wait before the given retry, starting at 1
+// for the first retry, according to the strategy. previous is the delay
+// before the previous retry, if any.
+func (p RetryPolicy) nextDelay(retry int, previous time.Duration) time.Duration {
+	if p.Strategy == DecorrelatedJitterBackoff {
+		return p.decorrelatedJitter(previous)
+	}
+
+	return p.backoff(retry)
+}
+
+// backoff returns how long to wait before the given retry with the
+// exponential or fixed strategies.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < retry && delay < math.MaxInt64/2 && p.Strategy != FixedBackoff; i++ {
+		delay *= 2
+	}
+
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	if p.Jitter > 0 {
+		jitter := math.Min(p.Jitter, 1)
+		delay -= time.Duration(rand.Float64() * jitter * float64(delay)) // #nosec G404
+	}
+
+	return delay
+}
+
+// shouldRetry returns whether the outcome of the given attempt warrants
+// another one.
+func (p RetryPolicy) shouldRetry(attempt int, resp *http.Response, err error) bool {
+	if attempt >= p.MaxAttempts {
+		return false
+	}
+
+	if err != nil {
+		return true
+	}
+
+	if p.RetryableStatusCodes == nil && !p.RetryOnConnectionError {
+		return resp.StatusCode >= http.StatusInternalServerError
+	}
+	for _, statusCode := range p.RetryableStatusCodes {
+		if resp.StatusCode == statusCode {
+			return true
+		}
+	}
+
+	return false
+}
+
+// decorrelatedJitter returns a random delay between BaseDelay and three
+// times the previous delay, capped at MaxDelay.
+func (p RetryPolicy) decorrelatedJitter(previous time.Duration) time.Duration {
+	if p.BaseDelay <= 0 {
+		return 0
+	}
+	if previous < p.BaseDelay {
+		previous = p.BaseDelay
+	}
+
+	upper := previous * 3
+	if upper < previous {
+		upper = math.MaxInt64
+	}
+	delay := p.BaseDelay + time.Duration(rand.Int63n(int64(upper-p.BaseDelay)+1)) // #nosec G404
+
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	return delay
+}
+
+// retryAfter returns the delay requested by the Retry-After header of a 429
+// or 503 response, in seconds or as an HTTP date, capped at MaxDelay.
+func (p RetryPolicy) retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+
+	var delay time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		delay = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		delay = date.Sub(now)
+		if delay < 0 {
+			delay = 0
+		}
+	} else {
+		return 0, false
+	}
+
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	return delay, true
+}
+
+// clampDelay bounds the delay before a retry by MinRetryAfter and
+// MaxRetryAfter, and returns whether it was changed.
+func (p RetryPolicy) clampDelay(delay time.Duration) (time.Duration, bool) {
+	if p.MaxRetryAfter > 0 && delay > p.MaxRetryAfter {
+		return p.MaxRetryAfter, true
+	}
+	if delay < p.MinRetryAfter {
+		return p.MinRetryAfter, true
+	}
+
+	return delay, false
+}
+
+// sleepContext waits for the given duration on the clock, or until the
+// context is done, in which case it returns the context's error.
+func sleepContext(ctx context.Context, clock Clock, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	if _, ok := clock.(realClock); ok {
+		// Unlike the channel of time.After, the timer is released as soon as
+		// the context is done
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			return nil
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-clock.After(d):
+		return nil
+	}
+}
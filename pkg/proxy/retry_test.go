@@ -0,0 +1,351 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/stripe-cli/pkg/proxy/proxytest"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+
+	require.Equal(t, 10*time.Millisecond, policy.backoff(1))
+	require.Equal(t, 20*time.Millisecond, policy.backoff(2))
+	require.Equal(t, 40*time.Millisecond, policy.backoff(3))
+	require.Equal(t, 50*time.Millisecond, policy.backoff(4))
+	require.Equal(t, 50*time.Millisecond, policy.backoff(100))
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		delay := policy.backoff(1)
+		require.True(t, delay > 5*time.Millisecond && delay <= 10*time.Millisecond)
+	}
+}
+
+func TestRetryPolicyBackoffStrategies(t *testing.T) {
+	policy := RetryPolicy{Strategy: FixedBackoff, BaseDelay: 10 * time.Millisecond}
+	require.Equal(t, 10*time.Millisecond, policy.nextDelay(1, 0))
+	require.Equal(t, 10*time.Millisecond, policy.nextDelay(5, 10*time.Millisecond))
+
+	policy = RetryPolicy{Strategy: DecorrelatedJitterBackoff, BaseDelay: 10 * time.Millisecond, MaxDelay: time.Second}
+	var delay time.Duration
+	for i := 1; i < 100; i++ {
+		previous := delay
+		if previous < policy.BaseDelay {
+			previous = policy.BaseDelay
+		}
+
+		delay = policy.nextDelay(i, delay)
+		require.True(t, delay >= policy.BaseDelay)
+		require.True(t, delay <= 3*previous)
+		require.True(t, delay <= policy.MaxDelay)
+	}
+}
+
+func TestRetryPolicyShouldRetry(t *testing.T) {
+	connErr := errors.New("connection refused")
+	status := func(code int) *http.Response {
+		return &http.Response{StatusCode: code}
+	}
+
+	policy := RetryPolicy{MaxAttempts: 3}
+	require.True(t, policy.shouldRetry(1, nil, connErr))
+	require.True(t, policy.shouldRetry(1, status(http.StatusInternalServerError), nil))
+	require.False(t, policy.shouldRetry(1, status(http.StatusConflict), nil))
+	require.False(t, policy.shouldRetry(3, nil, connErr))
+
+	policy.RetryableStatusCodes = []int{http.StatusBadGateway, http.StatusServiceUnavailable}
+	require.True(t, policy.shouldRetry(1, status(http.StatusServiceUnavailable), nil))
+	require.False(t, policy.shouldRetry(1, status(http.StatusInternalServerError), nil))
+	require.False(t, policy.shouldRetry(1, status(http.StatusOK), nil))
+	require.True(t, policy.shouldRetry(1, nil, connErr))
+
+	// Only connection errors are retried
+	policy.RetryableStatusCodes = []int{}
+	require.True(t, policy.shouldRetry(1, nil, connErr))
+	require.False(t, policy.shouldRetry(1, status(http.StatusServiceUnavailable), nil))
+
+	policy.RetryableStatusCodes = nil
+	policy.RetryOnConnectionError = true
+	require.True(t, policy.shouldRetry(1, nil, connErr))
+	require.False(t, policy.shouldRetry(1, status(http.StatusServiceUnavailable), nil))
+}
+
+func TestRetryPolicyRetryAfter(t *testing.T) {
+	policy := RetryPolicy{MaxDelay: time.Minute}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	newResponse := func(statusCode int, retryAfter string) *http.Response {
+		resp := &http.Response{StatusCode: statusCode, Header: http.Header{}}
+		resp.Header.Set("Retry-After", retryAfter)
+		return resp
+	}
+
+	delay, ok := policy.retryAfter(newResponse(http.StatusTooManyRequests, "3"), now)
+	require.True(t, ok)
+	require.Equal(t, 3*time.Second, delay)
+
+	delay, ok = policy.retryAfter(newResponse(http.StatusServiceUnavailable, now.Add(10*time.Second).Format(http.TimeFormat)), now)
+	require.True(t, ok)
+	require.Equal(t, 10*time.Second, delay)
+
+	// The delay is capped
+	delay, ok = policy.retryAfter(newResponse(http.StatusTooManyRequests, "3600"), now)
+	require.True(t, ok)
+	require.Equal(t, time.Minute, delay)
+
+	_, ok = policy.retryAfter(newResponse(http.StatusInternalServerError, "3"), now)
+	require.False(t, ok)
+	_, ok = policy.retryAfter(newResponse(http.StatusTooManyRequests, "soon"), now)
+	require.False(t, ok)
+	_, ok = policy.retryAfter(newResponse(http.StatusTooManyRequests, ""), now)
+	require.False(t, ok)
+}
+
+func TestPostHonorsRetryAfter(t *testing.T) {
+	var attempts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	clock := proxytest.NewFakeClock(time.Date(2019, 9, 1, 12, 0, 0, 0, time.UTC))
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		RetryPolicy: RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond},
+		Clock:       clock,
+	})
+	require.Nil(t, err)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Post("wh_123", "{}", map[string]string{})
+	}()
+
+	// The retry waits for the whole second instead of the base delay
+	require.True(t, clock.WaitForWaiters(1, time.Second))
+	clock.Advance(999 * time.Millisecond)
+	require.Equal(t, 1, clock.Waiters())
+	require.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+
+	clock.Advance(time.Millisecond)
+	require.Nil(t, <-done)
+	require.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+}
+
+func TestRetryPolicyClampDelay(t *testing.T) {
+	policy := RetryPolicy{MinRetryAfter: time.Second, MaxRetryAfter: time.Minute}
+
+	delay, ok := policy.clampDelay(10 * time.Second)
+	require.False(t, ok)
+	require.Equal(t, 10*time.Second, delay)
+
+	delay, ok = policy.clampDelay(time.Hour)
+	require.True(t, ok)
+	require.Equal(t, time.Minute, delay)
+
+	delay, ok = policy.clampDelay(time.Millisecond)
+	require.True(t, ok)
+	require.Equal(t, time.Second, delay)
+
+	// Zero means no bound
+	delay, ok = RetryPolicy{}.clampDelay(time.Hour)
+	require.False(t, ok)
+	require.Equal(t, time.Hour, delay)
+}
+
+func TestPostClampsRetryAfter(t *testing.T) {
+	var attempts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	logger := log.New()
+	logger.SetOutput(&buf)
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		Log:         logger,
+		RetryPolicy: RetryPolicy{MaxAttempts: 2, MaxRetryAfter: 10 * time.Millisecond},
+	})
+	require.Nil(t, err)
+
+	// The endpoint can't stall the client for an hour
+	start := time.Now()
+	require.Nil(t, client.Post("wh_123", "{}", map[string]string{}))
+	require.True(t, time.Since(start) < time.Minute)
+	require.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+	require.Contains(t, buf.String(), "Clamped retry delay of 1h0m0s to 10ms")
+}
+
+func TestPostRetriesServerErrors(t *testing.T) {
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&count, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	rcvStatus := 0
+	client, err := NewEndpointClient(
+		ts.URL,
+		false,
+		[]string{"*"},
+		&EndpointConfig{
+			RetryPolicy: RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+			ResponseHandler: EndpointResponseHandlerFunc(func(webhookID string, resp *http.Response) {
+				rcvStatus = resp.StatusCode
+			}),
+		},
+	)
+	require.Nil(t, err)
+
+	err = client.Post("wh_123", "{}", map[string]string{})
+
+	require.Nil(t, err)
+	require.Equal(t, int32(3), atomic.LoadInt32(&count))
+	require.Equal(t, http.StatusOK, rcvStatus)
+}
+
+func TestPostDoesNotRetryByDefault(t *testing.T) {
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	rcvStatus := 0
+	client, err := NewEndpointClient(
+		ts.URL,
+		false,
+		[]string{"*"},
+		&EndpointConfig{
+			ResponseHandler: EndpointResponseHandlerFunc(func(webhookID string, resp *http.Response) {
+				rcvStatus = resp.StatusCode
+			}),
+		},
+	)
+	require.Nil(t, err)
+
+	err = client.Post("wh_123", "{}", map[string]string{})
+
+	require.Nil(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&count))
+	require.Equal(t, http.StatusInternalServerError, rcvStatus)
+}
+
+func TestPostReturnsLastErrorAfterRetries(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := ts.URL
+	ts.Close()
+
+	client, err := NewEndpointClient(
+		url,
+		false,
+		[]string{"*"},
+		&EndpointConfig{
+			RetryPolicy: RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond},
+		},
+	)
+	require.Nil(t, err)
+
+	err = client.Post("wh_123", "{}", map[string]string{})
+
+	require.NotNil(t, err)
+}
+
+func TestPostRetryBackoffCanceled(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(
+		ts.URL,
+		false,
+		[]string{"*"},
+		&EndpointConfig{
+			RetryPolicy: RetryPolicy{MaxAttempts: 5, BaseDelay: time.Minute},
+		},
+	)
+	require.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err = client.PostWithContext(ctx, "wh_123", "{}", map[string]string{})
+
+	require.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestPostPerAttemptTimeout(t *testing.T) {
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&count, 1) == 1 {
+			// The request is only canceled once its body was read
+			ioutil.ReadAll(r.Body) // #nosec G104
+			<-r.Context().Done()
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	rcvStatus := 0
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		PerAttemptTimeout: 10 * time.Millisecond,
+		RetryPolicy:       RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond},
+		ResponseHandler: EndpointResponseHandlerFunc(func(webhookID string, resp *http.Response) {
+			rcvStatus = resp.StatusCode
+		}),
+	})
+	require.Nil(t, err)
+
+	// The slow first attempt times out and the retry succeeds
+	err = client.Post("wh_123", "{}", map[string]string{})
+	require.Nil(t, err)
+	require.Equal(t, http.StatusOK, rcvStatus)
+	require.Equal(t, int32(2), atomic.LoadInt32(&count))
+}
+
+func TestPostOverallTimeout(t *testing.T) {
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		OverallTimeout: 30 * time.Millisecond,
+		RetryPolicy:    RetryPolicy{MaxAttempts: 100, BaseDelay: 20 * time.Millisecond},
+	})
+	require.Nil(t, err)
+
+	start := time.Now()
+	err = client.Post("wh_123", "{}", map[string]string{})
+	require.True(t, errors.Is(err, ErrEndpointTimeout))
+	require.True(t, time.Since(start) < time.Second)
+	require.True(t, atomic.LoadInt32(&count) < 5)
+}
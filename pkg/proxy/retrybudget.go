@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+)
+
+//
+// Private constants
+//
+
+const defaultRetryBudgetRefillRate = 1
+
+//
+// Private types
+//
+
+// retryBudget is a token bucket shared by all the deliveries of an
+// EndpointClient, from which every retry takes a token, so that a burst of
+// failing events can't flood a recovering endpoint with retries.
+type retryBudget struct {
+	size float64
+
+	// rate is the number of tokens added to the bucket per second
+	rate float64
+
+	clock Clock
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// take takes a token from the budget and returns whether there was one. A
+// nil budget is unlimited.
+func (b *retryBudget) take() bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.clock.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.size {
+		b.tokens = b.size
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+
+	return true
+}
+
+//
+// Private functions
+//
+
+// newRetryBudget returns a full budget of the given size, or nil if size is
+// zero, which disables the budget.
+func newRetryBudget(size int, rate float64, clock Clock) *retryBudget {
+	if size <= 0 {
+		return nil
+	}
+	if rate <= 0 {
+		rate = defaultRetryBudgetRefillRate
+	}
+
+	return &retryBudget{
+		size:   float64(size),
+		rate:   rate,
+		clock:  clock,
+		tokens: float64(size),
+		last:   clock.Now(),
+	}
+}
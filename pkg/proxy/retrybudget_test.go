@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/stripe-cli/pkg/proxy/proxytest"
+)
+
+func TestRetryBudget(t *testing.T) {
+	clock := proxytest.NewFakeClock(time.Now())
+	budget := newRetryBudget(2, 100, clock)
+
+	require.True(t, budget.take())
+	require.True(t, budget.take())
+	require.False(t, budget.take())
+
+	// The budget refills over time
+	clock.Advance(20 * time.Millisecond)
+	require.True(t, budget.take())
+
+	var unlimited *retryBudget
+	require.True(t, unlimited.take())
+}
+
+func TestPostRetryBudget(t *testing.T) {
+	var attempts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		RetryPolicy:           RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+		RetryBudget:           3,
+		RetryBudgetRefillRate: 0.001,
+	})
+	require.Nil(t, err)
+
+	// The first event uses 2 retries, the second event gets the last one
+	require.Nil(t, client.Post("wh_1", "{}", map[string]string{}))
+	require.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+	require.Nil(t, client.Post("wh_2", "{}", map[string]string{}))
+	require.Equal(t, int32(5), atomic.LoadInt32(&attempts))
+
+	// Once the budget is exhausted, events are only attempted once
+	require.Nil(t, client.Post("wh_3", "{}", map[string]string{}))
+	require.Equal(t, int32(6), atomic.LoadInt32(&attempts))
+}
@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+)
+
+//
+// Public variables
+//
+
+// ErrNoRoute is returned by RoutingEndpointClient.Post when no route matches
+// the type of the event and there is no default URL.
+var ErrNoRoute = errors.New("no route matches the event type")
+
+//
+// Public types
+//
+
+// EventTypeRoute sends the events whose type matches Pattern to URL. Patterns
+// support the same wildcards as the list of events of an EndpointClient.
+type EventTypeRoute struct {
+	Pattern string
+	URL     string
+}
+
+// RoutingEndpointClient dispatches each event to a single local endpoint
+// chosen from its type.
+type RoutingEndpointClient struct {
+	routes []*route
+
+	// defaultClient receives the events that no route matches. It may be nil.
+	defaultClient *EndpointClient
+
+	// clients holds every client once, even if several routes share it
+	clients []*EndpointClient
+}
+
+// SupportsEventType returns whether a route, or the default URL, accepts the
+// event type.
+func (c *RoutingEndpointClient) SupportsEventType(connect bool, eventType string) bool {
+	client := c.clientFor(eventType)
+
+	return client != nil && client.SupportsEventType(connect, eventType)
+}
+
+// Post sends a message to the local endpoint of the route matching the type
+// of the event.
+func (c *RoutingEndpointClient) Post(webhookID string, body string, headers map[string]string) error {
+	return c.PostWithContext(context.Background(), webhookID, body, headers)
+}
+
+// PostWithContext is like Post but aborts the request when the context is
+// canceled. It returns ErrNoRoute if no route matches the event.
+func (c *RoutingEndpointClient) PostWithContext(ctx context.Context, webhookID string, body string, headers map[string]string) error {
+	client := c.clientFor(parseStripeEvent(body).Type)
+	if client == nil {
+		return ErrNoRoute
+	}
+
+	return client.PostWithContext(ctx, webhookID, body, headers)
+}
+
+// Close closes all the clients concurrently, as EndpointClient.Close does,
+// and returns the total number of canceled requests.
+func (c *RoutingEndpointClient) Close(ctx context.Context) int {
+	return closeAll(ctx, c.clients)
+}
+
+// clientFor returns the client of the first route matching the event type,
+// or the default client.
+func (c *RoutingEndpointClient) clientFor(eventType string) *EndpointClient {
+	for _, r := range c.routes {
+		if matchesEventType(r.events, eventType) {
+			return r.client
+		}
+	}
+
+	return c.defaultClient
+}
+
+//
+// Public functions
+//
+
+// NewRoutingEndpointClient returns a new RoutingEndpointClient. Routes are
+// tried in order and the first one matching the event type wins. Events that
+// no route matches are sent to defaultURL, unless it is empty. Each endpoint
+// gets its own copy of the configuration, and its own DedupFile and
+// DeadLetterFile as for NewMultiEndpointClient.
+func NewRoutingEndpointClient(routes []EventTypeRoute, defaultURL string, connect bool, cfg *EndpointConfig) (*RoutingEndpointClient, error) {
+	if cfg == nil {
+		cfg = &EndpointConfig{}
+	}
+
+	c := &RoutingEndpointClient{}
+
+	byURL := make(map[string]*EndpointClient)
+	getClient := func(url string) (*EndpointClient, error) {
+		if client, ok := byURL[url]; ok {
+			return client, nil
+		}
+
+		clientCfg := *cfg
+		withEndpointFiles(&clientCfg, url)
+		client, err := NewEndpointClient(url, connect, []string{"*"}, &clientCfg)
+		if err != nil {
+			return nil, err
+		}
+		byURL[url] = client
+		c.clients = append(c.clients, client)
+
+		return client, nil
+	}
+
+	for _, r := range routes {
+		client, err := getClient(r.URL)
+		if err != nil {
+			return nil, err
+		}
+		c.routes = append(c.routes, &route{
+			events: convertToMap([]string{r.Pattern}),
+			client: client,
+		})
+	}
+
+	if defaultURL != "" {
+		client, err := getClient(defaultURL)
+		if err != nil {
+			return nil, err
+		}
+		c.defaultClient = client
+	}
+
+	return c, nil
+}
+
+//
+// Private types
+//
+
+type route struct {
+	events map[string]bool
+	client *EndpointClient
+}
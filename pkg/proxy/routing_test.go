@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoutingEndpointClient(t *testing.T) {
+	var mu sync.Mutex
+	received := make(map[string][]string)
+	newServer := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			received[name] = append(received[name], r.Header.Get("X-Event-Type"))
+		}))
+	}
+
+	payments := newServer("payments")
+	defer payments.Close()
+	crm := newServer("crm")
+	defer crm.Close()
+	fallback := newServer("default")
+	defer fallback.Close()
+
+	client, err := NewRoutingEndpointClient([]EventTypeRoute{
+		{Pattern: "payment_intent.*", URL: payments.URL},
+		{Pattern: "customer.created", URL: payments.URL},
+		{Pattern: "customer.*", URL: crm.URL},
+	}, fallback.URL, false, nil)
+	require.Nil(t, err)
+	require.Len(t, client.clients, 3)
+
+	for _, eventType := range []string{"payment_intent.succeeded", "customer.created", "customer.updated", "charge.succeeded"} {
+		require.True(t, client.SupportsEventType(false, eventType))
+		err = client.Post("wh_123", `{"type":"`+eventType+`"}`, map[string]string{"X-Event-Type": eventType})
+		require.Nil(t, err)
+	}
+
+	require.Equal(t, []string{"payment_intent.succeeded", "customer.created"}, received["payments"])
+	require.Equal(t, []string{"customer.updated"}, received["crm"])
+	require.Equal(t, []string{"charge.succeeded"}, received["default"])
+}
+
+func TestRoutingEndpointClientNoRoute(t *testing.T) {
+	client, err := NewRoutingEndpointClient([]EventTypeRoute{
+		{Pattern: "customer.*", URL: "http://localhost"},
+	}, "", false, nil)
+	require.Nil(t, err)
+
+	require.False(t, client.SupportsEventType(false, "charge.succeeded"))
+	require.False(t, client.SupportsEventType(true, "customer.created"))
+
+	err = client.Post("wh_123", `{"type":"charge.succeeded"}`, map[string]string{})
+	require.Equal(t, ErrNoRoute, err)
+}
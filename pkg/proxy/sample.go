@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+//
+// Private types
+//
+
+// sampler randomly picks the events that are forwarded.
+type sampler struct {
+	rate float64
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// keep returns whether the next event is forwarded. A nil sampler keeps every
+// event.
+func (s *sampler) keep() bool {
+	if s == nil {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.rnd.Float64() < s.rate
+}
+
+//
+// Private functions
+//
+
+// newSampler returns a sampler keeping the given fraction of events, or nil
+// if the rate is zero or at least 1. A non-zero seed makes the sampling
+// reproducible.
+func newSampler(rate float64, seed int64) *sampler {
+	if rate <= 0 || rate >= 1 {
+		return nil
+	}
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	return &sampler{
+		rate: rate,
+		rnd:  rand.New(rand.NewSource(seed)), // #nosec G404
+	}
+}
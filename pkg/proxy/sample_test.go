@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSampler(t *testing.T) {
+	require.Nil(t, newSampler(0, 0))
+	require.Nil(t, newSampler(1, 0))
+
+	var disabled *sampler
+	require.True(t, disabled.keep())
+
+	sample := func(seed int64) []bool {
+		s := newSampler(0.5, seed)
+		kept := make([]bool, 100)
+		for i := range kept {
+			kept[i] = s.keep()
+		}
+		return kept
+	}
+
+	// Seeded samplers are reproducible
+	require.Equal(t, sample(42), sample(42))
+
+	kept := 0
+	for _, k := range sample(42) {
+		if k {
+			kept++
+		}
+	}
+	require.True(t, kept > 25 && kept < 75)
+}
+
+func TestPostSampleRate(t *testing.T) {
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		SampleRate: 0.1,
+		SampleSeed: 1,
+	})
+	require.Nil(t, err)
+
+	for i := 0; i < 200; i++ {
+		require.Nil(t, client.Post("wh_123", "{}", map[string]string{}))
+	}
+
+	forwarded := atomic.LoadInt32(&count)
+	require.True(t, forwarded > 0 && forwarded < 50)
+}
+
+func TestNewEndpointClientInvalidSampleRate(t *testing.T) {
+	_, err := NewEndpointClient("http://localhost", false, []string{"*"}, &EndpointConfig{
+		SampleRate: 1.5,
+	})
+	require.NotNil(t, err)
+}
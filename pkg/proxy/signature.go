@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//
+// Public variables
+//
+
+// ErrSignatureMismatch is returned by EndpointClient.Post when signature
+// verification is enabled and the Stripe-Signature header of the event
+// doesn't match its payload, or is too old.
+var ErrSignatureMismatch = errors.New("the Stripe-Signature header doesn't match the event")
+
+//
+// Private constants
+//
+
+const (
+	signatureHeader = "Stripe-Signature"
+
+	// defaultSignatureTolerance is the maximum age of a signature
+	defaultSignatureTolerance = 5 * time.Minute
+)
+
+//
+// Private functions
+//
+
+// computeSignature returns the v1 signature of the payload, signed at the
+// given time.
+func computeSignature(timestamp time.Time, payload []byte, secret string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(fmt.Sprintf("%d.", timestamp.Unix()))) // #nosec G104
+	mac.Write(payload)                                      // #nosec G104
+
+	return mac.Sum(nil)
+}
+
+// verifySignature checks that the Stripe-Signature header contains a v1
+// signature of the payload computed with one of the secrets, and that the
+// signature is no older than the tolerance, unless the tolerance is zero. It
+// returns the index of the secret that matched.
+func verifySignature(header string, payload []byte, secrets []string, tolerance time.Duration, now time.Time) (int, error) {
+	timestamp, signatures, err := parseSignatureHeader(header)
+	if err != nil {
+		return -1, err
+	}
+
+	if tolerance > 0 && now.Sub(timestamp) > tolerance {
+		return -1, fmt.Errorf("%w: timestamp is outside the tolerance window", ErrSignatureMismatch)
+	}
+
+	for i, secret := range secrets {
+		expected := computeSignature(timestamp, payload, secret)
+		for _, signature := range signatures {
+			if hmac.Equal(expected, signature) {
+				return i, nil
+			}
+		}
+	}
+
+	return -1, ErrSignatureMismatch
+}
+
+// parseSignatureHeader extracts the timestamp and the v1 signatures of a
+// Stripe-Signature header of the form "t=<timestamp>,v1=<signature>,...".
+func parseSignatureHeader(header string) (time.Time, [][]byte, error) {
+	var timestamp time.Time
+	signatures := make([][]byte, 0)
+
+	for _, pair := range strings.Split(header, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		switch parts[0] {
+		case "t":
+			unix, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil {
+				return time.Time{}, nil, fmt.Errorf("%w: invalid timestamp", ErrSignatureMismatch)
+			}
+			timestamp = time.Unix(unix, 0)
+		case "v1":
+			signature, err := hex.DecodeString(parts[1])
+			if err != nil {
+				continue
+			}
+			signatures = append(signatures, signature)
+		}
+	}
+
+	if timestamp.IsZero() || len(signatures) == 0 {
+		return time.Time{}, nil, fmt.Errorf("%w: missing timestamp or signature", ErrSignatureMismatch)
+	}
+
+	return timestamp, signatures, nil
+}
+
+// headerValue looks up a header in a map of headers, ignoring case.
+func headerValue(headers map[string]string, name string) string {
+	if v, ok := headers[name]; ok {
+		return v
+	}
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testSecret = "whsec_test"
+
+func signatureHeaderFor(timestamp time.Time, payload string, secret string) string {
+	return fmt.Sprintf("t=%d,v1=%s", timestamp.Unix(), hex.EncodeToString(computeSignature(timestamp, []byte(payload), secret)))
+}
+
+func TestVerifySignature(t *testing.T) {
+	now := time.Now()
+	payload := []byte(`{"id":"evt_123"}`)
+	valid := signatureHeaderFor(now, string(payload), testSecret)
+
+	index, err := verifySignature(valid, payload, []string{testSecret}, defaultSignatureTolerance, now)
+	require.Nil(t, err)
+	require.Equal(t, 0, index)
+
+	// Additional signatures, e.g. during a secret rotation, are accepted
+	other := signatureHeaderFor(now, string(payload), "whsec_other")
+	rotated := fmt.Sprintf("%s,v1=%s", other, hex.EncodeToString(computeSignature(now, payload, testSecret)))
+	_, err = verifySignature(rotated, payload, []string{testSecret}, defaultSignatureTolerance, now)
+	require.Nil(t, err)
+
+	// So are signatures matching any of the secrets
+	index, err = verifySignature(other, payload, []string{testSecret, "whsec_other"}, defaultSignatureTolerance, now)
+	require.Nil(t, err)
+	require.Equal(t, 1, index)
+
+	tests := map[string]string{
+		"wrong secret":      other,
+		"mutated payload":   signatureHeaderFor(now, `{"id":"evt_456"}`, testSecret),
+		"expired":           signatureHeaderFor(now.Add(-10*time.Minute), string(payload), testSecret),
+		"missing header":    "",
+		"no v1 signature":   fmt.Sprintf("t=%d,v0=abcdef", now.Unix()),
+		"invalid timestamp": "t=abc,v1=abcdef",
+	}
+	for name, header := range tests {
+		_, err := verifySignature(header, payload, []string{testSecret}, defaultSignatureTolerance, now)
+		require.True(t, errors.Is(err, ErrSignatureMismatch), name)
+	}
+}
+
+func TestPostVerifySignature(t *testing.T) {
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "stripe-cli-proxy")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	deadLetterFile := filepath.Join(dir, "dead_letters.jsonl")
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		VerifySignature: true,
+		Secret:          testSecret,
+		DeadLetterFile:  deadLetterFile,
+	})
+	require.Nil(t, err)
+
+	body := `{"id":"evt_123"}`
+
+	err = client.Post("wh_123", body, map[string]string{
+		"stripe-signature": signatureHeaderFor(time.Now(), body, testSecret),
+	})
+	require.Nil(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&count))
+
+	err = client.Post("wh_123", `{"id":"evt_456"}`, map[string]string{
+		"Stripe-Signature": signatureHeaderFor(time.Now(), body, testSecret),
+	})
+	require.True(t, errors.Is(err, ErrSignatureMismatch))
+	require.Equal(t, int32(1), atomic.LoadInt32(&count))
+
+	// Rejected events aren't worth replaying
+	_, err = ioutil.ReadFile(deadLetterFile)
+	require.NotNil(t, err)
+}
+
+func TestPostVerifySignatureSecrets(t *testing.T) {
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		VerifySignature: true,
+		Secrets:         []string{"whsec_old", testSecret},
+	})
+	require.Nil(t, err)
+
+	body := `{"id":"evt_123"}`
+
+	for _, secret := range []string{"whsec_old", testSecret} {
+		err = client.Post("wh_123", body, map[string]string{
+			"Stripe-Signature": signatureHeaderFor(time.Now(), body, secret),
+		})
+		require.Nil(t, err)
+	}
+	require.Equal(t, int32(2), atomic.LoadInt32(&count))
+
+	err = client.Post("wh_123", body, map[string]string{
+		"Stripe-Signature": signatureHeaderFor(time.Now(), body, "whsec_other"),
+	})
+	require.True(t, errors.Is(err, ErrSignatureMismatch))
+	require.Equal(t, int32(2), atomic.LoadInt32(&count))
+}
+
+func TestNewEndpointClientVerifySignatureWithoutSecret(t *testing.T) {
+	_, err := NewEndpointClient("http://localhost", false, []string{"*"}, &EndpointConfig{
+		VerifySignature: true,
+	})
+	require.NotNil(t, err)
+}
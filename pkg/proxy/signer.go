@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+//
+// Public types
+//
+
+// Signer signs the requests forwarded by an EndpointClient, for endpoints
+// that verify a signature scheme other than the one of the events they
+// receive from Stripe, e.g. a Svix-style signature or a custom HMAC. Sign is
+// called with the final body, before it is compressed if CompressRequests is
+// set, and the headers of every attempt, right before the BeforePost hook,
+// and sets the signature headers. If it returns an
+// error, the request isn't sent and Post returns that error without
+// retrying. Implementations must be safe for concurrent use.
+type Signer interface {
+	Sign(body []byte, headers http.Header) error
+}
+
+// StripeSigner signs requests like Stripe signs events, with a
+// Stripe-Signature header holding a timestamp and a v1 signature computed
+// with Secret, e.g. to re-sign events with the secret of an endpoint after
+// transforming them. Every attempt is signed with a new timestamp, from the
+// Clock of the EndpointClient.
+type StripeSigner struct {
+	Secret string
+}
+
+// Sign replaces the Stripe-Signature header with a signature of the body.
+func (s StripeSigner) Sign(body []byte, headers http.Header) error {
+	return s.signAt(time.Now(), body, headers)
+}
+
+//
+// Private types
+//
+
+// timestampSigner is implemented by the signers whose signatures hold a
+// timestamp, which the EndpointClient takes from its clock.
+type timestampSigner interface {
+	signAt(timestamp time.Time, body []byte, headers http.Header) error
+}
+
+//
+// Private functions
+//
+
+// sign signs the request with the Signer.
+func (c *EndpointClient) sign(body []byte, headers http.Header) error {
+	if signer, ok := c.cfg.Signer.(timestampSigner); ok {
+		return signer.signAt(c.clock.Now(), body, headers)
+	}
+
+	return c.cfg.Signer.Sign(body, headers)
+}
+
+func (s StripeSigner) signAt(timestamp time.Time, body []byte, headers http.Header) error {
+	if s.Secret == "" {
+		return errors.New("a secret is required to sign requests")
+	}
+
+	signature := computeSignature(timestamp, body, s.Secret)
+	headers.Set(signatureHeader, fmt.Sprintf("t=%d,v1=%s", timestamp.Unix(), hex.EncodeToString(signature)))
+
+	return nil
+}
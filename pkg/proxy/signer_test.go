@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/stripe-cli/pkg/proxy/proxytest"
+)
+
+type hmacSigner struct {
+	secret string
+}
+
+func (s hmacSigner) Sign(body []byte, headers http.Header) error {
+	mac := hmac.New(sha256.New, []byte(s.secret))
+	mac.Write([]byte(headers.Get("Webhook-Id") + ".")) // #nosec G104
+	mac.Write(body)                                    // #nosec G104
+	headers.Set("Webhook-Signature", "v1,"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	return nil
+}
+
+type signerFunc func(body []byte, headers http.Header) error
+
+func (f signerFunc) Sign(body []byte, headers http.Header) error {
+	return f(body, headers)
+}
+
+func TestPostStripeSigner(t *testing.T) {
+	var rcvSignature string
+	var rcvBody []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rcvSignature = r.Header.Get("Stripe-Signature")
+		rcvBody, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		Transform: func(eventType string, body []byte) ([]byte, error) {
+			return []byte(`{"transformed":true}`), nil
+		},
+		Signer: StripeSigner{Secret: "whsec_endpoint"},
+	})
+	require.Nil(t, err)
+
+	// The original signature is replaced with a signature of the final body
+	require.Nil(t, client.Post("wh_123", `{"type":"charge.succeeded"}`, map[string]string{
+		"Stripe-Signature": "t=1,v1=abc",
+	}))
+	require.Equal(t, `{"transformed":true}`, string(rcvBody))
+
+	index, err := verifySignature(rcvSignature, rcvBody, []string{"whsec_endpoint"}, time.Minute, time.Now())
+	require.Nil(t, err)
+	require.Equal(t, 0, index)
+
+	require.NotNil(t, StripeSigner{}.Sign(nil, http.Header{}))
+}
+
+func TestPostStripeSignerCompressed(t *testing.T) {
+	var rcvSignature string
+	var rcvBody []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rcvSignature = r.Header.Get("Stripe-Signature")
+		reader, err := gzip.NewReader(r.Body)
+		require.Nil(t, err)
+		rcvBody, _ = ioutil.ReadAll(reader)
+	}))
+	defer ts.Close()
+
+	clock := proxytest.NewFakeClock(time.Date(2019, 9, 1, 12, 0, 0, 0, time.UTC))
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		CompressRequests:     true,
+		CompressionThreshold: 1,
+		Signer:               StripeSigner{Secret: "whsec_endpoint"},
+		Clock:                clock,
+	})
+	require.Nil(t, err)
+
+	// The signature is of the uncompressed body, with the time of the clock
+	require.Nil(t, client.Post("wh_123", `{"type":"charge.succeeded"}`, map[string]string{}))
+	require.Equal(t, `{"type":"charge.succeeded"}`, string(rcvBody))
+	require.Contains(t, rcvSignature, "t=1567339200,")
+
+	_, err = verifySignature(rcvSignature, rcvBody, []string{"whsec_endpoint"}, time.Minute, clock.Now())
+	require.Nil(t, err)
+}
+
+func TestPostCustomSigner(t *testing.T) {
+	var rcvSignature string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rcvSignature = r.Header.Get("Webhook-Signature")
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		StaticHeaders: map[string]string{"Webhook-Id": "msg_123"},
+		Signer:        hmacSigner{secret: "secret"},
+	})
+	require.Nil(t, err)
+
+	// The signer sees the headers applied before it
+	require.Nil(t, client.Post("wh_123", "{}", map[string]string{}))
+	expected := hmacSigner{secret: "secret"}
+	headers := http.Header{"Webhook-Id": {"msg_123"}}
+	require.Nil(t, expected.Sign([]byte("{}"), headers))
+	require.Equal(t, headers.Get("Webhook-Signature"), rcvSignature)
+}
+
+func TestPostSignerError(t *testing.T) {
+	var count int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count++
+	}))
+	defer ts.Close()
+
+	signErr := errors.New("no key")
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		Signer: signerFunc(func(body []byte, headers http.Header) error {
+			return signErr
+		}),
+		RetryPolicy: RetryPolicy{MaxAttempts: 3},
+	})
+	require.Nil(t, err)
+
+	require.Equal(t, signErr, client.Post("wh_123", "{}", map[string]string{}))
+	require.Equal(t, 0, count)
+}
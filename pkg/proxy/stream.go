@@ -0,0 +1,242 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"reflect"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//
+// Public functions
+//
+
+// PostReader is like PostWithContext but streams the body to the endpoint
+// instead of holding a copy of it in memory. contentLength is the length of
+// the body, or -1 if unknown, in which case the body is sent with chunked
+// encoding.
+//
+// Streamed events are only retried if the body implements io.Seeker, and
+// as their payload isn't parsed, the event type reported to the response
+// handler and the metrics sinks is empty. Options that need the whole
+// payload, such as signature verification, compression, deduplication or
+// the buffer, and generally the options that aren't known to apply to
+// streamed bodies, make PostReader read the body in memory and fall back to
+// PostWithContext.
+func (c *EndpointClient) PostReader(ctx context.Context, webhookID string, body io.Reader, contentLength int64, headers map[string]string) error {
+	if c.needsPayload(contentLength) {
+		payload, err := c.readPayload(body)
+		if err != nil {
+			return err
+		}
+
+		return c.PostWithContext(ctx, webhookID, string(payload), headers)
+	}
+
+	ctx, cancel, err := c.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.end(cancel)
+
+	if c.cfg.MaxRequestBodyBytes > 0 && contentLength > c.cfg.MaxRequestBodyBytes {
+		c.cfg.Log.WithFields(log.Fields{
+			"prefix":     "proxy.EndpointClient.PostReader",
+			"webhook_id": webhookID,
+			"body_size":  contentLength,
+		}).Errorf("Not forwarding event, body exceeds %d bytes", c.cfg.MaxRequestBodyBytes)
+		return ErrBodyTooLarge
+	}
+
+	if !c.sampler.keep() {
+		c.logSkipped(log.Fields{
+			"prefix":     "proxy.EndpointClient.PostReader",
+			"webhook_id": webhookID,
+			"reason":     "sampled out",
+		}, "Event was sampled out")
+		return nil
+	}
+
+	_, err = c.forward(ctx, &delivery{
+		webhookID:     webhookID,
+		evt:           &stripeEvent{},
+		headers:       headers,
+		stream:        body,
+		contentLength: contentLength,
+	})
+
+	return err
+}
+
+//
+// Private functions
+//
+
+// needsPayload returns whether the configuration requires the whole payload
+// of an event to be in memory before it is forwarded. Only the options that
+// are known to apply to streamed bodies may be set, so that any other option,
+// including the options added later, makes PostReader fall back to
+// PostWithContext.
+func (c *EndpointClient) needsPayload(contentLength int64) bool {
+	if contentLength < 0 && (c.cfg.MaxRequestBodyBytes > 0 || c.cfg.MaxInFlightBytes > 0) {
+		return true
+	}
+
+	cfg := *c.cfg
+	clearStreamableOptions(&cfg)
+
+	return !reflect.DeepEqual(cfg, EndpointConfig{})
+}
+
+// clearStreamableOptions zeroes the options of the configuration that don't
+// depend on the payload of the events, and the options that only tune
+// options that do.
+func clearStreamableOptions(cfg *EndpointConfig) {
+	// Transport
+	cfg.HTTPClient = nil
+	cfg.ClientCertFile = ""
+	cfg.ClientKeyFile = ""
+	cfg.CACertFile = ""
+	cfg.InsecureSkipVerify = false
+	cfg.AllowRemote = false
+	cfg.ForceHTTP2 = false
+	cfg.ProxyURL = ""
+	cfg.MaxIdleConns = 0
+	cfg.MaxIdleConnsPerHost = 0
+	cfg.DialTimeout = 0
+	cfg.IdleConnTimeout = 0
+	cfg.ConnMaxLifetime = 0
+	cfg.CookieJar = nil
+	cfg.Cookies = nil
+	cfg.DisableKeepAlives = false
+	cfg.FollowRedirects = false
+	cfg.MaxRedirects = 0
+	cfg.Timeout = 0
+	cfg.PayloadTimeout = PayloadTimeout{}
+	cfg.PerAttemptTimeout = 0
+	cfg.OverallTimeout = 0
+	cfg.DryRun = false
+
+	// Responses
+	cfg.Log = nil
+	cfg.ResponseHandler = nil
+	cfg.FailOnNon2xx = false
+	cfg.SuccessStatusCodes = nil
+	cfg.ClassifyResponse = nil
+	cfg.OnError = nil
+	cfg.MaxResponseBodyBytes = 0
+	cfg.DisableResponseDecompression = false
+
+	// Delivery
+	cfg.ExcludedEvents = nil
+	cfg.LogSkipped = false
+	cfg.Clock = nil
+	cfg.RetryPolicy = RetryPolicy{}
+	cfg.RetryBudget = 0
+	cfg.RetryBudgetRefillRate = 0
+	cfg.CircuitBreaker = CircuitBreakerPolicy{}
+	cfg.OnFailureStreak = nil
+	cfg.FailureStreakThreshold = 0
+	cfg.RateLimit = 0
+	cfg.RateLimitBurst = 0
+	cfg.Workers = 0
+	cfg.MaxInFlight = 0
+	cfg.MaxConcurrentPerHost = 0
+	cfg.MaxInFlightBytes = 0
+	cfg.MaxRequestBodyBytes = 0
+	cfg.SampleRate = 0
+	cfg.SampleSeed = 0
+	cfg.KeepaliveInterval = 0
+
+	// Observability
+	cfg.MetricsSink = nil
+	cfg.PrometheusRegistry = nil
+	cfg.TracerProvider = nil
+	cfg.Propagator = nil
+	cfg.RecordSink = nil
+	cfg.SlowThreshold = 0
+	cfg.OnSlowResponse = nil
+
+	// Requests
+	cfg.StaticHeaders = nil
+	cfg.HeaderAllowlist = nil
+	cfg.HeaderDenylist = nil
+	cfg.Method = ""
+	cfg.DisableDefaultContentType = false
+	cfg.RequestIDHeader = ""
+	cfg.AttemptHeader = ""
+	cfg.DisableAccountHeader = false
+	cfg.BasicAuthUser = ""
+	cfg.BasicAuthPassword = ""
+	cfg.BearerToken = ""
+	cfg.UserAgent = ""
+	cfg.ProbeMethod = ""
+	cfg.ProbePath = ""
+	cfg.ProbeStatusCodes = nil
+
+	// Tuning of options that need the payload
+	cfg.BufferOverflow = 0
+	cfg.APIClient = nil
+	cfg.ForwardUnexpandedEvents = false
+	cfg.CompressionThreshold = 0
+	cfg.MaxBatchSize = 0
+	cfg.MaxBatchWait = 0
+	cfg.DumpSignature = false
+	cfg.Secret = ""
+	cfg.Secrets = nil
+	cfg.DedupTTL = 0
+	cfg.DedupFile = ""
+	cfg.EventCreatedHeader = ""
+}
+
+// readPayload reads the body in memory. When MaxRequestBodyBytes is set, at
+// most one byte over the limit is read so that the event is still rejected.
+func (c *EndpointClient) readPayload(body io.Reader) ([]byte, error) {
+	if c.cfg.MaxRequestBodyBytes > 0 {
+		body = io.LimitReader(body, c.cfg.MaxRequestBodyBytes+1)
+	}
+
+	return ioutil.ReadAll(body)
+}
+
+// size returns the size of the request body, or -1 if unknown.
+func (d *delivery) size() int {
+	if d.stream != nil {
+		return int(d.contentLength)
+	}
+
+	return len(d.body)
+}
+
+// rewindable returns whether the request body can be sent again.
+func (d *delivery) rewindable() bool {
+	if d.stream == nil {
+		return true
+	}
+
+	_, ok := d.stream.(io.Seeker)
+	return ok
+}
+
+// requestBody returns the body of the next attempt, rewinding the stream if
+// it was already sent.
+func (d *delivery) requestBody() (io.Reader, error) {
+	if d.stream == nil {
+		return bytes.NewReader(d.body), nil
+	}
+
+	// Streams are only sent again if they are rewindable
+	if seeker, ok := d.stream.(io.Seeker); ok && d.streamed {
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+	d.streamed = true
+
+	// The transport closes the body once it was sent, which mustn't close a
+	// stream that may have to be rewound
+	return ioutil.NopCloser(d.stream), nil
+}
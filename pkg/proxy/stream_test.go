@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostReader(t *testing.T) {
+	var contentLength int64
+	var transferEncoding []string
+	var received string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentLength = r.ContentLength
+		transferEncoding = r.TransferEncoding
+		buf, _ := ioutil.ReadAll(r.Body)
+		received = string(buf)
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{})
+	require.Nil(t, err)
+
+	payload := `{"id":"evt_123","type":"invoice.paid"}`
+
+	err = client.PostReader(context.Background(), "wh_123", strings.NewReader(payload), int64(len(payload)), map[string]string{})
+	require.Nil(t, err)
+	require.Equal(t, int64(len(payload)), contentLength)
+	require.Equal(t, payload, received)
+
+	// Bodies of unknown length are chunked
+	err = client.PostReader(context.Background(), "wh_123", ioutil.NopCloser(strings.NewReader(payload)), -1, map[string]string{})
+	require.Nil(t, err)
+	require.Equal(t, []string{"chunked"}, transferEncoding)
+	require.Equal(t, payload, received)
+}
+
+func TestPostReaderRetries(t *testing.T) {
+	var attempts int32
+	var received string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf, _ := ioutil.ReadAll(r.Body)
+		received = string(buf)
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		RetryPolicy: RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+	})
+	require.Nil(t, err)
+
+	payload := `{"id":"evt_123"}`
+
+	// Seekable bodies are rewound before a retry
+	err = client.PostReader(context.Background(), "wh_123", bytes.NewReader([]byte(payload)), int64(len(payload)), map[string]string{})
+	require.Nil(t, err)
+	require.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+	require.Equal(t, payload, received)
+
+	// Other bodies are sent once
+	atomic.StoreInt32(&attempts, 0)
+	err = client.PostReader(context.Background(), "wh_123", ioutil.NopCloser(strings.NewReader(payload)), int64(len(payload)), map[string]string{})
+	require.Nil(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+}
+
+func TestPostReaderFallsBackToPost(t *testing.T) {
+	var idempotencyKey string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idempotencyKey = r.Header.Get(idempotencyKeyHeader)
+	}))
+	defer ts.Close()
+
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		IdempotencyKeys:     true,
+		MaxRequestBodyBytes: 32,
+	})
+	require.Nil(t, err)
+
+	payload := `{"id":"evt_123"}`
+
+	err = client.PostReader(context.Background(), "wh_123", strings.NewReader(payload), -1, map[string]string{})
+	require.Nil(t, err)
+	require.Equal(t, "evt_123", idempotencyKey)
+
+	// The payload is still checked against the size limit
+	large := `{"id":"evt_123","data":"` + strings.Repeat("x", 32) + `"}`
+	err = client.PostReader(context.Background(), "wh_123", strings.NewReader(large), -1, map[string]string{})
+	require.Equal(t, ErrBodyTooLarge, err)
+	err = client.PostReader(context.Background(), "wh_123", strings.NewReader(large), int64(len(large)), map[string]string{})
+	require.Equal(t, ErrBodyTooLarge, err)
+}
+
+func TestNeedsPayload(t *testing.T) {
+	newClient := func(cfg *EndpointConfig) *EndpointClient {
+		client, err := NewEndpointClient("http://localhost", false, []string{"*"}, cfg)
+		require.Nil(t, err)
+		return client
+	}
+
+	client := newClient(&EndpointConfig{
+		RetryPolicy:         RetryPolicy{MaxAttempts: 3},
+		CircuitBreaker:      CircuitBreakerPolicy{FailureThreshold: 5},
+		StaticHeaders:       map[string]string{"X-Test": "1"},
+		MaxRequestBodyBytes: 1024,
+		FollowRedirects:     true,
+	})
+	require.False(t, client.needsPayload(100))
+	require.True(t, client.needsPayload(-1))
+
+	// Options that aren't known to apply to streamed bodies need the payload
+	require.True(t, newClient(&EndpointConfig{
+		TimeoutForEvent: func(string) time.Duration { return time.Second },
+	}).needsPayload(100))
+	require.True(t, newClient(&EndpointConfig{IdempotencyKeys: true}).needsPayload(100))
+	require.True(t, newClient(&EndpointConfig{PartitionKeyFunc: func(string) string { return "" }}).needsPayload(100))
+}
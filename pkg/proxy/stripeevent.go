@@ -1,6 +1,9 @@
 package proxy
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+)
 
 //
 // Private types
@@ -9,15 +12,32 @@ import "fmt"
 // stripeEvent is a minimal representation of a Stripe `event` object, used
 // to extract the event's ID and type for logging purposes.
 type stripeEvent struct {
-	ID      string `json:"id"`
-	Type    string `json:"type"`
-	Account string `json:"account"`
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Account  string `json:"account"`
+	Livemode bool   `json:"livemode"`
+	Created  int64  `json:"created"`
+
+	// RelatedObject is the object a thin event refers to
+	RelatedObject *relatedObject `json:"related_object"`
+}
+
+type relatedObject struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	URL  string `json:"url"`
 }
 
 func (e *stripeEvent) isConnect() bool {
 	return e.Account != ""
 }
 
+// isThin returns whether the event is a thin event, which only refers to the
+// object it is about instead of including it.
+func (e *stripeEvent) isThin() bool {
+	return e.RelatedObject != nil && e.RelatedObject.URL != ""
+}
+
 func (e *stripeEvent) urlForEventID() string {
 	url := ""
 	if e.isConnect() {
@@ -37,3 +57,16 @@ func (e *stripeEvent) urlForEventType() string {
 	}
 	return url
 }
+
+//
+// Private functions
+//
+
+// parseStripeEvent extracts the fields of a stripeEvent from an event
+// payload. A malformed payload yields an empty event.
+func parseStripeEvent(payload string) *stripeEvent {
+	evt := &stripeEvent{}
+	json.Unmarshal([]byte(payload), evt) // #nosec G104
+
+	return evt
+}
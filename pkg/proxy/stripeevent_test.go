@@ -29,3 +29,14 @@ func TestURLForEventType(t *testing.T) {
 	evt2 := &stripeEvent{ID: "evt_123", Type: "customer.created", Account: "acct_123"}
 	require.Equal(t, "https://dashboard.stripe.com/acct_123/test/events?type=customer.created", evt2.urlForEventType())
 }
+
+func TestParseStripeEvent(t *testing.T) {
+	evt := parseStripeEvent(`{"id": "evt_123", "type": "customer.created", "account": "acct_123", "created": 1600000000, "data": {}}`)
+	require.Equal(t, "evt_123", evt.ID)
+	require.Equal(t, "customer.created", evt.Type)
+	require.Equal(t, "acct_123", evt.Account)
+	require.Equal(t, int64(1600000000), evt.Created)
+
+	evt = parseStripeEvent("not json")
+	require.Equal(t, "", evt.Type)
+}
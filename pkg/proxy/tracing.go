@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//
+// Private constants
+//
+
+const (
+	tracerName = "github.com/stripe/stripe-cli/pkg/proxy"
+
+	attemptSpanName = "proxy.EndpointClient.Post"
+)
+
+//
+// Private functions
+//
+
+func newTracer(provider trace.TracerProvider) trace.Tracer {
+	if provider == nil {
+		return nil
+	}
+
+	return provider.Tracer(tracerName)
+}
+
+// propagator returns the propagator of the trace context, which defaults to
+// the W3C trace context headers.
+func (c *EndpointClient) propagator() propagation.TextMapPropagator {
+	if c.cfg.Propagator == nil {
+		return propagation.TraceContext{}
+	}
+
+	return c.cfg.Propagator
+}
+
+// startSpan starts the client span of an attempt and returns the context of
+// its request. It returns a nil span when tracing is disabled.
+func (c *EndpointClient) startSpan(ctx context.Context, d *delivery, t *target, attempt int) (context.Context, trace.Span) {
+	if c.tracer == nil {
+		return ctx, nil
+	}
+
+	ctx, span := c.tracer.Start(ctx, attemptSpanName, trace.WithSpanKind(trace.SpanKindClient))
+	span.SetAttributes(
+		attribute.String("http.method", c.method()),
+		attribute.String("http.url", t.url),
+		attribute.String("stripe.event_type", d.evt.Type),
+		attribute.String("stripe.webhook_id", d.webhookID),
+		attribute.Int("retry_count", attempt-1),
+	)
+
+	return ctx, span
+}
+
+// injectTraceContext sets the headers propagating the trace context of the
+// attempt's span, if tracing is enabled.
+func (c *EndpointClient) injectTraceContext(ctx context.Context, header http.Header) {
+	if c.tracer == nil {
+		return
+	}
+
+	c.propagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// endSpan records the outcome of an attempt and ends its span, whose status
+// is an error for transport errors and 4xx or 5xx responses, as for any HTTP
+// client span.
+func endSpan(span trace.Span, resp *http.Response, err error) {
+	if span == nil {
+		return
+	}
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+		if resp.StatusCode >= http.StatusBadRequest {
+			span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
+		}
+	}
+	span.End()
+}
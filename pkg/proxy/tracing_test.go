@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestPostTracing(t *testing.T) {
+	var traceparents []string
+	attempts := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparents = append(traceparents, r.Header.Get("traceparent"))
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)),
+		RetryPolicy:    RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond},
+	})
+	require.Nil(t, err)
+
+	require.Nil(t, client.Post("wh_123", `{"type":"invoice.paid"}`, map[string]string{}))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	require.Len(t, traceparents, 2)
+
+	for i, span := range spans {
+		attributes := spanAttributes(span)
+		require.Equal(t, attemptSpanName, span.Name())
+		require.Equal(t, trace.SpanKindClient, span.SpanKind())
+		require.Equal(t, "invoice.paid", attributes["stripe.event_type"])
+		require.Equal(t, ts.URL, attributes["http.url"])
+		require.Equal(t, int64(i), attributes["retry_count"])
+
+		// Each attempt propagates the context of its own span
+		require.True(t, strings.Contains(traceparents[i], span.SpanContext().SpanID().String()))
+	}
+	require.Equal(t, int64(http.StatusServiceUnavailable), spanAttributes(spans[0])["http.status_code"])
+	require.Equal(t, codes.Error, spans[0].Status().Code)
+	require.Equal(t, int64(http.StatusOK), spanAttributes(spans[1])["http.status_code"])
+	require.Equal(t, codes.Unset, spans[1].Status().Code)
+}
+
+func TestPostTracingError(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	client, err := NewEndpointClient("http://localhost:0", false, []string{"*"}, &EndpointConfig{
+		TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)),
+	})
+	require.Nil(t, err)
+
+	require.NotNil(t, client.Post("wh_123", `{"type":"invoice.paid"}`, map[string]string{}))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	require.Equal(t, codes.Error, spans[0].Status().Code)
+	require.Len(t, spans[0].Events(), 1)
+	require.Equal(t, "exception", spans[0].Events()[0].Name)
+}
+
+func TestPostTracingBeforeHooks(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	var signed, hooked string
+	recorder := tracetest.NewSpanRecorder()
+	client, err := NewEndpointClient(ts.URL, false, []string{"*"}, &EndpointConfig{
+		TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)),
+		Signer:         traceparentSigner{traceparent: &signed},
+		BeforePost: func(req *http.Request, body []byte) error {
+			hooked = req.Header.Get("traceparent")
+			return nil
+		},
+	})
+	require.Nil(t, err)
+
+	require.Nil(t, client.Post("wh_123", `{"type":"invoice.paid"}`, map[string]string{}))
+
+	// The hooks see the trace context, e.g. to sign it along with the body
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	require.Contains(t, signed, spans[0].SpanContext().SpanID().String())
+	require.Equal(t, signed, hooked)
+}
+
+// traceparentSigner records the traceparent header of the request it signs.
+type traceparentSigner struct {
+	traceparent *string
+}
+
+func (s traceparentSigner) Sign(body []byte, headers http.Header) error {
+	*s.traceparent = headers.Get("traceparent")
+	return nil
+}
+
+func spanAttributes(span sdktrace.ReadOnlySpan) map[string]interface{} {
+	attributes := make(map[string]interface{})
+	for _, kv := range span.Attributes() {
+		attributes[string(kv.Key)] = kv.Value.AsInterface()
+	}
+
+	return attributes
+}
@@ -0,0 +1,299 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+//
+// Private constants
+//
+
+const (
+	defaultMaxIdleConns        = 100
+	defaultMaxIdleConnsPerHost = 100
+	defaultIdleConnTimeout     = 90 * time.Second
+
+	defaultDialTimeout = 30 * time.Second
+	dialKeepAlive      = 30 * time.Second
+
+	// tlsHandshakeTimeout bounds the TLS handshakes of HTTP/2 only
+	// connections, as http.DefaultTransport does for the others
+	tlsHandshakeTimeout = 10 * time.Second
+)
+
+//
+// Private types
+//
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// http2OnlyTransport sends the requests to https URLs over TLS and those to
+// http URLs with prior knowledge (h2c), so that the targets of a client may
+// mix both.
+type http2OnlyTransport struct {
+	tls       *http2.Transport
+	cleartext *http2.Transport
+}
+
+func (t *http2OnlyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "http" {
+		return t.cleartext.RoundTrip(req)
+	}
+
+	return t.tls.RoundTrip(req)
+}
+
+func (t *http2OnlyTransport) CloseIdleConnections() {
+	t.tls.CloseIdleConnections()
+	t.cleartext.CloseIdleConnections()
+}
+
+// lifetimeConn is a connection that refuses to send requests once it is
+// older than its lifetime. As nothing was written, the transport then closes
+// it and transparently sends the request again on a new connection, as long
+// as the request body can be rewound.
+type lifetimeConn struct {
+	net.Conn
+	clock  Clock
+	expiry time.Time
+}
+
+func (c *lifetimeConn) Write(b []byte) (int, error) {
+	if !c.clock.Now().Before(c.expiry) {
+		return 0, errConnExpired
+	}
+
+	return c.Conn.Write(b)
+}
+
+//
+// Private variables
+//
+
+var errConnExpired = errors.New("connection exceeded its maximum lifetime")
+
+//
+// Private functions
+//
+
+// newHTTPClient builds the HTTP client used by an EndpointClient when none
+// was provided in its configuration. If socketPath is set, all connections
+// are made to the Unix domain socket at that path. The client doesn't follow
+// redirects, which EndpointClient does itself when FollowRedirects is set.
+func newHTTPClient(cfg *EndpointConfig, socketPath string) (*http.Client, error) {
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	if cfg.TimeoutForEvent != nil {
+		// The timeout applies to each attempt instead, see attemptContext
+		timeout = 0
+	}
+
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	proxyURL, err := parseProxyURL(cfg.ProxyURL)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL != nil && (cfg.ForceHTTP2 || socketPath != "") {
+		return nil, errors.New("a proxy can't be used with HTTP/2 only or Unix domain socket endpoints")
+	}
+
+	dialer := newDialer(cfg)
+	dial := dialer.DialContext
+	if socketPath != "" {
+		dial = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socketPath)
+		}
+	}
+	if cfg.ConnMaxLifetime > 0 && !cfg.ForceHTTP2 {
+		dial = withConnMaxLifetime(dial, newClock(cfg.Clock), cfg.ConnMaxLifetime)
+	}
+
+	if cfg.ForceHTTP2 {
+		return &http.Client{
+			Timeout:       timeout,
+			Transport:     newHTTP2Transport(tlsConfig, dial, cfg.DisableResponseDecompression),
+			Jar:           cfg.CookieJar,
+			CheckRedirect: noRedirects,
+		}, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.DialContext = dial
+	// The clone of http.DefaultTransport would otherwise send the requests
+	// through the proxy of the HTTPS_PROXY and HTTP_PROXY variables
+	transport.Proxy = nil
+	if proxyURL != nil {
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	transport.MaxIdleConns = defaultMaxIdleConns
+	if cfg.MaxIdleConns > 0 {
+		transport.MaxIdleConns = cfg.MaxIdleConns
+	}
+	transport.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	if cfg.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	}
+	transport.IdleConnTimeout = defaultIdleConnTimeout
+	if cfg.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = cfg.IdleConnTimeout
+	}
+	transport.DisableKeepAlives = cfg.DisableKeepAlives
+	transport.DisableCompression = cfg.DisableResponseDecompression
+
+	return &http.Client{
+		Timeout:       timeout,
+		Transport:     transport,
+		Jar:           cfg.CookieJar,
+		CheckRedirect: noRedirects,
+	}, nil
+}
+
+// newDialer builds the dialer of the transport, whose timeout only bounds
+// the time it takes to connect to the endpoint.
+func newDialer(cfg *EndpointConfig) *net.Dialer {
+	timeout := cfg.DialTimeout
+	if timeout <= 0 {
+		timeout = defaultDialTimeout
+	}
+
+	return &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: dialKeepAlive,
+	}
+}
+
+// withConnMaxLifetime wraps the dial function so that connections are
+// recycled once they are older than the lifetime on the clock.
+func withConnMaxLifetime(dial dialFunc, clock Clock, lifetime time.Duration) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		return &lifetimeConn{Conn: conn, clock: clock, expiry: clock.Now().Add(lifetime)}, nil
+	}
+}
+
+// newHTTP2Transport builds a transport that only speaks HTTP/2, negotiated
+// with ALPN for https URLs and with prior knowledge (h2c) for http URLs.
+// Connections are dialed with the context of the request that needs them.
+func newHTTP2Transport(tlsConfig *tls.Config, dial dialFunc, disableCompression bool) *http2OnlyTransport {
+	return &http2OnlyTransport{
+		tls: &http2.Transport{
+			DisableCompression: disableCompression,
+			TLSClientConfig:    tlsConfig,
+			DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+				return dialTLS(ctx, dial, network, addr, cfg)
+			},
+		},
+		cleartext: &http2.Transport{
+			AllowHTTP:          true,
+			DisableCompression: disableCompression,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dial(ctx, network, addr)
+			},
+		},
+	}
+}
+
+// dialTLS dials a TLS connection, whose handshake is bounded by
+// tlsHandshakeTimeout and by the deadline of the context, if it is earlier.
+func dialTLS(ctx context.Context, dial dialFunc, network, addr string, cfg *tls.Config) (net.Conn, error) {
+	conn, err := dial(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(tlsHandshakeTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetDeadline(deadline) // #nosec G104
+
+	tlsConn := tls.Client(conn, cfg)
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close() // #nosec G104
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{}) // #nosec G104
+
+	return tlsConn, nil
+}
+
+// newTLSConfig builds the TLS configuration of the transport from the
+// configuration. It returns nil if no TLS options are set.
+func newTLSConfig(cfg *EndpointConfig) (*tls.Config, error) {
+	if cfg.ClientCertFile == "" && cfg.ClientKeyFile == "" && cfg.CACertFile == "" && !cfg.InsecureSkipVerify {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: cfg.InsecureSkipVerify, // #nosec G402
+	}
+
+	if cfg.ClientCertFile != "" || cfg.ClientKeyFile != "" {
+		if cfg.ClientCertFile == "" || cfg.ClientKeyFile == "" {
+			return nil, errors.New("both a client certificate and a client key must be provided")
+		}
+
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCertFile, cfg.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if cfg.CACertFile != "" {
+		pem, err := ioutil.ReadFile(cfg.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no valid certificate found in %s", cfg.CACertFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+// parseProxyURL parses the URL of the proxy requests go through. It returns
+// nil if no proxy is configured.
+func parseProxyURL(rawURL string) (*url.URL, error) {
+	if rawURL == "" {
+		return nil, nil
+	}
+
+	proxyURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5":
+		return proxyURL, nil
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q, must be http, https or socks5", proxyURL.Scheme)
+	}
+}
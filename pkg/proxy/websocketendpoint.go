@@ -0,0 +1,682 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/stripe/stripe-cli/pkg/version"
+)
+
+//
+// Public variables
+//
+
+// ErrEventRejected is matched by the errors of WebSocketEndpointClient.Post
+// when the endpoint answered the event with a nack.
+var ErrEventRejected = errors.New("event was rejected by the endpoint")
+
+//
+// Public types
+//
+
+// WebSocketEndpointConfig contains the optional configuration parameters of
+// a WebSocketEndpointClient.
+type WebSocketEndpointConfig struct {
+	// Dialer connects to the endpoint. Defaults to a dialer with a 10s
+	// handshake timeout.
+	Dialer *ws.Dialer
+
+	// Header holds the headers of the handshake, e.g. to authenticate with
+	// the endpoint. The User-Agent defaults to the one of EndpointClient.
+	Header http.Header
+
+	Log *log.Logger
+
+	// ResponseHandler is handed the acks of the endpoint as responses, with
+	// the status code, headers and body of the ack.
+	ResponseHandler EndpointResponseHandler
+
+	// Timeout bounds the delivery of every event, from waiting for the
+	// connection to receiving its ack, including the resends of events whose
+	// connection dropped before they were acked. Defaults to 30s.
+	Timeout time.Duration
+
+	// Reconnect is the backoff between two attempts at connecting to the
+	// endpoint, which are made until the client is closed. MaxAttempts and
+	// the settings about retried failures aren't used. Defaults to an
+	// exponential backoff from 100ms to 30s with a 20% jitter.
+	Reconnect RetryPolicy
+
+	// InsecureSkipVerify disables the verification of the certificate of a
+	// wss:// endpoint, as EndpointConfig.InsecureSkipVerify does. It is only
+	// used when Dialer is not set.
+	InsecureSkipVerify bool
+
+	// LogSkipped logs every event that isn't forwarded because of its type
+	// or its connect mode, at info level along with the reason, as
+	// EndpointConfig.LogSkipped does.
+	LogSkipped bool
+
+	// AllowRemote silences the warning logged when the client is created
+	// and the host of the URL resolves to an address that is neither a
+	// loopback nor a private address, as EndpointConfig.AllowRemote does.
+	AllowRemote bool
+
+	// Clock, if set, is the source of time used for the backoff between two
+	// attempts at connecting, e.g. a fake clock in tests. Defaults to the
+	// system clock.
+	Clock Clock
+}
+
+// WebSocketEndpointClient forwards events to a local endpoint over a
+// persistent WebSocket connection instead of HTTP requests, for frameworks
+// that consume events pushed to them. It implements the same Endpoint
+// interface as EndpointClient so that it can be used by a Proxy.
+//
+// Every event is sent as a text message holding a JSON object:
+//
+//	{"type": "webhook_event", "id": "...", "webhook_id": "...",
+//	 "event_type": "...", "headers": {...}, "payload": "..."}
+//
+// where payload is the body of the event. The endpoint answers with a message
+// with the same id, either {"type": "ack", "id": "..."} once it processed the
+// event, optionally with a "status", "headers" and "body" handed to the
+// response handler, or {"type": "nack", "id": "...", "error": "..."} if it
+// failed. The client connects on the first use, and reconnects with a backoff
+// when the connection drops, resending the events that weren't acked.
+type WebSocketEndpointClient struct {
+	// URL is the ws:// or wss:// URL of the endpoint
+	URL string
+
+	connect bool
+	events  map[string]bool
+
+	// Optional configuration parameters
+	cfg *WebSocketEndpointConfig
+
+	clock Clock
+
+	// remoteChecked is closed once warnIfRemote is done, whose resolution is
+	// abandoned once backgroundCtx is canceled by Close
+	remoteChecked    chan struct{}
+	backgroundCtx    context.Context
+	cancelBackground context.CancelFunc
+
+	startRun sync.Once
+
+	// connMu protects conn, connected, pending and lastErr. conn is nil while
+	// disconnected, and connected is closed once it is set.
+	connMu    sync.Mutex
+	conn      *ws.Conn
+	connected chan struct{}
+	pending   map[string]chan *webSocketAck
+	lastErr   error
+
+	// writeMu serializes the writes to the connection
+	writeMu sync.Mutex
+
+	// drainMu protects closed and the registration of outstanding events
+	drainMu          sync.Mutex
+	closed           bool
+	outstanding      sync.WaitGroup
+	outstandingCount int32
+
+	// done is closed by Close to stop reconnecting and to cancel the
+	// outstanding events
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+// SupportsEventType returns whether events of the type are forwarded, as
+// EndpointClient.SupportsEventType does.
+func (c *WebSocketEndpointClient) SupportsEventType(connect bool, eventType string) bool {
+	reason := c.skipReason(connect, eventType)
+	if reason != "" && c.cfg.LogSkipped {
+		c.cfg.Log.WithFields(log.Fields{
+			"prefix":     "proxy.WebSocketEndpointClient.SupportsEventType",
+			"url":        c.URL,
+			"event_type": eventType,
+			"reason":     reason,
+		}).Info("Event skipped")
+	}
+
+	return reason == ""
+}
+
+// Post sends the event to the endpoint and waits for its ack.
+func (c *WebSocketEndpointClient) Post(webhookID string, body string, headers map[string]string) error {
+	return c.PostWithContext(context.Background(), webhookID, body, headers)
+}
+
+// PostWithContext is like Post but gives up when the context is done, in
+// which case the context's error is returned. ErrClosed is returned once the
+// client was closed.
+func (c *WebSocketEndpointClient) PostWithContext(ctx context.Context, webhookID string, body string, headers map[string]string) error {
+	ctx, cancel, err := c.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.end(cancel)
+
+	msg := &webSocketEvent{
+		Type:      "webhook_event",
+		WebhookID: webhookID,
+		EventType: parseStripeEvent(body).Type,
+		Headers:   headers,
+		Payload:   body,
+	}
+	if msg.ID, err = newRequestID(); err != nil {
+		return err
+	}
+
+	fields := log.Fields{
+		"prefix":     "proxy.WebSocketEndpointClient.Post",
+		"webhook_id": webhookID,
+		"message_id": msg.ID,
+	}
+
+	timeoutCtx, cancelTimeout := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancelTimeout()
+
+	for {
+		ack, err := c.send(timeoutCtx, msg)
+		if errors.Is(err, errWebSocketDisconnected) {
+			c.cfg.Log.WithFields(fields).Debug("Connection dropped before the event was acked, resending it")
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			c.cfg.Log.WithFields(fields).Errorf("Failed to send event to WebSocket endpoint, error = %v", err)
+			return err
+		}
+
+		if ack.Type == "nack" {
+			c.cfg.Log.WithFields(fields).Errorf("WebSocket endpoint rejected event, error = %s", ack.Error)
+			return fmt.Errorf("%w: %s", ErrEventRejected, ack.Error)
+		}
+
+		c.cfg.Log.WithFields(fields).Debug("WebSocket endpoint acked event")
+		c.cfg.ResponseHandler.ProcessResponse(webhookID, ack.response())
+
+		return nil
+	}
+}
+
+// Ping checks that the client is connected to the endpoint, as
+// EndpointClient.Ping does for HTTP endpoints. It returns an error if the
+// client didn't connect and answer the ping within a few seconds.
+func (c *WebSocketEndpointClient) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, defaultProbeTimeout)
+	defer cancel()
+
+	conn, err := c.connection(ctx)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(c.cfg.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	return classifyError(conn.WriteControl(ws.PingMessage, nil, deadline))
+}
+
+// Close stops accepting new events and waits for the outstanding events to be
+// acked. When the context is done before that, the outstanding events are
+// canceled. It then closes the connection, and returns the number of events
+// that were canceled.
+func (c *WebSocketEndpointClient) Close(ctx context.Context) int {
+	c.drainMu.Lock()
+	c.closed = true
+	c.drainMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		c.outstanding.Wait()
+		close(done)
+	}()
+
+	canceled := 0
+	select {
+	case <-done:
+	case <-ctx.Done():
+		canceled = int(atomic.LoadInt32(&c.outstandingCount))
+	}
+	c.doneOnce.Do(func() { close(c.done) })
+	c.cancelBackground()
+	<-done
+
+	c.connMu.Lock()
+	conn := c.conn
+	c.connMu.Unlock()
+	if conn != nil {
+		c.writeMu.Lock()
+		conn.WriteControl(ws.CloseMessage, ws.FormatCloseMessage(ws.CloseNormalClosure, ""), time.Now().Add(time.Second)) // #nosec G104
+		c.writeMu.Unlock()
+		conn.Close() // #nosec G104
+	}
+
+	if canceled > 0 {
+		c.cfg.Log.WithFields(log.Fields{
+			"prefix":   "proxy.WebSocketEndpointClient.Close",
+			"canceled": canceled,
+		}).Warn("Timed out waiting for outstanding events to WebSocket endpoint, canceled them")
+	}
+
+	return canceled
+}
+
+//
+// Public functions
+//
+
+// NewWebSocketEndpointClient returns a new WebSocketEndpointClient. It returns
+// an error if the URL isn't a ws:// or wss:// URL.
+func NewWebSocketEndpointClient(url string, connect bool, events []string, cfg *WebSocketEndpointConfig) (*WebSocketEndpointClient, error) {
+	if !strings.HasPrefix(url, "ws://") && !strings.HasPrefix(url, "wss://") {
+		return nil, fmt.Errorf("invalid WebSocket endpoint URL %q: expected a ws or wss scheme", url)
+	}
+
+	if cfg == nil {
+		cfg = &WebSocketEndpointConfig{}
+	}
+	if cfg.Dialer == nil {
+		cfg.Dialer = &ws.Dialer{HandshakeTimeout: defaultWebSocketHandshakeTimeout}
+		if cfg.InsecureSkipVerify {
+			cfg.Dialer.TLSClientConfig = &tls.Config{
+				InsecureSkipVerify: true, // #nosec G402
+			}
+		}
+	}
+	if cfg.Log == nil {
+		cfg.Log = &log.Logger{Out: ioutil.Discard}
+	}
+	if cfg.ResponseHandler == nil {
+		cfg.ResponseHandler = EndpointResponseHandlerFunc(func(string, *http.Response) {})
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.Reconnect.BaseDelay == 0 {
+		cfg.Reconnect = RetryPolicy{
+			BaseDelay: defaultReconnectBaseDelay,
+			MaxDelay:  defaultReconnectMaxDelay,
+			Jitter:    0.2,
+		}
+	}
+
+	c := &WebSocketEndpointClient{
+		URL:           url,
+		connect:       connect,
+		events:        convertToMap(events),
+		cfg:           cfg,
+		clock:         newClock(cfg.Clock),
+		remoteChecked: make(chan struct{}),
+		connected:     make(chan struct{}),
+		pending:       make(map[string]chan *webSocketAck),
+		done:          make(chan struct{}),
+	}
+	c.backgroundCtx, c.cancelBackground = context.WithCancel(context.Background())
+	c.warnIfRemote()
+
+	return c, nil
+}
+
+//
+// Private constants
+//
+
+const (
+	defaultWebSocketHandshakeTimeout = 10 * time.Second
+
+	defaultReconnectBaseDelay = 100 * time.Millisecond
+	defaultReconnectMaxDelay  = 30 * time.Second
+)
+
+//
+// Private types
+//
+
+// webSocketEvent is the message carrying an event to the endpoint
+type webSocketEvent struct {
+	Type      string            `json:"type"`
+	ID        string            `json:"id"`
+	WebhookID string            `json:"webhook_id"`
+	EventType string            `json:"event_type"`
+	Headers   map[string]string `json:"headers"`
+	Payload   string            `json:"payload"`
+}
+
+// webSocketAck is the answer of the endpoint to an event
+type webSocketAck struct {
+	Type    string            `json:"type"`
+	ID      string            `json:"id"`
+	Error   string            `json:"error"`
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+// response returns the ack as a response for the response handler.
+func (a *webSocketAck) response() *http.Response {
+	status := a.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	header := http.Header{}
+	for k, v := range a.Headers {
+		header.Set(k, v)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(strings.NewReader(a.Body)),
+		ContentLength: int64(len(a.Body)),
+	}
+}
+
+//
+// Private variables
+//
+
+// errWebSocketDisconnected is returned by send when the connection dropped
+// before the event was acked
+var errWebSocketDisconnected = errors.New("disconnected from WebSocket endpoint")
+
+//
+// Private functions
+//
+
+// skipReason returns why events with the connect mode and type aren't
+// forwarded, or an empty string if they are.
+func (c *WebSocketEndpointClient) skipReason(connect bool, eventType string) string {
+	if connect != c.connect {
+		if c.connect {
+			return "only Connect events are forwarded"
+		}
+		return "Connect events aren't forwarded"
+	}
+
+	if !matchesEventType(c.events, eventType) {
+		return "not in the list of events"
+	}
+
+	return ""
+}
+
+// send writes the message to the connection and waits for its ack. It
+// returns errWebSocketDisconnected if the connection dropped before that.
+func (c *WebSocketEndpointClient) send(ctx context.Context, msg *webSocketEvent) (*webSocketAck, error) {
+	conn, err := c.connection(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	acked := make(chan *webSocketAck, 1)
+	c.connMu.Lock()
+	if c.conn != conn {
+		c.connMu.Unlock()
+		return nil, errWebSocketDisconnected
+	}
+	c.pending[msg.ID] = acked
+	c.connMu.Unlock()
+
+	defer func() {
+		c.connMu.Lock()
+		delete(c.pending, msg.ID)
+		c.connMu.Unlock()
+	}()
+
+	deadline, _ := ctx.Deadline()
+	c.writeMu.Lock()
+	conn.SetWriteDeadline(deadline) // #nosec G104
+	err = conn.WriteJSON(msg)
+	c.writeMu.Unlock()
+	if err != nil {
+		// The read loop then fails and reconnects
+		conn.Close() // #nosec G104
+		c.disconnected(conn, err)
+		return nil, errWebSocketDisconnected
+	}
+
+	select {
+	case ack, ok := <-acked:
+		if !ok {
+			return nil, errWebSocketDisconnected
+		}
+		return ack, nil
+	case <-ctx.Done():
+		return nil, classifyError(ctx.Err())
+	}
+}
+
+// connection returns the current connection, starting the connection loop
+// on first use and waiting for the connection if there is none.
+func (c *WebSocketEndpointClient) connection(ctx context.Context) (*ws.Conn, error) {
+	c.startRun.Do(func() { go c.run() })
+
+	for {
+		c.connMu.Lock()
+		conn, connected := c.conn, c.connected
+		c.connMu.Unlock()
+		if conn != nil {
+			return conn, nil
+		}
+
+		select {
+		case <-connected:
+		case <-c.done:
+			return nil, ErrClosed
+		case <-ctx.Done():
+			c.connMu.Lock()
+			lastErr := c.lastErr
+			c.connMu.Unlock()
+			if lastErr != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, &endpointError{kind: ErrEndpointUnreachable, err: lastErr}
+			}
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// run connects to the endpoint and reads the acks of the events, reconnecting
+// with a backoff when the connection drops, until the client is closed.
+func (c *WebSocketEndpointClient) run() {
+	if c.cfg.InsecureSkipVerify {
+		c.cfg.Log.WithFields(log.Fields{
+			"prefix": "proxy.WebSocketEndpointClient.run",
+		}).Warn("Certificate verification is disabled for the WebSocket endpoint")
+	}
+
+	var delay time.Duration
+	for attempt := 1; ; attempt++ {
+		conn, err := c.dial()
+		if err != nil {
+			delay = c.cfg.Reconnect.nextDelay(attempt, delay)
+			c.cfg.Log.WithFields(log.Fields{
+				"prefix":  "proxy.WebSocketEndpointClient.run",
+				"url":     c.URL,
+				"attempt": attempt,
+			}).Debugf("Failed to connect to WebSocket endpoint, retrying in %v, error = %v", delay, err)
+
+			c.connMu.Lock()
+			c.lastErr = err
+			c.connMu.Unlock()
+
+			select {
+			case <-c.clock.After(delay):
+				continue
+			case <-c.done:
+				return
+			}
+		}
+
+		c.cfg.Log.WithFields(log.Fields{
+			"prefix": "proxy.WebSocketEndpointClient.run",
+			"url":    c.URL,
+		}).Debug("Connected to WebSocket endpoint")
+
+		c.connMu.Lock()
+		select {
+		case <-c.done:
+			// Close already closed the previous connection, if any
+			c.connMu.Unlock()
+			conn.Close() // #nosec G104
+			return
+		default:
+		}
+		c.conn = conn
+		c.lastErr = nil
+		close(c.connected)
+		c.connMu.Unlock()
+
+		err = c.readAcks(conn)
+		conn.Close() // #nosec G104
+		c.disconnected(conn, err)
+
+		select {
+		case <-c.done:
+			return
+		default:
+		}
+
+		c.cfg.Log.WithFields(log.Fields{
+			"prefix": "proxy.WebSocketEndpointClient.run",
+			"url":    c.URL,
+		}).Warnf("Disconnected from WebSocket endpoint, reconnecting, error = %v", err)
+		attempt, delay = 0, 0
+	}
+}
+
+// disconnected forgets the connection after it failed, failing the events
+// waiting for their ack on it so that they are resent. It does nothing if the
+// connection was already replaced.
+func (c *WebSocketEndpointClient) disconnected(conn *ws.Conn, err error) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+
+	if c.conn != conn {
+		return
+	}
+
+	c.conn = nil
+	c.lastErr = err
+	c.connected = make(chan struct{})
+	for id, acked := range c.pending {
+		close(acked)
+		delete(c.pending, id)
+	}
+}
+
+// dial makes a single attempt at connecting to the endpoint, which is
+// canceled if the client is closed.
+func (c *WebSocketEndpointClient) dial() (*ws.Conn, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-c.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	header := http.Header{}
+	for k, v := range c.cfg.Header {
+		header[k] = v
+	}
+	if header.Get("User-Agent") == "" {
+		header.Set("User-Agent", "StripeCLI-Proxy/"+version.Version)
+	}
+
+	conn, resp, err := c.cfg.Dialer.DialContext(ctx, c.URL, header)
+	if resp != nil {
+		resp.Body.Close() // #nosec G104
+	}
+
+	return conn, err
+}
+
+// readAcks hands the acks read from the connection to the events waiting for
+// them, until the connection fails.
+func (c *WebSocketEndpointClient) readAcks(conn *ws.Conn) error {
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		var ack webSocketAck
+		if err := json.Unmarshal(data, &ack); err != nil || (ack.Type != "ack" && ack.Type != "nack") {
+			c.cfg.Log.WithFields(log.Fields{
+				"prefix": "proxy.WebSocketEndpointClient.readAcks",
+			}).Warn("Received malformed message from WebSocket endpoint")
+			continue
+		}
+
+		c.connMu.Lock()
+		acked, ok := c.pending[ack.ID]
+		if ok {
+			delete(c.pending, ack.ID)
+		}
+		c.connMu.Unlock()
+
+		if ok {
+			acked <- &ack
+		}
+	}
+}
+
+// begin registers an outstanding event, like EndpointClient.begin.
+func (c *WebSocketEndpointClient) begin(ctx context.Context) (context.Context, context.CancelFunc, error) {
+	c.drainMu.Lock()
+	defer c.drainMu.Unlock()
+
+	if c.closed {
+		return nil, nil, ErrClosed
+	}
+
+	c.outstanding.Add(1)
+	atomic.AddInt32(&c.outstandingCount, 1)
+
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-c.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel, nil
+}
+
+func (c *WebSocketEndpointClient) end(cancel context.CancelFunc) {
+	cancel()
+	atomic.AddInt32(&c.outstandingCount, -1)
+	c.outstanding.Done()
+}
@@ -0,0 +1,293 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/stripe-cli/pkg/proxy/proxytest"
+)
+
+// newWebSocketServer starts a WebSocket server calling handle with every
+// event it receives on a connection, along with the number of the
+// connection, and writing the ack it returns, if any. It closes the
+// connection when handle returns nil.
+func newWebSocketServer(t *testing.T, handle func(conn int, evt webSocketEvent) *webSocketAck) *httptest.Server {
+	var conns int32
+	upgrader := ws.Upgrader{}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		n := int(atomic.AddInt32(&conns, 1))
+
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var evt webSocketEvent
+			require.Nil(t, json.Unmarshal(data, &evt))
+
+			ack := handle(n, evt)
+			if ack == nil {
+				return
+			}
+			ack.ID = evt.ID
+			if err := conn.WriteJSON(ack); err != nil {
+				return
+			}
+		}
+	}))
+}
+
+func webSocketURL(ts *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(ts.URL, "http")
+}
+
+func TestWebSocketEndpointPost(t *testing.T) {
+	ts := newWebSocketServer(t, func(conn int, evt webSocketEvent) *webSocketAck {
+		if evt.WebhookID == "wh_nack" {
+			return &webSocketAck{Type: "nack", Error: "boom"}
+		}
+		return &webSocketAck{Type: "ack", Status: http.StatusAccepted, Body: evt.EventType + " " + evt.Headers["Stripe-Signature"]}
+	})
+	defer ts.Close()
+
+	var status int
+	var body string
+	client, err := NewWebSocketEndpointClient(webSocketURL(ts), false, []string{"charge.*"}, &WebSocketEndpointConfig{
+		ResponseHandler: EndpointResponseHandlerFunc(func(webhookID string, resp *http.Response) {
+			buf, _ := ioutil.ReadAll(resp.Body)
+			status = resp.StatusCode
+			body = string(buf)
+		}),
+	})
+	require.Nil(t, err)
+	defer client.Close(context.Background())
+
+	require.True(t, client.SupportsEventType(false, "charge.succeeded"))
+	require.False(t, client.SupportsEventType(false, "invoice.paid"))
+	require.False(t, client.SupportsEventType(true, "charge.succeeded"))
+
+	require.Nil(t, client.Ping(context.Background()))
+
+	err = client.Post("wh_123", `{"id":"evt_123","type":"charge.succeeded"}`, map[string]string{
+		"Stripe-Signature": "t=1,v1=abc",
+	})
+	require.Nil(t, err)
+	require.Equal(t, http.StatusAccepted, status)
+	require.Equal(t, "charge.succeeded t=1,v1=abc", body)
+
+	err = client.Post("wh_nack", `{"id":"evt_123","type":"charge.succeeded"}`, map[string]string{})
+	require.True(t, errors.Is(err, ErrEventRejected))
+	require.Contains(t, err.Error(), "boom")
+}
+
+func TestWebSocketEndpointReconnect(t *testing.T) {
+	// The first connection drops before acking the event
+	ts := newWebSocketServer(t, func(conn int, evt webSocketEvent) *webSocketAck {
+		if conn == 1 {
+			return nil
+		}
+		return &webSocketAck{Type: "ack"}
+	})
+	defer ts.Close()
+
+	client, err := NewWebSocketEndpointClient(webSocketURL(ts), false, []string{"*"}, &WebSocketEndpointConfig{
+		Reconnect: RetryPolicy{BaseDelay: 10 * time.Millisecond},
+	})
+	require.Nil(t, err)
+	defer client.Close(context.Background())
+
+	require.Nil(t, client.Post("wh_123", `{"id":"evt_123"}`, map[string]string{}))
+}
+
+func TestWebSocketEndpointReconnectClock(t *testing.T) {
+	// The first handshake fails, so that the client waits for the backoff
+	var handshakes int32
+	upgrader := ws.Upgrader{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&handshakes, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			var evt webSocketEvent
+			if err := conn.ReadJSON(&evt); err != nil {
+				return
+			}
+			conn.WriteJSON(&webSocketAck{Type: "ack", ID: evt.ID}) // #nosec G104
+		}
+	}))
+	defer ts.Close()
+
+	clock := proxytest.NewFakeClock(time.Date(2019, 9, 1, 12, 0, 0, 0, time.UTC))
+	client, err := NewWebSocketEndpointClient(webSocketURL(ts), false, []string{"*"}, &WebSocketEndpointConfig{
+		Reconnect: RetryPolicy{BaseDelay: time.Minute},
+		Clock:     clock,
+	})
+	require.Nil(t, err)
+	defer client.Close(context.Background())
+
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Post("wh_123", `{"id":"evt_123"}`, map[string]string{})
+	}()
+
+	require.True(t, clock.WaitForWaiters(1, time.Second))
+	clock.Advance(time.Minute - time.Millisecond)
+	select {
+	case err := <-done:
+		t.Fatalf("Post returned before the backoff elapsed: %v", err)
+	default:
+	}
+
+	clock.Advance(time.Millisecond)
+	require.Nil(t, <-done)
+	require.Equal(t, int32(2), atomic.LoadInt32(&handshakes))
+}
+
+func TestWebSocketEndpointInsecureSkipVerify(t *testing.T) {
+	upgrader := ws.Upgrader{}
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			var evt webSocketEvent
+			if err := conn.ReadJSON(&evt); err != nil {
+				return
+			}
+			conn.WriteJSON(&webSocketAck{Type: "ack", ID: evt.ID}) // #nosec G104
+		}
+	}))
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	logger := log.New()
+	logger.Out = &buf
+
+	// The certificate of the test server is self-signed
+	client, err := NewWebSocketEndpointClient(webSocketURL(ts), false, []string{"*"}, &WebSocketEndpointConfig{
+		Timeout: 100 * time.Millisecond,
+	})
+	require.Nil(t, err)
+	require.NotNil(t, client.Post("wh_123", `{"id":"evt_123"}`, map[string]string{}))
+	client.Close(context.Background())
+
+	client, err = NewWebSocketEndpointClient(webSocketURL(ts), false, []string{"*"}, &WebSocketEndpointConfig{
+		InsecureSkipVerify: true,
+		Log:                logger,
+	})
+	require.Nil(t, err)
+	defer client.Close(context.Background())
+
+	require.Nil(t, client.Post("wh_123", `{"id":"evt_123"}`, map[string]string{}))
+	require.Contains(t, buf.String(), "Certificate verification is disabled")
+}
+
+func TestWebSocketEndpointLogSkipped(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New()
+	logger.Out = &buf
+
+	client, err := NewWebSocketEndpointClient("ws://localhost/events", false, []string{"charge.*"}, &WebSocketEndpointConfig{
+		LogSkipped: true,
+		Log:        logger,
+	})
+	require.Nil(t, err)
+	defer client.Close(context.Background())
+
+	require.True(t, client.SupportsEventType(false, "charge.succeeded"))
+	require.False(t, client.SupportsEventType(false, "invoice.paid"))
+	require.False(t, client.SupportsEventType(true, "charge.succeeded"))
+	require.Contains(t, buf.String(), "not in the list of events")
+	require.Contains(t, buf.String(), "Connect events aren't forwarded")
+	require.Equal(t, 2, strings.Count(buf.String(), "Event skipped"))
+}
+
+func TestWebSocketEndpointWarnsRemote(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New()
+	logger.Out = &buf
+
+	client, err := NewWebSocketEndpointClient("ws://203.0.113.10/events", false, []string{"*"}, &WebSocketEndpointConfig{
+		Log: logger,
+	})
+	require.Nil(t, err)
+	<-client.remoteChecked
+	client.Close(context.Background())
+	require.Contains(t, buf.String(), "203.0.113.10")
+
+	buf.Reset()
+	client, err = NewWebSocketEndpointClient("ws://203.0.113.10/events", false, []string{"*"}, &WebSocketEndpointConfig{
+		AllowRemote: true,
+		Log:         logger,
+	})
+	require.Nil(t, err)
+	<-client.remoteChecked
+	client.Close(context.Background())
+	require.NotContains(t, buf.String(), "AllowRemote")
+}
+
+func TestWebSocketEndpointUnreachable(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := webSocketURL(ts)
+	ts.Close()
+
+	client, err := NewWebSocketEndpointClient(url, false, []string{"*"}, &WebSocketEndpointConfig{
+		Timeout:   50 * time.Millisecond,
+		Reconnect: RetryPolicy{BaseDelay: 10 * time.Millisecond},
+	})
+	require.Nil(t, err)
+
+	err = client.Post("wh_123", `{"id":"evt_123"}`, map[string]string{})
+	require.True(t, errors.Is(err, ErrEndpointUnreachable))
+
+	require.Equal(t, 0, client.Close(context.Background()))
+	require.Equal(t, ErrClosed, client.Post("wh_123", `{"id":"evt_123"}`, map[string]string{}))
+}
+
+func TestWebSocketEndpointPingUnreachable(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := webSocketURL(ts)
+	ts.Close()
+
+	client, err := NewWebSocketEndpointClient(url, false, []string{"*"}, &WebSocketEndpointConfig{
+		Reconnect: RetryPolicy{BaseDelay: 10 * time.Millisecond},
+	})
+	require.Nil(t, err)
+	defer client.Close(context.Background())
+
+	// Without a deadline on the context, the ping still gives up
+	err = client.Ping(context.Background())
+	require.True(t, errors.Is(err, ErrEndpointUnreachable))
+}
+
+func TestNewWebSocketEndpointClientInvalidURL(t *testing.T) {
+	_, err := NewWebSocketEndpointClient("http://localhost", false, []string{"*"}, nil)
+	require.NotNil(t, err)
+}